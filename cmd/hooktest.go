@@ -32,6 +32,7 @@ dim hooktest -r ubuntu:xenial
 	}
 
 	hooktestCommand.Flags().BoolVarP(&remoteFlag, "remote", "r", false, "Reads the image used to test hooks from the remote registry")
+	hooktestCommand.Flags().StringVar(&platformFlag, "platform", "", "Platform (os/arch[/variant]) of the remote image when it is available for several platforms")
 	rootCommand.AddCommand(hooktestCommand)
 }
 
@@ -64,8 +65,12 @@ func runHooktest(c *cli.Cli, args []string) error {
 		}
 
 		tag := registry.ParseTag(parsedName)
+		var images []*dim.RegistryImage
+		if images, err = repo.Images(tag); err != nil {
+			return err
+		}
 		var image *dim.RegistryImage
-		if image, err = repo.Image(tag); err != nil {
+		if image, err = registry.SelectPlatform(images, platformFlag); err != nil {
 			return err
 		}
 
@@ -0,0 +1,289 @@
+// Copyright 2016
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"context"
+
+	"github.com/docker/docker/api/types"
+	"github.com/nhurel/dim/cli"
+	"github.com/nhurel/dim/lib"
+	"github.com/nhurel/dim/lib/index"
+	"github.com/nhurel/dim/lib/registry"
+	"github.com/spf13/cobra"
+)
+
+func newIndexCommand(c *cli.Cli, rootCommand *cobra.Command, ctx context.Context) {
+	indexCommand := &cobra.Command{
+		Use:   "index",
+		Short: "Manages the index of dim server",
+	}
+
+	backupCommand := &cobra.Command{
+		Use:   "backup FILE",
+		Short: "Saves a snapshot of the index of a running dim server",
+		Long: `Download a snapshot archive of the index of the dim server, along with its pending notifications.
+The server keeps running while the snapshot is taken.`,
+		Example: `dim index backup --registry-url https://private-registry dim-index.tar.gz`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runIndexBackup(c, args)
+		},
+	}
+
+	restoreCommand := &cobra.Command{
+		Use:   "restore FILE",
+		Short: "Creates the index of a dim server from a snapshot",
+		Long: `Write the index and the pending notifications saved in a snapshot archive to the given paths.
+The dim server must be stopped. Once started with the restored index, it only indexes the changes made on the registry since the snapshot.`,
+		Example: `dim index restore --index-path dim.index --queue-path dim.queue dim-index.tar.gz`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runIndexRestore(args)
+		},
+	}
+	restoreCommand.Flags().StringVar(&indexDir, "index-path", "dim.index", "Directory where the index is restored")
+	restoreCommand.Flags().StringVar(&queuePath, "queue-path", "dim.queue", "File where the pending registry notifications are restored")
+
+	exportCommand := &cobra.Command{
+		Use:   "export [FILE]",
+		Short: "Exports the images indexed by a dim server",
+		Long: `Write all the images indexed by the dim server to FILE, or to the standard output if no file is given.
+Images are written as JSON lines by default, or as CSV with the --format flag. Use the --field flag to only export some fields.
+Lists are written in CSV cells as comma separated values, and maps as comma separated key=value pairs.`,
+		Example: `dim index export --registry-url https://private-registry images.json
+dim index export --format csv --field Name --field Tags --field Created --field Size images.csv`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runIndexExport(c, args)
+		},
+	}
+	exportCommand.Flags().StringVar(&exportFormatFlag, "format", index.JSONFormat, "Export format : json or csv")
+	exportCommand.Flags().StringSliceVar(&exportFieldsFlag, "field", nil, "Field of the images to export. All fields are exported by default")
+
+	importCommand := &cobra.Command{
+		Use:   "import FILE",
+		Short: "Loads exported images into an index",
+		Long: `Index the images of a file written by dim index export in JSON format, with all their fields.
+The images are added to the index stored in --index-path, which is created if needed. The dim server using this index must be stopped.`,
+		Example: `dim index import --index-path dim.index images.json`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runIndexImport(c, args)
+		},
+	}
+	importCommand.Flags().StringVar(&indexDir, "index-path", "dim.index", "Directory of the index to load the images into")
+
+	deadLettersCommand := &cobra.Command{
+		Use:   "deadletters [ID...]",
+		Short: "Lists the registry notifications the dim server failed to apply",
+		Long: `Print the registry notifications that still failed after all their attempts, with their last error.
+Use the --retry flag to submit them again, or the --purge flag to remove them. Only the dead letters with the given IDs are retried or purged, or all of them if no ID is given.`,
+		Example: `dim index deadletters --registry-url https://private-registry
+dim index deadletters --retry 12 15
+dim index deadletters --purge`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runIndexDeadLetters(c, args)
+		},
+	}
+	deadLettersCommand.Flags().BoolVar(&retryFlag, "retry", false, "Submit the dead letters again")
+	deadLettersCommand.Flags().BoolVar(&purgeFlag, "purge", false, "Remove the dead letters")
+	deadLettersCommand.Flags().IntVarP(&widthFlag, "width", "W", 150, "Column width")
+
+	indexCommand.AddCommand(backupCommand, restoreCommand, exportCommand, importCommand, deadLettersCommand)
+	rootCommand.AddCommand(indexCommand)
+}
+
+func runIndexBackup(c *cli.Cli, args []string) error {
+	if len(args) == 0 {
+		return errors.New("snapshot file is missing")
+	}
+	if registryURL == "" {
+		return fmt.Errorf("No registry URL given")
+	}
+
+	var authConfig *types.AuthConfig
+	if username != "" || password != "" {
+		authConfig = &types.AuthConfig{Username: username, Password: password}
+	}
+
+	var client dim.RegistryClient
+	var err error
+	if client, err = registry.New(c, authConfig, registryURL); err != nil {
+		return fmt.Errorf("Failed to connect to registry : %v", err)
+	}
+
+	var f *os.File
+	if f, err = os.Create(args[0]); err != nil {
+		return fmt.Errorf("Failed to create snapshot file : %v", err)
+	}
+	if err = client.BackupIndex(f); err != nil {
+		f.Close()
+		os.Remove(args[0])
+		return fmt.Errorf("Failed to backup index : %v", err)
+	}
+	return f.Close()
+}
+
+func runIndexRestore(args []string) error {
+	if len(args) == 0 {
+		return errors.New("snapshot file is missing")
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		return fmt.Errorf("Failed to open snapshot file : %v", err)
+	}
+	defer f.Close()
+
+	return index.Restore(&index.Config{Directory: indexDir, QueuePath: queuePath}, f)
+}
+
+func runIndexExport(c *cli.Cli, args []string) error {
+	if registryURL == "" {
+		return fmt.Errorf("No registry URL given")
+	}
+
+	var authConfig *types.AuthConfig
+	if username != "" || password != "" {
+		authConfig = &types.AuthConfig{Username: username, Password: password}
+	}
+
+	var client dim.RegistryClient
+	var err error
+	if client, err = registry.New(c, authConfig, registryURL); err != nil {
+		return fmt.Errorf("Failed to connect to registry : %v", err)
+	}
+
+	if len(args) == 0 {
+		return client.ExportIndex(c.Out, exportFormatFlag, exportFieldsFlag)
+	}
+
+	var f *os.File
+	if f, err = os.Create(args[0]); err != nil {
+		return fmt.Errorf("Failed to create export file : %v", err)
+	}
+	if err = client.ExportIndex(f, exportFormatFlag, exportFieldsFlag); err != nil {
+		f.Close()
+		os.Remove(args[0])
+		return err
+	}
+	return f.Close()
+}
+
+func runIndexImport(c *cli.Cli, args []string) error {
+	if len(args) == 0 {
+		return errors.New("file to import is missing")
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		return fmt.Errorf("Failed to open file to import : %v", err)
+	}
+	defer f.Close()
+
+	var idx *index.Index
+	if idx, err = index.New(&index.Config{Directory: indexDir}, nil); err != nil {
+		return err
+	}
+	defer idx.Close()
+
+	var count int
+	if count, err = idx.Import(f); err != nil {
+		return fmt.Errorf("Failed to import images (%d imported) : %v", count, err)
+	}
+	fmt.Fprintf(c.Err, "%d images imported\n", count)
+	return nil
+}
+
+func runIndexDeadLetters(c *cli.Cli, args []string) error {
+	if retryFlag && purgeFlag {
+		return errors.New("--retry and --purge cannot be used together")
+	}
+	if registryURL == "" {
+		return fmt.Errorf("No registry URL given")
+	}
+
+	ids := make([]uint64, 0, len(args))
+	for _, a := range args {
+		id, err := strconv.ParseUint(a, 10, 64)
+		if err != nil {
+			return fmt.Errorf("Invalid dead letter ID %s", a)
+		}
+		ids = append(ids, id)
+	}
+
+	var authConfig *types.AuthConfig
+	if username != "" || password != "" {
+		authConfig = &types.AuthConfig{Username: username, Password: password}
+	}
+
+	var client dim.RegistryClient
+	var err error
+	if client, err = registry.New(c, authConfig, registryURL); err != nil {
+		return fmt.Errorf("Failed to connect to registry : %v", err)
+	}
+
+	var count int
+	switch {
+	case retryFlag:
+		if count, err = client.RetryDeadLetters(ids); err != nil {
+			return fmt.Errorf("Failed to retry dead letters : %v", err)
+		}
+		fmt.Fprintf(c.Err, "%d dead letters submitted again\n", count)
+		return nil
+	case purgeFlag:
+		if count, err = client.PurgeDeadLetters(ids); err != nil {
+			return fmt.Errorf("Failed to purge dead letters : %v", err)
+		}
+		fmt.Fprintf(c.Err, "%d dead letters removed\n", count)
+		return nil
+	}
+
+	var letters []*dim.DeadLetter
+	if letters, err = client.DeadLetters(); err != nil {
+		return fmt.Errorf("Failed to get dead letters : %v", err)
+	}
+	if len(letters) == 0 {
+		fmt.Fprintln(c.Err, "No dead letter found")
+		return nil
+	}
+	return printDeadLetters(c, letters)
+}
+
+// printDeadLetters prints the dead letters as a table
+func printDeadLetters(c *cli.Cli, letters []*dim.DeadLetter) error {
+	printer := cli.NewTabPrinter(c.Out, c.In, cli.WithWidth(widthFlag))
+	printer.Append([]string{"ID", "Action", "Repository", "Tag", "Attempts", "Error"})
+	for _, l := range letters {
+		repository := l.Job.Repository
+		if l.Job.Registry != "" {
+			repository = l.Job.Registry + "/" + repository
+		}
+		tag := l.Job.Tag
+		if tag == "" {
+			tag = l.Job.Digest.String()
+		}
+		printer.Append([]string{strconv.FormatUint(l.ID, 10), string(l.Job.Action), repository, tag, strconv.Itoa(l.Attempts), l.LastError})
+	}
+	return printer.PrintAll(false)
+}
+
+var (
+	exportFormatFlag string
+	exportFieldsFlag []string
+	retryFlag        bool
+	purgeFlag        bool
+)
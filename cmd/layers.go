@@ -0,0 +1,100 @@
+// Copyright 2016
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"context"
+
+	"github.com/docker/docker/reference"
+	"github.com/docker/go-units"
+	"github.com/nhurel/dim/cli"
+	"github.com/nhurel/dim/lib"
+	"github.com/nhurel/dim/lib/registry"
+	"github.com/nhurel/dim/lib/utils"
+	"github.com/spf13/cobra"
+)
+
+func newLayersCommand(c *cli.Cli, rootCommand *cobra.Command, ctx context.Context) {
+	layersCommand := &cobra.Command{
+		Use:   "layers IMAGE",
+		Short: "Lists the layers of an image",
+		Long: `Print the layers of an image hosted on the private registry.
+For each layer, dim lists the other images of the registry that share it (requires dim server)`,
+		Example: `dim layers private-registry/debian:jessie`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runLayers(c, args)
+		},
+	}
+
+	layersCommand.Flags().IntVarP(&widthFlag, "width", "W", 150, "Column width")
+	layersCommand.Flags().StringVar(&platformFlag, "platform", "", "Platform (os/arch[/variant]) of the image when it is available for several platforms")
+	rootCommand.AddCommand(layersCommand)
+}
+
+func runLayers(c *cli.Cli, args []string) error {
+	if len(args) == 0 {
+		return errors.New("image name is missing")
+	}
+
+	var client dim.RegistryClient
+	var parsedName reference.Named
+	var err error
+	if client, parsedName, err = connectRegistry(c, args[0]); err != nil {
+		return err
+	}
+
+	var image *dim.RegistryImage
+	if image, err = client.Image(parsedName, platformFlag); err != nil {
+		return fmt.Errorf("Failed to get image : %v", err)
+	}
+
+	name := parsedName.Name()[strings.Index(parsedName.Name(), "/")+1:]
+	tag := registry.ParseTag(parsedName)
+
+	printer := cli.NewTabPrinter(c.Out, c.In, cli.WithWidth(widthFlag))
+	printer.Append([]string{"#", "Layer", "Size", "Shared with"})
+	for i, layer := range image.Layers {
+		var shared []string
+		if shared, err = sharingImages(client, layer.Digest, name, tag); err != nil {
+			return err
+		}
+		printer.Append([]string{strconv.Itoa(i + 1), layer.Digest, units.HumanSize(float64(layer.Size)), strings.Join(shared, ",")})
+	}
+
+	if err = printer.PrintAll(false); err != nil {
+		return err
+	}
+	fmt.Fprintln(c.Out)
+	return nil
+}
+
+// sharingImages returns the full name of all indexed images using the given layer, except the image itself
+func sharingImages(client dim.RegistryClient, layer, name, tag string) ([]string, error) {
+	results, err := searchAll(client, fmt.Sprintf("Layers:%s", layer), "", "FullName")
+	if err != nil {
+		return nil, err
+	}
+	shared := make([]string, 0, len(results))
+	for _, r := range results {
+		if r.Name != name || !utils.ListContains(r.Tags, tag) {
+			shared = append(shared, r.FullName)
+		}
+	}
+	return shared, nil
+}
@@ -0,0 +1,226 @@
+// Copyright 2016
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"context"
+
+	"github.com/docker/docker/reference"
+	"github.com/nhurel/dim/cli"
+	"github.com/nhurel/dim/lib"
+	"github.com/nhurel/dim/lib/registry"
+	"github.com/spf13/cobra"
+)
+
+func newParentsCommand(c *cli.Cli, rootCommand *cobra.Command, ctx context.Context) {
+	parentsCommand := &cobra.Command{
+		Use:   "parents IMAGE",
+		Short: "Lists the images an image is built on",
+		Long: `Print the indexed images an image is built on, from the closest one to the base image (requires dim server).
+An image is built on another one when the layers of the other image are the first layers of the image`,
+		Example: `dim parents private-registry/team/app:1.0`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runParents(c, args)
+		},
+	}
+
+	parentsCommand.Flags().IntVarP(&widthFlag, "width", "W", 150, "Column width")
+	parentsCommand.Flags().StringVar(&platformFlag, "platform", "", "Platform (os/arch[/variant]) of the image when it is available for several platforms")
+	rootCommand.AddCommand(parentsCommand)
+}
+
+func newChildrenCommand(c *cli.Cli, rootCommand *cobra.Command, ctx context.Context) {
+	childrenCommand := &cobra.Command{
+		Use:   "children IMAGE",
+		Short: "Prints the tree of the images built on an image",
+		Long: `Print the tree of the indexed images built on an image, and of the images built on them (requires dim server).
+An image is built on another one when the layers of the other image are the first layers of the image`,
+		Example: `dim children private-registry/ubuntu:16.04`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runChildren(c, args)
+		},
+	}
+
+	childrenCommand.Flags().StringVar(&platformFlag, "platform", "", "Only print the images of the given platform (os/arch[/variant])")
+	rootCommand.AddCommand(childrenCommand)
+}
+
+func runParents(c *cli.Cli, args []string) error {
+	client, images, err := lineageImages(c, args)
+	if err != nil {
+		return err
+	}
+	if len(groupByID(images)) > 1 {
+		return fmt.Errorf("%s is available for several platforms, select one with the --platform flag", args[0])
+	}
+
+	printer := cli.NewTabPrinter(c.Out, c.In, cli.WithWidth(widthFlag))
+	printer.Append([]string{"#", "Image", "Layers"})
+	visited := map[string]bool{images[0].ID: true}
+	for parent, n := images[0].Parent, 1; parent != "" && !visited[parent]; n++ {
+		visited[parent] = true
+		var parents []dim.SearchResult
+		if parents, err = searchAll(client, fmt.Sprintf("ID:%s", parent), "", "FullName"); err != nil {
+			return err
+		}
+		if len(parents) == 0 {
+			break
+		}
+		printer.Append([]string{strconv.Itoa(n), strings.Join(imageNames(parents), ", "), strconv.Itoa(len(parents[0].Layers))})
+		parent = parents[0].Parent
+	}
+
+	if err = printer.PrintAll(false); err != nil {
+		return err
+	}
+	fmt.Fprintln(c.Out)
+	return nil
+}
+
+func runChildren(c *cli.Cli, args []string) error {
+	client, images, err := lineageImages(c, args)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(c.Out, strings.Join(uniqueNames(images), ", "))
+	visited := make(map[string]bool)
+	for _, image := range images {
+		visited[image.ID] = true
+	}
+	return printChildren(c.Out, client, groupByID(images), "", visited)
+}
+
+// lineageImages connects to the dim server and returns the indexed images with the name given in args
+func lineageImages(c *cli.Cli, args []string) (dim.RegistryClient, []dim.SearchResult, error) {
+	if len(args) == 0 {
+		return nil, nil, errors.New("image name is missing")
+	}
+
+	var client dim.RegistryClient
+	var parsedName reference.Named
+	var err error
+	if client, parsedName, err = connectRegistry(c, args[0]); err != nil {
+		return nil, nil, err
+	}
+
+	name := parsedName.Name()[strings.Index(parsedName.Name(), "/")+1:]
+	tag := registry.ParseTag(parsedName)
+
+	var images []dim.SearchResult
+	if images, err = searchAll(client, fmt.Sprintf(`+Repository:"%s" +Tag:"%s"`, name, tag), platformFlag, "FullName"); err != nil {
+		return nil, nil, err
+	}
+	if len(images) == 0 {
+		return nil, nil, fmt.Errorf("%s is not indexed", args[0])
+	}
+	return client, images, nil
+}
+
+// printChildren prints the images built on the given images as a tree
+func printChildren(out io.Writer, client dim.RegistryClient, parents [][]dim.SearchResult, indent string, visited map[string]bool) error {
+	children := make([]dim.SearchResult, 0, 10)
+	for _, parent := range parents {
+		results, err := searchAll(client, fmt.Sprintf("Parent:%s", parent[0].ID), platformFlag, "FullName")
+		if err != nil {
+			return err
+		}
+		for _, r := range results {
+			if !visited[r.ID] {
+				children = append(children, r)
+			}
+		}
+	}
+
+	groups := groupByID(children)
+	for i, group := range groups {
+		branch, next := "├── ", "│   "
+		if i == len(groups)-1 {
+			branch, next = "└── ", "    "
+		}
+		fmt.Fprintf(out, "%s%s%s\n", indent, branch, strings.Join(imageNames(group), ", "))
+		visited[group[0].ID] = true
+		if err := printChildren(out, client, [][]dim.SearchResult{group}, indent+next, visited); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// searchAll returns all the images matching an advanced query, fetched page per page
+func searchAll(client dim.RegistryClient, query, platform, sort string) ([]dim.SearchResult, error) {
+	images := make([]dim.SearchResult, 0, 10)
+	for fetched, total := 0, 1; fetched < total; {
+		results, err := client.Search("", query, platform, nil, []string{sort}, fetched, searchPageSize)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to search images matching %s : %v", query, err)
+		}
+		if len(results.Results) == 0 {
+			break
+		}
+		images = append(images, results.Results...)
+		total = results.NumResults
+		fetched += len(results.Results)
+	}
+	return images, nil
+}
+
+// groupByID groups the images having the same manifest, in their original order
+func groupByID(images []dim.SearchResult) [][]dim.SearchResult {
+	groups := make([][]dim.SearchResult, 0, len(images))
+	index := make(map[string]int, len(images))
+	for _, image := range images {
+		if i, ok := index[image.ID]; ok {
+			groups[i] = append(groups[i], image)
+			continue
+		}
+		index[image.ID] = len(groups)
+		groups = append(groups, []dim.SearchResult{image})
+	}
+	return groups
+}
+
+// imageNames returns the full names of images, prefixed with their registry when the server indexes several registries
+func imageNames(images []dim.SearchResult) []string {
+	names := make([]string, len(images))
+	for i, image := range images {
+		names[i] = image.FullName
+		if image.Registry != "" {
+			names[i] = image.Registry + "/" + image.FullName
+		}
+	}
+	return names
+}
+
+// uniqueNames returns the names of images without duplicates, like the ones of the platforms of a multi-platform image
+func uniqueNames(images []dim.SearchResult) []string {
+	names := make([]string, 0, len(images))
+	seen := make(map[string]bool, len(images))
+	for _, name := range imageNames(images) {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// searchPageSize is the number of images fetched at a time by searchAll
+const searchPageSize = 50
@@ -0,0 +1,58 @@
+// Copyright 2016
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/nhurel/dim/lib"
+	"github.com/nhurel/dim/lib/mock"
+)
+
+func TestPrintChildren(t *testing.T) {
+	// Images by digest of their parent
+	children := map[string][]dim.SearchResult{
+		"debian": {
+			{ID: "httpd", FullName: "httpd:2.4"},
+			{ID: "httpd", FullName: "mirror/httpd:2.4"},
+			{ID: "mysql", FullName: "mysql:5.7"},
+		},
+		"httpd": {{ID: "app", FullName: "app:1.0", Registry: "prod"}},
+	}
+	client := &mock.NoOpRegistryClient{
+		SearchFn: func(query, advanced, platform string, facets, sort []string, offset, numResults int) (*dim.SearchResults, error) {
+			results := children[strings.TrimPrefix(advanced, "Parent:")]
+			if offset > 0 {
+				results = nil
+			}
+			return &dim.SearchResults{NumResults: len(results), Results: results}, nil
+		},
+	}
+
+	out := &bytes.Buffer{}
+	roots := [][]dim.SearchResult{{{ID: "debian", FullName: "debian:8"}}}
+	if err := printChildren(out, client, roots, "", map[string]bool{"debian": true}); err != nil {
+		t.Fatalf("printChildren returned an error : %v", err)
+	}
+
+	expected := `├── httpd:2.4, mirror/httpd:2.4
+│   └── prod/app:1.0
+└── mysql:5.7
+`
+	if out.String() != expected {
+		t.Errorf("printChildren printed\n%s\ninstead of\n%s", out, expected)
+	}
+}
@@ -101,11 +101,15 @@ func NewRootCommand(cli *cli.Cli, ctx context.Context) *cobra.Command {
 	newDeleteCommand(cli, rootCommand, ctx)
 	newGenBashCompletionCommand(cli, rootCommand, ctx)
 	newLabelCommand(cli, rootCommand, ctx)
+	newLayersCommand(cli, rootCommand, ctx)
+	newParentsCommand(cli, rootCommand, ctx)
+	newChildrenCommand(cli, rootCommand, ctx)
 	newSearchCommand(cli, rootCommand, ctx)
 	newServerCommand(cli, rootCommand, ctx)
 	newShowCommand(cli, rootCommand, ctx)
 	newVersionCommand(cli, rootCommand, ctx)
 	newHooktestCommand(cli, rootCommand, ctx)
+	newIndexCommand(cli, rootCommand, ctx)
 	newGenPasswdCommand(cli, rootCommand, ctx)
 
 	return rootCommand
@@ -189,6 +193,12 @@ func readConfigHooks(hookFns map[string]interface{}) (*index.Config, error) {
 
 	cfg.Hooks = hooks
 
+	searches := make([]*index.SavedSearch, 0, 10)
+	if err := viper.UnmarshalKey("index.searches", &searches); err != nil {
+		return nil, err
+	}
+	cfg.Searches = searches
+
 	for n, f := range hookFns {
 		cfg.RegisterFunction(n, f)
 	}
@@ -197,6 +207,10 @@ func readConfigHooks(hookFns map[string]interface{}) (*index.Config, error) {
 		return nil, err
 	}
 
+	if err := cfg.ParseSearches(); err != nil {
+		return nil, err
+	}
+
 	return cfg, nil
 }
 
@@ -225,7 +239,40 @@ __custom_func() {
 			__docker_complete_image_repos_and_tags
 			return
 			;;
+		dim_search)
+			__dim_complete_search
+			return
+			;;
+		dim_layers | dim_parents | dim_children)
+			__dim_suggest Name "$cur"
+			return
+			;;
+		*)
+			;;
+	esac
+}
+
+# __dim_suggest completes the current word with the values of a field indexed by the dim server.
+# The optional third argument is a prefix added to the suggested values
+__dim_suggest() {
+	local suggestions="$(dim search --suggest "$1" --quiet -- "$2" 2>/dev/null)"
+	COMPREPLY=( $(compgen -P "$3" -W "$suggestions" -- "$2") )
+	__ltrim_colon_completions "$cur"
+}
+
+__dim_complete_search() {
+	case "$cur" in
+		Label.*)
+			__dim_suggest Labels "${cur#Label.}" Label.
+			;;
+		Env.*)
+			__dim_suggest Envs "${cur#Env.}" Env.
+			;;
+		Annotation.*)
+			__dim_suggest Annotations "${cur#Annotation.}" Annotation.
+			;;
 		*)
+			__dim_suggest Name "$cur"
 			;;
 	esac
 }
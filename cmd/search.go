@@ -18,6 +18,7 @@ import (
 	"fmt"
 	"strings"
 
+	"sort"
 	"strconv"
 
 	"time"
@@ -46,7 +47,22 @@ dim search -a Label.os:ubuntu
 dim search -a Labels:os
 
 With the -a flag, you can also use the +/- operator to combine your clauses :
-dim search -a +Label.os:ubuntu -Label.version=xenial`,
+dim search -a +Label.os:ubuntu -Label.version=xenial
+
+Shortcuts and AND/OR/NOT operators are also supported :
+dim search -a '(label:team=payments OR port:8080) AND created<30d AND NOT size>200MB'
+
+Count the images per value of a field with the --facet flag (FIELD[:SIZE]) :
+dim search -a Labels:team --facet Label.team --facet Repository:20 --facet Created --facet Size
+
+Sort the results with the --sort flag. Prefix a field with - to sort in descending order :
+dim search -a Labels:team --sort -Created --sort Name
+
+Only return the images of a platform with the --platform flag :
+dim search -a Labels:team --platform linux/arm64
+
+List the most frequent values of a field starting with a prefix with the --suggest flag :
+dim search --suggest Labels org.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return runSearch(c, args)
 		},
@@ -58,14 +74,21 @@ dim search -a +Label.os:ubuntu -Label.version=xenial`,
 	searchCommand.Flags().IntVarP(&widthFlag, "width", "W", 150, "Column width")
 	searchCommand.Flags().BoolVarP(&quietFlag, "quiet", "q", false, "Print only image fullname")
 	searchCommand.Flags().StringVarP(&templateFlag, "template", "t", "", "Template to use to display image info")
+	searchCommand.Flags().StringSliceVar(&facetFlag, "facet", nil, "Count the images per value of a field (FIELD[:SIZE]). Created and Size are counted per range")
+	searchCommand.Flags().StringVar(&platformFlag, "platform", "", "Only return images for the given platform (os/arch[/variant])")
+	searchCommand.Flags().StringVar(&suggestFlag, "suggest", "", "Suggest the values of a field (Name, Tags, Labels, Envs...) starting with the query instead of searching images")
+	searchCommand.Flags().StringSliceVar(&sortFlag, "sort", nil, "Sort results on Name, Tag, FullName, Created, Size or Score. Prefix with - for descending order")
 	rootCommand.AddCommand(searchCommand)
 }
 
 func runSearch(c *cli.Cli, args []string) error {
-	if len(args) == 0 {
+	if len(args) == 0 && suggestFlag == "" {
 		return errors.New("query is missing")
 	}
-	query := args[0]
+	var query string
+	if len(args) > 0 {
+		query = args[0]
+	}
 
 	var authConfig *types.AuthConfig
 	if username != "" || password != "" {
@@ -81,6 +104,10 @@ func runSearch(c *cli.Cli, args []string) error {
 		return fmt.Errorf("Failed to connect to registry : %v", err)
 	}
 
+	if suggestFlag != "" {
+		return printSuggestions(c, client, suggestFlag, query)
+	}
+
 	var q, a string
 	if advancedFlag {
 		a = query
@@ -89,24 +116,33 @@ func runSearch(c *cli.Cli, args []string) error {
 	}
 
 	var results *dim.SearchResults
-	if results, err = client.Search(q, a, 0, paginationFlag); err != nil {
+	if results, err = client.Search(q, a, platformFlag, facetFlag, sortFlag, 0, paginationFlag); err != nil {
 		return fmt.Errorf("Failed to search images : %v", err)
 	}
 
 	if results.NumResults > 0 {
 		fmt.Fprintf(c.Err, "%d results found :\n", results.NumResults)
+		if err = printFacets(c, results.Facets); err != nil {
+			return err
+		}
 		var printer cli.Printer
+		// The registry of the images is only shown when the server indexes several registries
+		federated := hasRegistry(results.Results)
 		template := guessTemplate(quietFlag, templateFlag)
 		switch template {
 		case "":
 			printer = cli.NewTabPrinter(c.Out, c.In, cli.WithWidth(widthFlag))
-			printer.(*cli.TabPrinter).Append([]string{"Name", "Tag", "Created", "Labels", "Volumes", "Ports"})
+			header := []string{"Name", "Tags", "Created", "Platform", "User", "WorkingDir", "Entrypoint", "Cmd", "Healthcheck", "Labels", "Volumes", "Ports"}
+			if federated {
+				header = append([]string{"Registry"}, header...)
+			}
+			printer.(*cli.TabPrinter).Append(header)
 		default:
 			printer = cli.NewTemplatePrinter(c.Out, c.In, template)
 		}
 
 		for _, r := range results.Results {
-			printAppend(printer, r)
+			printAppend(printer, r, federated)
 		}
 
 		if err := printer.PrintAll(false); err != nil {
@@ -116,11 +152,11 @@ func runSearch(c *cli.Cli, args []string) error {
 			if unlimitedFlag {
 				c.Out.Write([]byte("\n"))
 			}
-			if results, err = client.Search(q, a, fetched, paginationFlag); err != nil {
+			if results, err = client.Search(q, a, platformFlag, nil, sortFlag, fetched, paginationFlag); err != nil {
 				return fmt.Errorf("Failed to search images : %v", err)
 			}
 			for _, r := range results.Results {
-				printAppend(printer, r)
+				printAppend(printer, r, federated)
 			}
 
 			if err := printer.PrintAll(!unlimitedFlag); err != nil {
@@ -136,6 +172,63 @@ func runSearch(c *cli.Cli, args []string) error {
 	return nil
 }
 
+// printSuggestions prints the most frequent values of a field starting with prefix.
+// Only the values are printed in quiet mode so that they can be used for shell completion
+func printSuggestions(c *cli.Cli, client dim.RegistryClient, field, prefix string) error {
+	terms, err := client.Suggest(field, prefix, 0)
+	if err != nil {
+		return fmt.Errorf("Failed to suggest values : %v", err)
+	}
+	if quietFlag {
+		for _, t := range terms {
+			fmt.Fprintln(c.Out, t.Term)
+		}
+		return nil
+	}
+
+	printer := cli.NewTabPrinter(c.Out, c.In, cli.WithWidth(facetWidth))
+	printer.Append([]string{field, "Count"})
+	for _, t := range terms {
+		printer.Append([]string{t.Term, strconv.Itoa(t.Count)})
+	}
+	if err = printer.PrintAll(false); err != nil {
+		return err
+	}
+	fmt.Fprintln(c.Out)
+	return nil
+}
+
+// printFacets prints a table of counts for each facet
+func printFacets(c *cli.Cli, facets map[string]*dim.Facet) error {
+	names := make([]string, 0, len(facets))
+	for name := range facets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		f := facets[name]
+		printer := cli.NewTabPrinter(c.Out, c.In, cli.WithWidth(facetWidth))
+		printer.Append([]string{name, "Count"})
+		for _, t := range f.Terms {
+			printer.Append([]string{t.Term, strconv.Itoa(t.Count)})
+		}
+		if f.Other > 0 {
+			printer.Append([]string{"(other)", strconv.Itoa(f.Other)})
+		}
+		if f.Missing > 0 {
+			printer.Append([]string{"(missing)", strconv.Itoa(f.Missing)})
+		}
+		if err := printer.PrintAll(false); err != nil {
+			return err
+		}
+		fmt.Fprint(c.Out, "\n\n")
+	}
+	return nil
+}
+
+const facetWidth = 60
+
 func intToStringSlice(iSlice []int) []string {
 	result := make([]string, len(iSlice))
 	for ind, i := range iSlice {
@@ -144,14 +237,41 @@ func intToStringSlice(iSlice []int) []string {
 	return result
 }
 
-func printAppend(printer cli.Printer, r dim.SearchResult) {
+func printAppend(printer cli.Printer, r dim.SearchResult, federated bool) {
 	if p, ok := printer.(*cli.TabPrinter); ok {
-		p.Append([]string{r.Name, r.Tag, utils.ParseDuration(time.Since(r.Created)), utils.FlatMap(r.Label), strings.Join(r.Volumes, ","), strings.Join(intToStringSlice(r.ExposedPorts), ",")})
+		row := []string{r.Name, tags(r), utils.ParseDuration(time.Since(r.Created)), platform(r), r.User, r.WorkingDir, strings.Join(r.Entrypoint, " "), strings.Join(r.Cmd, " "), r.Healthcheck, utils.FlatMap(r.Label), strings.Join(r.Volumes, ","), strings.Join(intToStringSlice(r.ExposedPorts), ",")}
+		if federated {
+			row = append([]string{r.Registry}, row...)
+		}
+		p.Append(row)
 	} else if p, ok := printer.(*cli.TemplatePrinter); ok {
 		p.Append(r)
 	}
 }
 
+// hasRegistry returns true if any result gives the registry of the image
+func hasRegistry(results []dim.SearchResult) bool {
+	for _, r := range results {
+		if r.Registry != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// tags returns all the tags of an image. Servers indexing one image per tag only return its Tag
+func tags(r dim.SearchResult) string {
+	if len(r.Tags) == 0 {
+		return r.Tag
+	}
+	return strings.Join(r.Tags, ",")
+}
+
+// platform returns the os/architecture pair of an image
+func platform(r dim.SearchResult) string {
+	return dim.FormatPlatform(r.OS, r.Architecture, r.Variant)
+}
+
 func guessTemplate(quiet bool, tpl string) string {
 	if quiet {
 		return "{{.FullName}}"
@@ -165,4 +285,7 @@ var (
 	widthFlag      int
 	unlimitedFlag  bool
 	quietFlag      bool
+	facetFlag      []string
+	sortFlag       []string
+	suggestFlag    string
 )
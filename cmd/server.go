@@ -17,8 +17,6 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
-	"path"
-	"time"
 
 	"context"
 
@@ -33,6 +31,7 @@ import (
 	"github.com/nhurel/dim/lib"
 	"github.com/nhurel/dim/lib/index"
 	"github.com/nhurel/dim/lib/registry"
+	"github.com/nhurel/dim/lib/utils"
 	"github.com/nhurel/dim/server"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -44,6 +43,8 @@ func newServerCommand(c *cli.Cli, rootCommand *cobra.Command, ctx context.Contex
 		Short: "Runs in server mode to provide search feature",
 		Long: `Start dim in server mode. In this mode, dim indexes your private registry and provide a search endpoint.
 Use the --port flag to specify the adress the server listens.
+The index stored in --index-path is reused across restarts and only updated with the changes made on the registry meanwhile.
+Use the --rebuild-index flag to drop it and index the whole registry again.
 	`,
 		PreRun: func(cmd *cobra.Command, args []string) {
 			sslCertFile = viper.GetString("ssl-cert-file")
@@ -57,18 +58,27 @@ Use the --port flag to specify the adress the server listens.
 	}
 
 	serverCommand.Flags().StringVarP(&port, "port", "p", "0.0.0.0:6000", "Dim listening port")
-	serverCommand.Flags().StringVar(&indexDir, "index-path", "dim.index", "Dim listening port")
+	serverCommand.Flags().StringVar(&indexDir, "index-path", "dim.index", "Directory where the index is stored")
+	serverCommand.Flags().BoolVar(&rebuildIndexFlag, "rebuild-index", false, "Drop the existing index and crawl the whole registry again")
+	serverCommand.Flags().StringVar(&queuePath, "queue-path", "dim.queue", "File where pending registry notifications are stored. Set it empty to keep them in memory only")
 	serverCommand.Flags().String("ssl-cert-file", "", "SSL certificate file for https connections")
 	serverCommand.Flags().String("ssl-key-file", "", "SSL key file for https connections")
 
 	viper.BindPFlag("ssl-cert-file", serverCommand.Flags().Lookup("ssl-cert-file"))
 	viper.BindPFlag("ssl-key-file", serverCommand.Flags().Lookup("ssl-key-file"))
 
+	newServerStatusCommand(c, serverCommand, ctx)
 	rootCommand.AddCommand(serverCommand)
 }
 
 func runServer(c *cli.Cli, ctx context.Context, cmd *cobra.Command, args []string) error {
-	if registryURL == "" {
+	var registries []*federatedRegistry
+	var err error
+	if registries, err = readFederatedRegistries(); err != nil {
+		return err
+	}
+
+	if registryURL == "" && len(registries) == 0 {
 		return fmt.Errorf("No registry URL given")
 	}
 
@@ -76,9 +86,6 @@ func runServer(c *cli.Cli, ctx context.Context, cmd *cobra.Command, args []strin
 		return fmt.Errorf("ssl-cert-file and ssl-key-file cannot be defined separately")
 	}
 
-	realDir := path.Join(indexDir, time.Now().Format("20060102150405.000"))
-	logrus.Warnf("Creating index dir at %s\n", realDir)
-
 	var authConfig *types.AuthConfig
 	var u, p string
 	if username != "" || password != "" {
@@ -87,30 +94,66 @@ func runServer(c *cli.Cli, ctx context.Context, cmd *cobra.Command, args []strin
 	}
 
 	var idx *index.Index
-	var err error
 
 	var cfg *index.Config
 	if cfg, err = readConfigHooks(hookFunctions); err != nil {
 		return err
 	}
-	cfg.Directory = realDir
-
-	var client dim.RegistryClient
+	cfg.Backend = viper.GetString("index.backend")
+	cfg.Directory = indexDir
+	cfg.Rebuild = rebuildIndexFlag
+	cfg.QueuePath = queuePath
+	cfg.MaxAttempts = viper.GetInt("index.max-attempts")
+	cfg.MaxDeadLetters = viper.GetInt("index.max-dead-letters")
+	cfg.ResyncInterval = viper.GetDuration("index.resync-interval")
+	cfg.PollInterval = viper.GetDuration("index.poll-interval")
+	cfg.BatchSize = viper.GetInt("index.batch-size")
+	cfg.BatchInterval = viper.GetDuration("index.batch-interval")
+	cfg.ParseWorkers = viper.GetInt("index.parse-workers")
+	cfg.TagWorkers = viper.GetInt("index.tag-workers")
+
+	// Without registry-url, the first federated registry is the one behind the proxy
+	if registryURL == "" {
+		registryURL = utils.BuildURL(registries[0].URL, registries[0].Insecure)
+		u, p = registries[0].User, registries[0].Password
+	}
 
 	var url *url.URL
 	if url, err = url.Parse(registryURL); err != nil {
 		return err
 	}
 
-	if client, err = registry.New(c, authConfig, registryURL); err != nil {
-		return fmt.Errorf("Failed to connect to registry : %v", err)
+	if len(registries) > 0 {
+		clients := make(map[string]dim.RegistryClient, len(registries))
+		for _, r := range registries {
+			if _, ok := clients[r.Name]; ok {
+				return fmt.Errorf("Registry %s is declared twice", r.Name)
+			}
+			var auth *types.AuthConfig
+			if r.User != "" || r.Password != "" {
+				auth = &types.AuthConfig{Username: r.User, Password: r.Password}
+			}
+			if clients[r.Name], err = registry.New(c, auth, utils.BuildURL(r.URL, r.Insecure)); err != nil {
+				return fmt.Errorf("Failed to connect to registry %s : %v", r.Name, err)
+			}
+		}
+		if idx, err = index.NewFederated(cfg, clients); err != nil {
+			return err
+		}
+	} else {
+		var client dim.RegistryClient
+		if client, err = registry.New(c, authConfig, registryURL); err != nil {
+			return fmt.Errorf("Failed to connect to registry : %v", err)
+		}
+		if idx, err = index.New(cfg, client); err != nil {
+			return err
+		}
 	}
 
-	if idx, err = index.New(cfg, client); err != nil {
-		return err
-	}
+	serverIndex = idx
 
-	indexationDone := idx.Build()
+	// Reconcile falls back to a full build when the index is new
+	indexationDone := idx.Reconcile()
 
 	go func() {
 		_ = <-indexationDone
@@ -136,14 +179,19 @@ func runServer(c *cli.Cli, ctx context.Context, cmd *cobra.Command, args []strin
 }
 
 var (
-	port        string
-	indexDir    string
-	sslCertFile string
-	sslKeyFile  string
+	port             string
+	indexDir         string
+	rebuildIndexFlag bool
+	queuePath        string
+	sslCertFile      string
+	sslKeyFile       string
 )
 
 var s *server.Server
 
+// serverIndex is closed on shutdown to stop its workers and release the index and queue files
+var serverIndex *index.Index
+
 func handleSignal() {
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
@@ -153,6 +201,11 @@ func handleSignal() {
 				logrus.Infoln("ShuttingDown server")
 				s.BlockingClose()
 			}
+			if serverIndex != nil {
+				if err := serverIndex.Close(); err != nil {
+					logrus.WithError(err).Errorln("Failed to close index")
+				}
+			}
 			os.Exit(0)
 		}
 	}()
@@ -170,6 +223,29 @@ type notificationRequest struct {
 
 type notificationRequestOption func(*notificationRequest)
 
+// federatedRegistry is one of the registries indexed by the server, declared under the registries key of the configuration
+type federatedRegistry struct {
+	Name     string
+	URL      string
+	User     string
+	Password string
+	Insecure bool
+}
+
+// readFederatedRegistries returns the registries declared in the configuration
+func readFederatedRegistries() ([]*federatedRegistry, error) {
+	registries := make([]*federatedRegistry, 0, 5)
+	if err := viper.UnmarshalKey("registries", &registries); err != nil {
+		return nil, err
+	}
+	for _, r := range registries {
+		if r.Name == "" || r.URL == "" {
+			return nil, fmt.Errorf("Registries must have a name and an url")
+		}
+	}
+	return registries, nil
+}
+
 var hookFunctions = map[string]interface{}{
 	"info": func(args ...interface{}) bool {
 		logrus.Infoln(args)
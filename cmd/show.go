@@ -33,7 +33,8 @@ func newShowCommand(c *cli.Cli, rootCommand *cobra.Command, ctx context.Context)
 		Short: "Shows details about an image",
 		Long: `Print the defails of a local image.
 Use the -o flag to write the details into a flag instead of writing to stdout.
-Use the -r flag to print the details of an image on the private registry (not present locally)`,
+Use the -r flag to print the details of an image on the private registry (not present locally).
+Use the --platform flag to choose the platform to show when a remote image is a multi-platform image`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return runShow(c, args)
 		},
@@ -42,6 +43,7 @@ Use the -r flag to print the details of an image on the private registry (not pr
 	showCommand.Flags().StringVarP(&templateFlag, "template", "t", "", "Template to use to display image info")
 	showCommand.Flags().BoolVarP(&remoteFlag, "remote", "r", false, "Show image from remote repository")
 	showCommand.Flags().StringVarP(&outputFlag, "output", "o", "", "Write output to file instead of stdout")
+	showCommand.Flags().StringVar(&platformFlag, "platform", "", "Platform (os/arch[/variant]) of the image to show when it is available for several platforms")
 	rootCommand.AddCommand(showCommand)
 }
 
@@ -80,19 +82,23 @@ func runShow(c *cli.Cli, args []string) error {
 			return err
 		}
 
-		return client.PrintImageInfo(output, parsedName, tpl)
+		return client.PrintImageInfo(output, parsedName, platformFlag, tpl)
 	}
 
 	return Dim.PrintImageInfo(output, image, tpl)
 }
 
-var templateFlag, outputFlag string
+var templateFlag, outputFlag, platformFlag string
 
 const infoTpl = `Name : {{range $i, $e := .RepoTags}} {{if eq $i  0}}{{$e}}{{end}}{{end}}
 Id :  {{.ID}}
+Platform : {{.Platform}}
 Labels:
 {{range $k, $v := .Config.Labels}}{{$k}} = {{$v}}
-{{end}}
+{{end}}{{if .Annotations}}
+Annotations:
+{{range $k, $v := .Annotations}}{{$k}} = {{$v}}
+{{end}}{{end}}
 Tags:
 {{range $i, $e := .RepoTags}}{{$e}}
 {{end}}
@@ -107,4 +113,6 @@ Env :
 {{end}}
 Entrypoint : {{.Config.Entrypoint}}
 Command : {{.Config.Cmd}}
-`
+History :
+{{range .History}} {{.CreatedBy}}
+{{end}}`
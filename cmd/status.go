@@ -0,0 +1,92 @@
+// Copyright 2016
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"context"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/go-units"
+	"github.com/nhurel/dim/cli"
+	"github.com/nhurel/dim/lib"
+	"github.com/nhurel/dim/lib/registry"
+	"github.com/spf13/cobra"
+)
+
+func newServerStatusCommand(c *cli.Cli, serverCommand *cobra.Command, ctx context.Context) {
+	statusCommand := &cobra.Command{
+		Use:   "status",
+		Short: "Prints the state of the dim server index",
+		Long: `Print the state of the index of the dim server running in front of the registry.
+While the registry is crawled, the number of repositories and tags already read is printed along with their total.`,
+		Example: `dim server status --registry-url https://private-registry`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runServerStatus(c)
+		},
+	}
+	serverCommand.AddCommand(statusCommand)
+}
+
+func runServerStatus(c *cli.Cli) error {
+	if registryURL == "" {
+		return fmt.Errorf("No registry URL given")
+	}
+
+	var authConfig *types.AuthConfig
+	if username != "" || password != "" {
+		authConfig = &types.AuthConfig{Username: username, Password: password}
+	}
+
+	var client dim.RegistryClient
+	var err error
+	if client, err = registry.New(c, authConfig, registryURL); err != nil {
+		return fmt.Errorf("Failed to connect to registry : %v", err)
+	}
+
+	var status *dim.IndexStatus
+	if status, err = client.IndexStatus(); err != nil {
+		return fmt.Errorf("Failed to get index status : %v", err)
+	}
+	return printStatus(c, status)
+}
+
+func printStatus(c *cli.Cli, status *dim.IndexStatus) error {
+	lastResync := "never"
+	if status.LastResync != nil {
+		lastResync = status.LastResync.Format(time.RFC3339)
+	}
+	_, err := fmt.Fprintf(c.Out, `State : %s
+Repositories : %d/%d
+Tags : %d/%d
+Indexed images : %d
+Pending notifications : %d
+Last resync : %s
+Index size : %s
+`, status.State, status.RepositoriesCrawled, status.Repositories, status.TagsCrawled, status.Tags,
+		status.Documents, status.PendingJobs, lastResync, units.HumanSize(float64(status.DiskSize)))
+	if err != nil || len(status.Errors) == 0 {
+		return err
+	}
+
+	fmt.Fprintln(c.Out, "Errors :")
+	for _, e := range status.Errors {
+		if _, err = fmt.Fprintf(c.Out, "  %s\n", e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
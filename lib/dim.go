@@ -138,16 +138,37 @@ func (d *Dim) RemoveLabel(parent string, labels []string, tag string) error {
 // PrintImageInfo writes image information to the writer
 func (d *Dim) PrintImageInfo(w io.Writer, image string, tpl *template.Template) error {
 	var err error
-	var infos types.ImageInspect
+	infos := imageInfo{}
 
-	if infos, err = d.Docker.Inspect(image); err != nil {
+	if infos.ImageInspect, err = d.Docker.Inspect(image); err != nil {
 		return err
 	}
+	infos.Platform = FormatPlatform(infos.Os, infos.Architecture, "")
+
+	var history []types.ImageHistory
+	if history, err = d.Docker.History(image); err != nil {
+		return err
+	}
+
+	// Docker returns the most recent step first
+	infos.History = make([]HistoryEntry, len(history))
+	for i, h := range history {
+		infos.History[len(history)-1-i] = HistoryEntry{Created: time.Unix(h.Created, 0), CreatedBy: h.CreatedBy, Comment: h.Comment}
+	}
 
 	return tpl.Execute(w, infos)
 
 }
 
+// imageInfo adds the platform, the OCI annotations and the build history to the image details.
+// Annotations are only known for images read from the registry
+type imageInfo struct {
+	types.ImageInspect
+	Platform    string
+	Annotations map[string]string
+	History     []HistoryEntry
+}
+
 // AsIndexImage returns the IndexImage representation of an image metadata
 func (d *Dim) AsIndexImage(image string) (*IndexImage, error) {
 	var err error
@@ -166,6 +187,7 @@ func (d *Dim) AsIndexImage(image string) (*IndexImage, error) {
 		Comment:  dockerImage.Comment,
 		Author:   dockerImage.Author,
 		FullName: fullName,
+		Tags:     []string{strings.Split(fullName, ":")[1]},
 	}
 	parsed.Created, _ = time.Parse(time.RFC3339, dockerImage.Created)
 
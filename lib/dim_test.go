@@ -22,6 +22,7 @@ import (
 	"strings"
 
 	"github.com/docker/docker/utils/templates"
+	"github.com/docker/engine-api/types"
 	"github.com/nhurel/dim/lib"
 	"github.com/nhurel/dim/lib/mock"
 	"github.com/nhurel/dim/lib/utils"
@@ -174,6 +175,27 @@ func TestPrintImageInfo(t *testing.T) {
 
 }
 
+func TestPrintImageInfoHistory(t *testing.T) {
+	//GIVEN
+	history := []types.ImageHistory{
+		{CreatedBy: "/bin/sh -c apt-get install -y openjdk-8-jdk"},
+		{CreatedBy: "/bin/sh -c #(nop) ADD file:1234 in /"},
+	}
+	d := &dim.Dim{Docker: &mock.NoOpDockerClient{ImageHistory: history, Calls: make(map[string][]interface{})}}
+	writer := &bytes.Buffer{}
+	//WHEN
+	tpl, err := templates.NewParse("test", "{{range .History}}{{.CreatedBy}};{{end}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.PrintImageInfo(writer, "image", tpl)
+	//THEN
+	expected := "/bin/sh -c #(nop) ADD file:1234 in /;/bin/sh -c apt-get install -y openjdk-8-jdk;"
+	if got := writer.String(); got != expected {
+		t.Errorf("PrintImageInfo returned '%s' instead of '%s'", got, expected)
+	}
+}
+
 func testNoOpCalls(calls map[string][]interface{}, method string, expectedParams []interface{}, t *testing.T) {
 	if len(calls[method]) != len(expectedParams) {
 		t.Errorf("%s was called with %d parameters. Expected %d", method, len(calls[method]), len(expectedParams))
@@ -0,0 +1,120 @@
+// Copyright 2016
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package index
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/nhurel/dim/lib"
+)
+
+// Both built-in backends are the bleve backend, with different storages
+const (
+	// DiskBackend stores the bleve index in the directory given in the configuration. It is the default backend
+	DiskBackend = "disk"
+	// MemoryBackend keeps the bleve index in memory only. It is rebuilt each time the server starts
+	MemoryBackend = "memory"
+)
+
+// Backend stores the indexed images and answers the searches on them.
+// Documents are identified by the ID returned by documentID
+type Backend interface {
+	// Write indexes the given documents by ID and removes the deleted ones, in a single batch
+	Write(docs map[string]*dim.IndexImage, deleted []string) error
+	// Get returns the document with the given ID, or nil if it does not exist
+	Get(id string) (*dim.IndexImage, error)
+	// Find returns all the images matching a filter
+	Find(filter Filter) ([]*dim.IndexImage, error)
+	// Related returns the images whose layers are a strict prefix of the given ones, or start with all of them
+	Related(layers []string) ([]*dim.IndexImage, error)
+	// Walk calls f for each indexed image and stops at the first error, which it returns
+	Walk(f func(*dim.IndexImage) error) error
+	// Search returns the images matching a search request
+	Search(rq *SearchRequest) (*dim.IndexResults, error)
+	// Terms returns the values of a field starting with prefix, with the number of images having each of them
+	Terms(field, prefix string) ([]dim.FacetTerm, error)
+	// Count returns the number of indexed images
+	Count() (uint64, error)
+	Close() error
+}
+
+// Filter matches the images having exactly the given value in each field.
+// Fields are the ones of IndexImage, like Name, Tags, Registry, ID, ListDigest or Layers. A list field matches when one of its values does
+type Filter map[string]string
+
+// SearchRequest holds the parameters of a search (see Index.SearchImages)
+type SearchRequest struct {
+	// Query is searched in the image names and tags
+	Query string
+	// Advanced is a query written with the advanced syntax (see ParseQuery)
+	Advanced string
+	Platform string
+	// IDs restricts the search to the documents with these IDs when not empty
+	IDs    []string
+	Fields []string
+	Facets []string
+	Sort   []string
+	Offset int
+	Size   int
+}
+
+// BackendFactory opens the backend where images are stored
+type BackendFactory func(cfg *Config) (Backend, error)
+
+var (
+	backends = map[string]BackendFactory{
+		DiskBackend:   openDisk,
+		MemoryBackend: newMemOnly,
+	}
+	backendsMu sync.RWMutex
+)
+
+// RegisterBackend makes a backend available under the given name for the Backend configuration key
+func RegisterBackend(name string, factory BackendFactory) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	backends[name] = factory
+}
+
+// openBackend opens the backend selected in the configuration
+func openBackend(cfg *Config) (Backend, error) {
+	name := cfg.Backend
+	if name == "" {
+		name = DiskBackend
+	}
+
+	backendsMu.RLock()
+	factory, ok := backends[name]
+	backendsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("Unknown index backend %s. Available backends are %s", name, strings.Join(backendNames(), ", "))
+	}
+	logrus.WithField("backend", name).Debugln("Opening index")
+	return factory(cfg)
+}
+
+func backendNames() []string {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
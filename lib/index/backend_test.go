@@ -0,0 +1,78 @@
+// Copyright 2016
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package index
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestMemoryBackend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dim")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir : %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	idx, err := New(&Config{Backend: MemoryBackend, Directory: path.Join(dir, "index")}, nil)
+	if err != nil {
+		t.Fatalf("New returned an error : %v", err)
+	}
+	defer idx.Close()
+
+	if _, err = os.Stat(path.Join(dir, "index")); !os.IsNotExist(err) {
+		t.Error("In-memory index should not be written on disk")
+	}
+
+	for i := range images {
+		idx.IndexImage(&images[i])
+	}
+	results, err := idx.SearchImages("", "+Label.family:debian -Name:mysql", "", nil, nil, nil, 0, 10)
+	if err != nil {
+		t.Fatalf("SearchImages returned an error : %v", err)
+	}
+	if len(results.Images) != 1 || results.Images[0].Name != "httpd" {
+		t.Errorf("SearchImages returned %v instead of httpd", results.Images)
+	}
+}
+
+func TestUnknownBackend(t *testing.T) {
+	if _, err := New(&Config{Backend: "unknown"}, nil); err == nil {
+		t.Error("New should fail with an unknown backend")
+	}
+}
+
+func TestRegisterBackend(t *testing.T) {
+	called := false
+	RegisterBackend("custom", func(cfg *Config) (Backend, error) {
+		called = true
+		return newMemOnly(cfg)
+	})
+	defer func() {
+		backendsMu.Lock()
+		delete(backends, "custom")
+		backendsMu.Unlock()
+	}()
+
+	idx, err := New(&Config{Backend: "custom"}, nil)
+	if err != nil {
+		t.Fatalf("New returned an error : %v", err)
+	}
+	idx.Close()
+	if !called {
+		t.Error("New did not use the registered backend")
+	}
+}
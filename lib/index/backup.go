@@ -0,0 +1,294 @@
+// Copyright 2016
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package index
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/blevesearch/bleve"
+	"github.com/boltdb/bolt"
+)
+
+// Names of the entries of a snapshot archive
+const (
+	indexEntry = "index.kv"
+	queueEntry = "queue.db"
+)
+
+// restoreBatchSize is the number of keys written at once when restoring an index
+const restoreBatchSize = 1000
+
+// dumper is implemented by the backends whose content can be written to a snapshot
+type dumper interface {
+	// dump writes the content of the backend to w and returns the number of bytes written
+	dump(w io.Writer) (int64, error)
+}
+
+// Backup writes a snapshot of the index, and of the notification queue if any, to w as a gzipped tar archive.
+// The index keeps serving requests meanwhile : the snapshot holds its content at the time Backup was called
+func (idx *Index) Backup(w io.Writer) error {
+	d, ok := idx.Backend.(dumper)
+	if !ok {
+		return fmt.Errorf("Index backend does not support backups")
+	}
+
+	// The key-values are dumped to a temporary file first because tar needs the entry size before its content
+	dump, err := ioutil.TempFile("", "dim-backup")
+	if err != nil {
+		return fmt.Errorf("Failed to create temporary file : %v", err)
+	}
+	defer os.Remove(dump.Name())
+	defer dump.Close()
+
+	var size int64
+	if size, err = d.dump(dump); err != nil {
+		return err
+	}
+	if _, err = dump.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("Failed to read index dump : %v", err)
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+	if err = tw.WriteHeader(&tar.Header{Name: indexEntry, Mode: 0600, Size: size, ModTime: now}); err != nil {
+		return fmt.Errorf("Failed to write snapshot : %v", err)
+	}
+	if _, err = io.Copy(tw, dump); err != nil {
+		return fmt.Errorf("Failed to write snapshot : %v", err)
+	}
+
+	if idx.queue != nil {
+		if err = idx.queue.db.View(func(tx *bolt.Tx) error {
+			if err := tw.WriteHeader(&tar.Header{Name: queueEntry, Mode: 0600, Size: tx.Size(), ModTime: now}); err != nil {
+				return err
+			}
+			_, err := tx.WriteTo(tw)
+			return err
+		}); err != nil {
+			return fmt.Errorf("Failed to write notification queue snapshot : %v", err)
+		}
+	}
+
+	if err = tw.Close(); err != nil {
+		return fmt.Errorf("Failed to write snapshot : %v", err)
+	}
+	return gz.Close()
+}
+
+// dump writes all the key-values of the index store to w and returns the number of bytes written.
+// Each key and value is preceded by its length
+func (b *bleveBackend) dump(w io.Writer) (int64, error) {
+	_, kv, err := b.index.Advanced()
+	if err != nil {
+		return 0, fmt.Errorf("Failed to access index store : %v", err)
+	}
+	reader, err := kv.Reader()
+	if err != nil {
+		return 0, fmt.Errorf("Failed to read index store : %v", err)
+	}
+	defer reader.Close()
+
+	it := reader.RangeIterator(nil, nil)
+	defer it.Close()
+
+	bw := bufio.NewWriter(w)
+	var size int64
+	var keys int
+	buf := make([]byte, binary.MaxVarintLen64)
+	for k, v, ok := it.Current(); ok; k, v, ok = it.Current() {
+		for _, b := range [][]byte{k, v} {
+			n := binary.PutUvarint(buf, uint64(len(b)))
+			if _, err = bw.Write(buf[:n]); err != nil {
+				return 0, fmt.Errorf("Failed to dump index : %v", err)
+			}
+			if _, err = bw.Write(b); err != nil {
+				return 0, fmt.Errorf("Failed to dump index : %v", err)
+			}
+			size += int64(n + len(b))
+		}
+		keys++
+		it.Next()
+	}
+	logrus.WithField("keys", keys).Debugln("Index dumped")
+	if err = bw.Flush(); err != nil {
+		return 0, fmt.Errorf("Failed to dump index : %v", err)
+	}
+	return size, nil
+}
+
+// Restore replaces the index in cfg.Directory, and the notification queue in cfg.QueuePath, with the content of a snapshot written by Backup.
+// It must not run while a server uses the index
+func Restore(cfg *Config, r io.Reader) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("Failed to read snapshot : %v", err)
+	}
+	defer gz.Close()
+
+	restoredIndex := false
+	tr := tar.NewReader(gz)
+	for {
+		var hdr *tar.Header
+		if hdr, err = tr.Next(); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("Failed to read snapshot : %v", err)
+		}
+
+		switch hdr.Name {
+		case indexEntry:
+			if err = restoreIndex(cfg.Directory, tr); err != nil {
+				return err
+			}
+			restoredIndex = true
+		case queueEntry:
+			if cfg.QueuePath == "" {
+				logrus.Warnln("No queue path given, pending notifications of the snapshot are not restored")
+				continue
+			}
+			if err = restoreFile(cfg.QueuePath, tr); err != nil {
+				return fmt.Errorf("Failed to restore notification queue : %v", err)
+			}
+		default:
+			logrus.WithField("entry", hdr.Name).Warnln("Ignoring unknown snapshot entry")
+		}
+	}
+
+	if !restoredIndex {
+		return fmt.Errorf("Snapshot does not contain any index")
+	}
+	return nil
+}
+
+// restoreIndex creates an index in directory from the key-values dumped by bleveBackend.dump.
+// The index is written next to directory first so an existing index is only replaced once the snapshot is fully restored
+func restoreIndex(directory string, r io.Reader) error {
+	tmp := directory + ".restore"
+	if err := os.RemoveAll(tmp); err != nil {
+		return fmt.Errorf("Failed to remove previous restore attempt %s : %v", tmp, err)
+	}
+	i, err := openOrCreate(&Config{Directory: tmp})
+	if err != nil {
+		return fmt.Errorf("Failed to create index : %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	if err = loadIndex(i, bufio.NewReader(r)); err != nil {
+		i.Close()
+		return err
+	}
+	if err = i.Close(); err != nil {
+		return fmt.Errorf("Failed to close restored index : %v", err)
+	}
+
+	// Reopening the index checks it is readable and was created with the current mapping
+	if i, err = bleve.Open(tmp); err != nil {
+		return fmt.Errorf("Failed to open restored index : %v", err)
+	}
+	v, _ := i.GetInternal(mappingVersionKey)
+	i.Close()
+	if string(v) != mappingVersion {
+		return fmt.Errorf("Snapshot was created with mapping version %s, this version of dim needs version %s", v, mappingVersion)
+	}
+
+	if err = os.RemoveAll(directory); err != nil {
+		return fmt.Errorf("Failed to remove index directory %s : %v", directory, err)
+	}
+	if err = os.Rename(tmp, directory); err != nil {
+		return fmt.Errorf("Failed to move restored index to %s : %v", directory, err)
+	}
+	return nil
+}
+
+// loadIndex writes the key-values dumped by bleveBackend.dump in the store of the given index
+func loadIndex(i bleve.Index, r *bufio.Reader) error {
+	_, kv, err := i.Advanced()
+	if err != nil {
+		return fmt.Errorf("Failed to access index store : %v", err)
+	}
+	writer, err := kv.Writer()
+	if err != nil {
+		return fmt.Errorf("Failed to write index store : %v", err)
+	}
+	defer writer.Close()
+
+	batch := writer.NewBatch()
+	defer batch.Close()
+	pending := 0
+	for {
+		var k, v []byte
+		if k, err = readChunk(r); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("Failed to read index snapshot : %v", err)
+		}
+		if v, err = readChunk(r); err != nil {
+			return fmt.Errorf("Failed to read index snapshot : %v", err)
+		}
+		batch.Set(k, v)
+		if pending++; pending == restoreBatchSize {
+			if err = writer.ExecuteBatch(batch); err != nil {
+				return fmt.Errorf("Failed to restore index : %v", err)
+			}
+			batch.Reset()
+			pending = 0
+		}
+	}
+	if err = writer.ExecuteBatch(batch); err != nil {
+		return fmt.Errorf("Failed to restore index : %v", err)
+	}
+	return nil
+}
+
+// readChunk reads a byte slice preceded by its length
+func readChunk(r *bufio.Reader) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, n)
+	if _, err = io.ReadFull(r, b); err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return b, err
+}
+
+// restoreFile writes the content of r to path, replacing any existing file once fully written
+func restoreFile(path string, r io.Reader) error {
+	tmp := path + ".restore"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err = f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
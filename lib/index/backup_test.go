@@ -0,0 +1,92 @@
+// Copyright 2016
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package index
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/nhurel/dim/lib"
+)
+
+func TestBackupRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dim")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir : %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// No notification worker is started so the queued job stays pending
+	idx := &Index{Config: &Config{Directory: path.Join(dir, "index")}}
+	if idx.Backend, err = openDisk(idx.Config); err != nil {
+		t.Fatalf("Failed to create index : %v", err)
+	}
+	if idx.queue, err = OpenQueue(path.Join(dir, "queue")); err != nil {
+		t.Fatalf("Failed to create queue : %v", err)
+	}
+	for i := range images {
+		idx.IndexImage(&images[i])
+	}
+	idx.queue.Push(&dim.NotificationJob{Action: dim.PushAction, Repository: "pending"})
+
+	snapshot := &bytes.Buffer{}
+	if err = idx.Backup(snapshot); err != nil {
+		t.Fatalf("Backup returned an error : %v", err)
+	}
+	idx.Close()
+
+	restored := &Config{Directory: path.Join(dir, "restored.index"), QueuePath: path.Join(dir, "restored.queue")}
+	if err = Restore(restored, snapshot); err != nil {
+		t.Fatalf("Restore returned an error : %v", err)
+	}
+
+	i, err := openOrCreate(restored)
+	if err != nil {
+		t.Fatalf("Failed to open restored index : %v", err)
+	}
+	defer i.Close()
+	if count, _ := i.DocCount(); count != uint64(len(images)) {
+		t.Errorf("Restored index has %d documents instead of %d", count, len(images))
+	}
+	if doc, err := i.Document(documentID(&images[1])); err != nil || doc == nil {
+		t.Errorf("Image %s was not restored : %v", images[1].Name, err)
+	}
+
+	q, err := OpenQueue(restored.QueuePath)
+	if err != nil {
+		t.Fatalf("Failed to open restored queue : %v", err)
+	}
+	defer q.Close()
+	if pending, _ := q.Pending(); pending != 1 {
+		t.Errorf("Restored queue has %d pending jobs instead of 1", pending)
+	}
+}
+
+func TestRestoreInvalidSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dim")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir : %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err = Restore(&Config{Directory: path.Join(dir, "index")}, bytes.NewBufferString("not a snapshot")); err == nil {
+		t.Error("Restore should fail on an invalid snapshot")
+	}
+	if _, err = os.Stat(path.Join(dir, "index")); !os.IsNotExist(err) {
+		t.Error("Restore should not create an index from an invalid snapshot")
+	}
+}
@@ -0,0 +1,578 @@
+// Copyright 2016
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package index
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/document"
+	"github.com/blevesearch/bleve/index"
+	"github.com/blevesearch/bleve/search"
+	"github.com/nhurel/dim/lib"
+	"github.com/nhurel/dim/lib/utils"
+)
+
+// bleveBackend stores the images in a bleve index
+type bleveBackend struct {
+	index bleve.Index
+}
+
+// NewBleveBackend returns a backend storing the images in the given bleve index, which must map them with ImageMapping
+func NewBleveBackend(i bleve.Index) Backend {
+	return &bleveBackend{index: i}
+}
+
+// openDisk opens the index stored in cfg.Directory or creates it
+func openDisk(cfg *Config) (Backend, error) {
+	i, err := openOrCreate(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return NewBleveBackend(i), nil
+}
+
+// newMemOnly creates an empty bleve index kept in memory, which is the bleve backend with a memory storage
+func newMemOnly(cfg *Config) (Backend, error) {
+	logrus.Infoln("Creating in-memory index")
+	i, err := bleve.NewMemOnly(newIndexMapping())
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create in-memory index : %v", err)
+	}
+	if err = i.SetInternal(mappingVersionKey, []byte(mappingVersion)); err != nil {
+		i.Close()
+		return nil, err
+	}
+	return NewBleveBackend(i), nil
+}
+
+// mappingVersion must be incremented each time ImageMapping changes so existing indexes get rebuilt
+const mappingVersion = "12"
+
+var mappingVersionKey = []byte("dim.mappingVersion")
+
+// newIndexMapping returns the mapping of an index storing images
+func newIndexMapping() *bleve.IndexMapping {
+	mapping := bleve.NewIndexMapping()
+	mapping.AddDocumentMapping("image", ImageMapping)
+	return mapping
+}
+
+// openOrCreate opens the index stored in cfg.Directory or creates it
+func openOrCreate(cfg *Config) (bleve.Index, error) {
+	l := logrus.WithField("directory", cfg.Directory)
+	if cfg.Rebuild {
+		l.Warnln("Removing existing index")
+		if err := os.RemoveAll(cfg.Directory); err != nil {
+			return nil, fmt.Errorf("Failed to remove index directory %s : %v", cfg.Directory, err)
+		}
+	}
+
+	i, err := bleve.Open(cfg.Directory)
+	switch err {
+	case nil:
+		if v, _ := i.GetInternal(mappingVersionKey); string(v) == mappingVersion {
+			l.Infoln("Reusing existing index")
+			return i, nil
+		}
+		l.Warnln("Existing index was created with an older mapping. Rebuilding it")
+		i.Close()
+		if err = os.RemoveAll(cfg.Directory); err != nil {
+			return nil, fmt.Errorf("Failed to remove index directory %s : %v", cfg.Directory, err)
+		}
+	case bleve.ErrorIndexPathDoesNotExist:
+		l.Infoln("Creating new index")
+	case bleve.ErrorIndexMetaMissing:
+		if !isLegacyIndexDir(cfg.Directory) {
+			return nil, fmt.Errorf("Failed to open index in %s (use a clean rebuild to start over) : %v", cfg.Directory, err)
+		}
+		// Older versions created a new index in a timestamped subdirectory at each start. They cannot be reused
+		l.Warnln("Directory holds indexes of an older dim version. Replacing them with a new index")
+		if err = os.RemoveAll(cfg.Directory); err != nil {
+			return nil, fmt.Errorf("Failed to remove index directory %s : %v", cfg.Directory, err)
+		}
+	default:
+		return nil, fmt.Errorf("Failed to open index in %s (use a clean rebuild to start over) : %v", cfg.Directory, err)
+	}
+
+	if i, err = bleve.New(cfg.Directory, newIndexMapping()); err != nil {
+		return nil, err
+	}
+	if err = i.SetInternal(mappingVersionKey, []byte(mappingVersion)); err != nil {
+		i.Close()
+		return nil, err
+	}
+	return i, nil
+}
+
+// legacyIndexRegexp matches the names of the subdirectories older versions created an index in
+var legacyIndexRegexp = regexp.MustCompile(`^\d{14}\.\d{3}$`)
+
+// isLegacyIndexDir tells whether dir only holds the timestamped indexes created by older versions
+func isLegacyIndexDir(dir string) bool {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil || len(entries) == 0 {
+		return false
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || !legacyIndexRegexp.MatchString(entry.Name()) {
+			return false
+		}
+	}
+	return true
+}
+
+// keywordFields maps the image fields to the indexed fields holding their whole values, when they differ
+var keywordFields = map[string]string{
+	"Name":   "Repository",
+	"Tags":   "Tag",
+	"Author": "AuthorValue",
+}
+
+// keywordField returns the indexed field holding the whole values of an image field, so that terms are values instead of words.
+// Values of the Label.*, Annotation.* and Env.* fields are read from the corresponding exact fields
+func keywordField(field string) string {
+	if f, ok := keywordFields[field]; ok {
+		return f
+	}
+	if i := strings.Index(field, "."); i > 0 {
+		if m, ok := mapFields[strings.ToLower(field[:i])]; ok && m.values == field[:i+1] {
+			return m.exact + field[i+1:]
+		}
+	}
+	return field
+}
+
+// findPageSize is the number of documents read at once when looking for all the documents matching a query
+const findPageSize = 500
+
+// Write indexes the documents and removes the deleted ones in a single batch
+func (b *bleveBackend) Write(docs map[string]*dim.IndexImage, deleted []string) error {
+	batch := b.index.NewBatch()
+	for _, id := range deleted {
+		batch.Delete(id)
+	}
+	for id, doc := range docs {
+		if err := batch.Index(id, newImageDocument(doc)); err != nil {
+			return err
+		}
+	}
+	return b.index.Batch(batch)
+}
+
+// Get returns the document with the given ID, or nil if it does not exist
+func (b *bleveBackend) Get(id string) (*dim.IndexImage, error) {
+	images, err := b.findAll(bleve.NewDocIDQuery([]string{id}))
+	if err != nil || len(images) == 0 {
+		return nil, err
+	}
+	return images[0], nil
+}
+
+// Find returns all the images having the values of the filter as terms of their keyword fields
+func (b *bleveBackend) Find(filter Filter) ([]*dim.IndexImage, error) {
+	clauses := make([]bleve.Query, 0, len(filter))
+	for field, value := range filter {
+		clauses = append(clauses, bleve.NewTermQuery(value).SetField(keywordField(field)))
+	}
+	return b.findAll(bleve.NewConjunctionQuery(clauses))
+}
+
+// findAll returns all the images matching the query with all their fields
+func (b *bleveBackend) findAll(q bleve.Query) ([]*dim.IndexImage, error) {
+	var images []*dim.IndexImage
+	for from, total := 0, 1; from < total; from += findPageSize {
+		rq := bleve.NewSearchRequestOptions(q, findPageSize, from, false)
+		rq.Fields = allFields
+		rq.SortBy([]string{"_id"})
+		sr, err := b.index.Search(rq)
+		if err != nil {
+			return nil, err
+		}
+		for _, h := range sr.Hits {
+			images = append(images, DocumentToImage(h))
+		}
+		total = int(sr.Total)
+	}
+	return images, nil
+}
+
+// Related finds the images whose layers key is a prefix of the given layers, or starts with them
+func (b *bleveBackend) Related(layers []string) ([]*dim.IndexImage, error) {
+	key := layersKey(layers)
+	clauses := make([]bleve.Query, 0, len(layers)+1)
+	for n := 1; n <= len(layers); n++ {
+		clauses = append(clauses, bleve.NewTermQuery(layersKey(layers[:n])).SetField("LayersKey"))
+	}
+	clauses = append(clauses, bleve.NewPrefixQuery(key+" ").SetField("LayersKey"))
+	return b.findAll(bleve.NewDisjunctionQuery(clauses))
+}
+
+// Walk calls f for each indexed image, in the order of their document IDs.
+// Documents are read from a single snapshot of the index, so that images written meanwhile are neither read twice nor skipped
+func (b *bleveBackend) Walk(f func(*dim.IndexImage) error) error {
+	i, _, err := b.index.Advanced()
+	if err != nil {
+		return err
+	}
+	var reader index.IndexReader
+	if reader, err = i.Reader(); err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	var ids index.DocIDReader
+	if ids, err = reader.DocIDReaderAll(); err != nil {
+		return err
+	}
+	defer ids.Close()
+
+	var internalID index.IndexInternalID
+	for internalID, err = ids.Next(); internalID != nil && err == nil; internalID, err = ids.Next() {
+		var id string
+		if id, err = reader.ExternalID(internalID); err != nil {
+			return err
+		}
+		var doc *document.Document
+		if doc, err = reader.Document(id); err != nil {
+			return err
+		}
+		if doc == nil {
+			continue
+		}
+		if err = f(DocumentToImage(storedFields(doc))); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// storedFields returns a match holding the stored fields of a document, converted as search results convert them
+func storedFields(doc *document.Document) *search.DocumentMatch {
+	match := &search.DocumentMatch{ID: doc.ID}
+	for _, field := range doc.Fields {
+		var value interface{}
+		switch field := field.(type) {
+		case *document.TextField:
+			value = string(field.Value())
+		case *document.NumericField:
+			if n, err := field.Number(); err == nil {
+				value = n
+			}
+		case *document.DateTimeField:
+			if t, err := field.DateTime(); err == nil {
+				value = t.Format(time.RFC3339)
+			}
+		}
+		if value != nil {
+			match.AddFieldValue(field.Name(), value)
+		}
+	}
+	return match
+}
+
+// Search builds the bleve query of a search request with BuildQuery and runs it
+func (b *bleveBackend) Search(rq *SearchRequest) (*dim.IndexResults, error) {
+	var err error
+	var sr *bleve.SearchResult
+	var query bleve.Query
+	if query, err = BuildQuery(rq.Query, rq.Advanced); err != nil {
+		return nil, err
+	}
+	if rq.Platform != "" {
+		query = bleve.NewConjunctionQuery([]bleve.Query{query, platformQuery(rq.Platform)})
+	}
+	if len(rq.IDs) > 0 {
+		query = bleve.NewConjunctionQuery([]bleve.Query{bleve.NewDocIDQuery(rq.IDs), query})
+	}
+	request := bleve.NewSearchRequestOptions(query, rq.Size, rq.Offset, false)
+	request.Fields = []string{"Name", "Tags", "Labels", "Annotations", "Envs"}
+	if len(rq.Fields) > 0 {
+		// Stored documents are read as a whole anyway, so loading all their fields costs no more than the requested ones
+		request.Fields = allFields
+	}
+	var order []string
+	if order, err = NewSortOrder(rq.Sort); err != nil {
+		return nil, err
+	}
+	request.SortBy(order)
+	now := time.Now()
+	for _, spec := range rq.Facets {
+		var name string
+		var fr *bleve.FacetRequest
+		if name, fr, err = NewFacetRequest(spec, now); err != nil {
+			return nil, err
+		}
+		request.AddFacet(name, fr)
+	}
+	l := logrus.WithField("request", request).WithField("query", request.Query)
+	l.Debugln("Running search")
+	if sr, err = b.index.Search(request); err != nil {
+		return nil, fmt.Errorf("Error occured when processing search : %v", err)
+	}
+
+	if len(rq.Fields) > 0 {
+		detailFields := make([]string, len(rq.Fields))
+		copy(detailFields, rq.Fields)
+		for _, f := range []string{"Name", "Tags"} {
+			if !utils.ListContains(detailFields, f) {
+				detailFields = append(detailFields, f)
+			}
+		}
+
+		for _, h := range sr.Hits {
+			h.Fields = selectFields(h.Fields, detailFields)
+		}
+	}
+
+	results := &dim.IndexResults{Total: sr.Total, Facets: facetsToResults(sr.Facets)}
+	results.Images = buildResults(sr)
+
+	return results, nil
+}
+
+// Terms reads the terms of the field holding the whole values of an image field in its dictionary
+func (b *bleveBackend) Terms(field, prefix string) ([]dim.FacetTerm, error) {
+	field = keywordField(field)
+	var dict index.FieldDict
+	var err error
+	// An empty prefix does not match anything in the field dictionary
+	if prefix == "" {
+		dict, err = b.index.FieldDict(field)
+	} else {
+		dict, err = b.index.FieldDictPrefix(field, []byte(prefix))
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer dict.Close()
+
+	terms := make([]dim.FacetTerm, 0)
+	var entry *index.DictEntry
+	for entry, err = dict.Next(); entry != nil && err == nil; entry, err = dict.Next() {
+		// Terms of removed images may be kept with no count
+		if entry.Count > 0 {
+			terms = append(terms, dim.FacetTerm{Term: entry.Term, Count: int(entry.Count)})
+		}
+	}
+	return terms, err
+}
+
+// Count returns the number of documents of the index
+func (b *bleveBackend) Count() (uint64, error) {
+	return b.index.DocCount()
+}
+
+// Close closes the index
+func (b *bleveBackend) Close() error {
+	return b.index.Close()
+}
+
+// platformQuery matches the images of the given platform and of all its variants
+func platformQuery(platform string) bleve.Query {
+	return bleve.NewDisjunctionQuery([]bleve.Query{bleve.NewTermQuery(platform).SetField("Platform"), bleve.NewPrefixQuery(platform + "/").SetField("Platform")})
+}
+
+// digestRegexp matches the algorithm part of a digest used as a field value (like Layers:sha256:...).
+// Its colon must be escaped so the query string parser does not fail
+var digestRegexp = regexp.MustCompile(`:(sha256|sha384|sha512):`)
+
+// BuildQuery returns the query object corresponding to given parameters.
+// The advanced search is parsed with ParseQuery
+func BuildQuery(nameTag, advanced string) (bleve.Query, error) {
+	l := logrus.WithFields(logrus.Fields{"nameTag": nameTag, "advanced": advanced})
+	l.Debugln("Building query clause")
+
+	if nameTag == "*" || advanced == "*" {
+		return bleve.NewMatchAllQuery(), nil
+	}
+
+	bq := make([]bleve.Query, 0, 3)
+
+	name := nameTag
+	tag := nameTag
+
+	if split := strings.Split(nameTag, ":"); len(split) == 2 {
+		name = split[0]
+		tag = split[1]
+	}
+
+	if nameTag != "" {
+		l.WithFields(logrus.Fields{"name": name, "tag": tag}).Debugln("Adding name and tag clauses")
+		bq = append(bq, bleve.NewFuzzyQuery(name).SetField("Name"), bleve.NewMatchQuery(tag).SetField("Tag"))
+	}
+
+	if advanced != "" {
+		l.Debugln("Adding advanced clause")
+		q, err := ParseQuery(advanced, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		bq = append(bq, q)
+	}
+
+	logrus.WithField("queries", bq).Debugln("Returning query with should clauses")
+	return bleve.NewBooleanQuery(nil, bq, nil), nil
+
+}
+
+func buildResults(sr *bleve.SearchResult) []*dim.IndexImage {
+	images := make([]*dim.IndexImage, 0, sr.Total)
+	for _, h := range sr.Hits {
+		images = append(images, DocumentToImage(h))
+	}
+	return images
+}
+
+// selectFields keeps the requested stored fields of a document.
+// Requesting Labels, Annotations or Envs keeps the Label.*, Annotation.* or Env.* fields as well
+func selectFields(stored map[string]interface{}, fields []string) map[string]interface{} {
+	selected := make(map[string]interface{}, len(stored))
+	for name, value := range stored {
+		keep := utils.ListContains(fields, name)
+		if i := strings.Index(name, "."); !keep && i > 0 {
+			if m, ok := mapFields[strings.ToLower(name[:i])]; ok && m.values == name[:i+1] {
+				keep = utils.ListContains(fields, m.keys)
+			}
+		}
+		if keep {
+			selected[name] = value
+		}
+	}
+	return selected
+}
+
+// allFields loads all the stored fields of a document, including the Label.*, Annotation.* and Env.* ones
+var allFields = []string{"*"}
+
+// DocumentToImage reads all fields of the given DocumentMatch and returns an image
+func DocumentToImage(h *search.DocumentMatch) *dim.IndexImage {
+	logrus.WithField("hit", h).Debugln("Entering documentToSearchResult")
+	result := &dim.IndexImage{
+		Name: h.Fields["Name"].(string),
+		Tags: storedStrings(h.Fields["Tags"]),
+	}
+	normalizeTags(result)
+	result.ID, _ = h.Fields["ID"].(string)
+	result.Registry, _ = h.Fields["Registry"].(string)
+	result.Comment, _ = h.Fields["Comment"].(string)
+	result.Author, _ = h.Fields["Author"].(string)
+
+	if h.Fields["Created"] != nil {
+		if t, err := time.Parse(time.RFC3339, h.Fields["Created"].(string)); err == nil {
+			result.Created = t
+		} else {
+			logrus.WithError(err).WithField("time", h.Fields["Created"].(string)).Errorln("Failed to parse time")
+		}
+	}
+
+	labels := make(map[string]string, 10)
+	annotations := make(map[string]string, 10)
+	envs := make(map[string]string, 10)
+	for k, v := range h.Fields {
+		if strings.HasPrefix(k, "Label.") {
+			labels[strings.TrimPrefix(k, "Label.")] = v.(string)
+		} else if strings.HasPrefix(k, "Annotation.") {
+			annotations[strings.TrimPrefix(k, "Annotation.")] = v.(string)
+		} else if strings.HasPrefix(k, "Env.") {
+			envs[strings.TrimPrefix(k, "Env.")] = v.(string)
+		}
+	}
+
+	if len(labels) > 0 {
+		result.Label = labels
+		result.Labels = utils.Keys(labels)
+	}
+	if len(annotations) > 0 {
+		result.Annotation = annotations
+		result.Annotations = utils.Keys(annotations)
+	}
+	if h.Fields["Volumes"] != nil {
+		switch vol := h.Fields["Volumes"].(type) {
+		case string:
+			result.Volumes = []string{vol}
+		case []interface{}:
+			result.Volumes = make([]string, len(vol))
+			for i, volume := range vol {
+				result.Volumes[i] = volume.(string)
+			}
+		}
+	}
+	if h.Fields["ExposedPorts"] != nil {
+		switch ports := h.Fields["ExposedPorts"].(type) {
+		case float64:
+			result.ExposedPorts = []int{int(ports)}
+		case []interface{}:
+			result.ExposedPorts = make([]int, len(ports))
+			for i, port := range ports {
+				result.ExposedPorts[i] = int(port.(float64))
+			}
+		}
+	}
+	if len(envs) > 0 {
+		result.Env = envs
+		result.Envs = utils.Keys(envs)
+	}
+	result.Entrypoint = storedStrings(h.Fields["Entrypoint"])
+	result.Cmd = storedStrings(h.Fields["Cmd"])
+	result.User, _ = h.Fields["User"].(string)
+	result.WorkingDir, _ = h.Fields["WorkingDir"].(string)
+	result.OS, _ = h.Fields["OS"].(string)
+	result.Architecture, _ = h.Fields["Architecture"].(string)
+	result.Variant, _ = h.Fields["Variant"].(string)
+	result.Platform, _ = h.Fields["Platform"].(string)
+	result.ListDigest, _ = h.Fields["ListDigest"].(string)
+	result.Healthcheck, _ = h.Fields["Healthcheck"].(string)
+	if h.Fields["Size"] != nil {
+		result.Size = int64(h.Fields["Size"].(float64))
+	}
+	result.Layers = storedStrings(h.Fields["Layers"])
+	result.History = storedStrings(h.Fields["History"])
+	result.Parent, _ = h.Fields["Parent"].(string)
+	result.BasedOn = storedStrings(h.Fields["BasedOn"])
+	if h.Fields["LayerSizes"] != nil {
+		switch sizes := h.Fields["LayerSizes"].(type) {
+		case float64:
+			result.LayerSizes = []int64{int64(sizes)}
+		case []interface{}:
+			result.LayerSizes = make([]int64, len(sizes))
+			for i, size := range sizes {
+				result.LayerSizes[i] = int64(size.(float64))
+			}
+		}
+	}
+
+	return result
+}
+
+// storedStrings converts a stored field holding one or many strings into a slice
+func storedStrings(field interface{}) []string {
+	switch values := field.(type) {
+	case string:
+		return []string{values}
+	case []interface{}:
+		result := make([]string, len(values))
+		for i, v := range values {
+			result[i] = v.(string)
+		}
+		return result
+	}
+	return nil
+}
@@ -18,6 +18,7 @@ import (
 	"io/ioutil"
 	"sync"
 	"text/template"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/nhurel/dim/lib"
@@ -25,11 +26,75 @@ import (
 
 // Config holds index configuration
 type Config struct {
+	// Backend is the name of the backend storing the index (see RegisterBackend). The index is stored in Directory when empty
+	Backend string
 	// Directory where to write index data
 	Directory string
+	// Rebuild drops any index found in Directory instead of reusing it
+	Rebuild bool
+	// QueuePath is the file where pending notifications are persisted. They are kept in memory only when empty
+	QueuePath string
+	// MaxAttempts is the number of times a notification is processed before being moved to the dead letters
+	MaxAttempts int
+	// MaxDeadLetters is the number of failed notifications kept aside. The oldest ones are dropped beyond it
+	MaxDeadLetters int
+	// ResyncInterval is the delay between two reconciliations with the registry. Periodic resync is disabled when zero
+	ResyncInterval time.Duration
+	// PollInterval is the delay between two polls of the registry for changes, for registries that cannot send notifications. Polling is disabled when zero
+	PollInterval time.Duration
+	// BatchSize is the number of crawled images written to the index at once while building it. DefaultBatchSize is used when zero
+	BatchSize int
+	// BatchInterval is the maximum delay before crawled images are written to the index while building it. DefaultBatchInterval is used when zero
+	BatchInterval time.Duration
+	// ParseWorkers is the number of images parsed concurrently while building the index. DefaultParseWorkers is used when zero
+	ParseWorkers int
+	// TagWorkers is the number of tags read concurrently from the registries while building the index. DefaultTagWorkers is used when zero
+	TagWorkers int
 	// Hooks to trigger on event
-	Hooks   []*Hook
-	funcMap template.FuncMap
+	Hooks []*Hook
+	// Searches are evaluated against each pushed image to trigger their action when the image matches
+	Searches []*SavedSearch
+	funcMap  template.FuncMap
+}
+
+// Defaults of the settings used while building the index
+const (
+	DefaultBatchSize     = 500
+	DefaultBatchInterval = 5 * time.Second
+	DefaultParseWorkers  = 3
+	DefaultTagWorkers    = 10
+)
+
+// batchSize returns the number of images written to the index at once while building it
+func (c *Config) batchSize() int {
+	if c.BatchSize > 0 {
+		return c.BatchSize
+	}
+	return DefaultBatchSize
+}
+
+// batchInterval returns the maximum delay before crawled images are written to the index
+func (c *Config) batchInterval() time.Duration {
+	if c.BatchInterval > 0 {
+		return c.BatchInterval
+	}
+	return DefaultBatchInterval
+}
+
+// parseWorkers returns the number of images parsed concurrently while building the index
+func (c *Config) parseWorkers() int {
+	if c.ParseWorkers > 0 {
+		return c.ParseWorkers
+	}
+	return DefaultParseWorkers
+}
+
+// tagWorkers returns the number of tags read concurrently from the registries while building the index
+func (c *Config) tagWorkers() int {
+	if c.TagWorkers > 0 {
+		return c.TagWorkers
+	}
+	return DefaultTagWorkers
 }
 
 // Hook evals the template string  when an event of its type occurs
@@ -0,0 +1,220 @@
+// Copyright 2016
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package index
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nhurel/dim/lib"
+	"github.com/nhurel/dim/lib/utils"
+)
+
+// Export formats
+const (
+	// JSONFormat writes one JSON document per image
+	JSONFormat = "json"
+	// CSVFormat writes one CSV row per image, after a header row with the field names
+	CSVFormat = "csv"
+)
+
+// exportFields lists the fields of IndexImage in their declaration order
+var exportFields = func() []string {
+	t := reflect.TypeOf(dim.IndexImage{})
+	fields := make([]string, t.NumField())
+	for i := range fields {
+		fields[i] = t.Field(i).Name
+	}
+	return fields
+}()
+
+// Export writes all the indexed images to w in the given format, JSON lines by default.
+// When fields is not empty, only these fields of the images are written.
+// Unknown formats and fields return a dim.QueryError before anything is written
+func (idx *Index) Export(w io.Writer, format string, fields []string) error {
+	if format == "" {
+		format = JSONFormat
+	}
+	if format != JSONFormat && format != CSVFormat {
+		return &dim.QueryError{Message: fmt.Sprintf("Unknown export format %s. Only %s and %s are supported", format, JSONFormat, CSVFormat)}
+	}
+	for _, f := range fields {
+		if !fieldExported(f) {
+			return &dim.QueryError{Message: fmt.Sprintf("Unknown image field %s", f)}
+		}
+	}
+
+	var write func(img *dim.IndexImage) error
+	var flush func() error
+	switch format {
+	case CSVFormat:
+		if len(fields) == 0 {
+			fields = exportFields
+		}
+		cw := csv.NewWriter(w)
+		if err := cw.Write(fields); err != nil {
+			return fmt.Errorf("Failed to export images : %v", err)
+		}
+		write = func(img *dim.IndexImage) error {
+			return cw.Write(csvRecord(img, fields))
+		}
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	default:
+		bw := bufio.NewWriter(w)
+		enc := json.NewEncoder(bw)
+		write = func(img *dim.IndexImage) error {
+			if len(fields) == 0 {
+				return enc.Encode(img)
+			}
+			return enc.Encode(jsonRecord(img, fields))
+		}
+		flush = bw.Flush
+	}
+
+	var writeErr error
+	err := idx.Backend.Walk(func(img *dim.IndexImage) error {
+		writeErr = write(img)
+		return writeErr
+	})
+	if writeErr != nil {
+		return fmt.Errorf("Failed to export images : %v", writeErr)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to read indexed images : %v", err)
+	}
+	if err = flush(); err != nil {
+		return fmt.Errorf("Failed to export images : %v", err)
+	}
+	return nil
+}
+
+func fieldExported(field string) bool {
+	for _, f := range exportFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonRecord returns the given fields of an image
+func jsonRecord(img *dim.IndexImage, fields []string) map[string]interface{} {
+	v := reflect.ValueOf(img).Elem()
+	record := make(map[string]interface{}, len(fields))
+	for _, f := range fields {
+		record[f] = v.FieldByName(f).Interface()
+	}
+	return record
+}
+
+// csvRecord returns the given fields of an image as strings.
+// Lists are joined with commas and maps are written as comma separated key=value pairs
+func csvRecord(img *dim.IndexImage, fields []string) []string {
+	v := reflect.ValueOf(img).Elem()
+	record := make([]string, len(fields))
+	for i, f := range fields {
+		record[i] = csvValue(v.FieldByName(f))
+	}
+	return record
+}
+
+func csvValue(v reflect.Value) string {
+	switch value := v.Interface().(type) {
+	case string:
+		return value
+	case int64:
+		return strconv.FormatInt(value, 10)
+	case time.Time:
+		if value.IsZero() {
+			return ""
+		}
+		return value.Format(time.RFC3339)
+	case []string:
+		return strings.Join(value, ",")
+	case map[string]string:
+		pairs := make([]string, 0, len(value))
+		for k, val := range value {
+			pairs = append(pairs, fmt.Sprintf("%s=%s", k, val))
+		}
+		sort.Strings(pairs)
+		return strings.Join(pairs, ",")
+	}
+
+	if v.Kind() == reflect.Slice {
+		values := make([]string, v.Len())
+		for i := range values {
+			values[i] = fmt.Sprint(v.Index(i).Interface())
+		}
+		return strings.Join(values, ",")
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// importBatchSize is the number of images indexed at once when importing
+const importBatchSize = 100
+
+// Import indexes the images read from r, written as JSON lines by Export without field selection.
+// It returns the number of imported images
+func (idx *Index) Import(r io.Reader) (int, error) {
+	dec := json.NewDecoder(r)
+	batch := make(map[string]*dim.IndexImage, importBatchSize)
+	count := 0
+	for line := 1; ; line++ {
+		img := &dim.IndexImage{}
+		if err := dec.Decode(img); err == io.EOF {
+			break
+		} else if err != nil {
+			return count, fmt.Errorf("Failed to read image #%d : %v", line, err)
+		}
+		if img.ID == "" || img.Name == "" || len(img.Tags) == 0 {
+			return count, fmt.Errorf("Image #%d must have an ID, a Name and Tags", line)
+		}
+
+		// Lists of keys are derived from the maps so that images exported with a subset of fields stay searchable
+		if len(img.Label) > 0 {
+			img.Labels = utils.Keys(img.Label)
+		}
+		if len(img.Annotation) > 0 {
+			img.Annotations = utils.Keys(img.Annotation)
+		}
+		if len(img.Env) > 0 {
+			img.Envs = utils.Keys(img.Env)
+		}
+		normalizeTags(img)
+		batch[documentID(img)] = img
+
+		if len(batch) == importBatchSize {
+			if err := idx.Backend.Write(batch, nil); err != nil {
+				return count, fmt.Errorf("Failed to index images : %v", err)
+			}
+			count += len(batch)
+			batch = make(map[string]*dim.IndexImage, importBatchSize)
+		}
+	}
+	if err := idx.Backend.Write(batch, nil); err != nil {
+		return count, fmt.Errorf("Failed to index images : %v", err)
+	}
+	return count + len(batch), nil
+}
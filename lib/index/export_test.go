@@ -0,0 +1,110 @@
+// Copyright 2016
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package index
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+
+	"github.com/nhurel/dim/lib"
+	"github.com/nhurel/dim/lib/index/indextest"
+	. "gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestExportJSON(c *C) {
+	out := &bytes.Buffer{}
+	c.Assert(s.index.Export(out, JSONFormat, nil), IsNil)
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	c.Assert(lines, HasLen, len(images))
+	img := &dim.IndexImage{}
+	c.Assert(json.Unmarshal([]byte(lines[0]), img), IsNil)
+	c.Assert(img.Name, Equals, "centos")
+	c.Assert(img.Label, DeepEquals, images[0].Label)
+
+	out.Reset()
+	c.Assert(s.index.Export(out, JSONFormat, []string{"Name", "Tags"}), IsNil)
+	record := make(map[string]interface{})
+	c.Assert(json.Unmarshal([]byte(strings.SplitN(out.String(), "\n", 2)[0]), &record), IsNil)
+	c.Assert(record, DeepEquals, map[string]interface{}{"Name": "centos", "Tags": []interface{}{"centos6"}})
+}
+
+func (s *TestSuite) TestExportSnapshot(c *C) {
+	i, err := indextest.MockIndex(ImageMapping)
+	c.Assert(err, IsNil)
+	idx := &Index{Backend: NewBleveBackend(i), Config: &Config{}}
+	defer idx.Close()
+	for n := range images {
+		idx.IndexImage(&images[n])
+	}
+
+	// Images written while the index is read are not exported, and the exported ones are read once
+	var names []string
+	err = idx.Backend.Walk(func(img *dim.IndexImage) error {
+		names = append(names, img.Name)
+		if img.Name == "centos" {
+			idx.IndexImage(&dim.IndexImage{ID: "new", Name: "debian", Tags: []string{"8"}})
+			moved := images[2]
+			moved.Name = "zmysql"
+			idx.IndexImage(&moved)
+		}
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(names, DeepEquals, []string{"centos", "httpd", "mysql"})
+	count, err := idx.Backend.Count()
+	c.Assert(err, IsNil)
+	c.Assert(count, Equals, uint64(len(images)+2))
+}
+
+func (s *TestSuite) TestExportCSV(c *C) {
+	out := &bytes.Buffer{}
+	c.Assert(s.index.Export(out, CSVFormat, []string{"Name", "Tags", "Label", "LayerSizes"}), IsNil)
+
+	records, err := csv.NewReader(out).ReadAll()
+	c.Assert(err, IsNil)
+	c.Assert(records, HasLen, len(images)+1)
+	c.Assert(records[0], DeepEquals, []string{"Name", "Tags", "Label", "LayerSizes"})
+	c.Assert(records[1], DeepEquals, []string{"centos", "centos6", "family=rhel,type=base", "2048"})
+}
+
+func (s *TestSuite) TestExportInvalid(c *C) {
+	err := s.index.Export(&bytes.Buffer{}, "xml", nil)
+	c.Assert(err, FitsTypeOf, &dim.QueryError{})
+	err = s.index.Export(&bytes.Buffer{}, CSVFormat, []string{"Name", "Unknown"})
+	c.Assert(err, FitsTypeOf, &dim.QueryError{})
+}
+
+func (s *TestSuite) TestImport(c *C) {
+	out := &bytes.Buffer{}
+	c.Assert(s.index.Export(out, JSONFormat, nil), IsNil)
+
+	i, err := indextest.MockIndex(ImageMapping)
+	c.Assert(err, IsNil)
+	imported := &Index{Backend: NewBleveBackend(i), Config: &Config{}}
+	defer imported.Close()
+
+	count, err := imported.Import(out)
+	c.Assert(err, IsNil)
+	c.Assert(count, Equals, len(images))
+
+	results, err := imported.SearchImages("", "Label.type:base", "", nil, nil, nil, 0, 10)
+	c.Assert(err, IsNil)
+	c.Assert(results.Total, Equals, uint64(1))
+
+	_, err = imported.Import(strings.NewReader(`{"ID": "123", "Name": "no-tag"}`))
+	c.Assert(err, NotNil)
+}
@@ -0,0 +1,124 @@
+// Copyright 2016
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package index
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search"
+	"github.com/nhurel/dim/lib"
+)
+
+// DefaultFacetSize is the number of terms returned by a facet when no size is given
+const DefaultFacetSize = 10
+
+const day = 24 * time.Hour
+
+var createdRanges = []struct {
+	name       string
+	start, end time.Duration
+}{
+	{"< 1 day", day, 0},
+	{"1 day - 1 week", 7 * day, day},
+	{"1 week - 1 month", 30 * day, 7 * day},
+	{"1 month - 1 year", 365 * day, 30 * day},
+	{"> 1 year", 0, 365 * day},
+}
+
+const (
+	mb = float64(1 << 20)
+	gb = float64(1 << 30)
+)
+
+var sizeRanges = []struct {
+	name     string
+	min, max float64
+}{
+	{"< 10MB", 0, 10 * mb},
+	{"10MB - 100MB", 10 * mb, 100 * mb},
+	{"100MB - 500MB", 100 * mb, 500 * mb},
+	{"500MB - 1GB", 500 * mb, gb},
+	{"> 1GB", gb, 0},
+}
+
+// NewFacetRequest parses a facet specification FIELD[:SIZE] and returns the corresponding bleve facet request.
+// Created and Size fields are split in predefined ranges, any other field returns the SIZE most frequent terms
+func NewFacetRequest(spec string, now time.Time) (string, *bleve.FacetRequest, error) {
+	field, size := spec, DefaultFacetSize
+	if i := strings.LastIndex(spec, ":"); i >= 0 {
+		var err error
+		field = spec[:i]
+		if size, err = strconv.Atoi(spec[i+1:]); err != nil || size <= 0 {
+			return "", nil, &dim.QueryError{Message: fmt.Sprintf("Invalid facet size in %s", spec)}
+		}
+	}
+	if field == "" {
+		return "", nil, &dim.QueryError{Message: fmt.Sprintf("No field given for facet %s", spec)}
+	}
+
+	fr := bleve.NewFacetRequest(keywordField(field), size)
+	switch field {
+	case "Created":
+		for _, r := range createdRanges {
+			var start, end time.Time
+			if r.start > 0 {
+				start = now.Add(-r.start)
+			}
+			if r.end > 0 {
+				end = now.Add(-r.end)
+			}
+			fr.AddDateTimeRange(r.name, start, end)
+		}
+	case "Size":
+		for _, r := range sizeRanges {
+			var min, max *float64
+			if r.min > 0 {
+				min = new(float64)
+				*min = r.min
+			}
+			if r.max > 0 {
+				max = new(float64)
+				*max = r.max
+			}
+			fr.AddNumericRange(r.name, min, max)
+		}
+	}
+	return field, fr, nil
+}
+
+// facetsToResults converts bleve facet results into dim facets
+func facetsToResults(facets search.FacetResults) map[string]*dim.Facet {
+	if len(facets) == 0 {
+		return nil
+	}
+	results := make(map[string]*dim.Facet, len(facets))
+	for name, f := range facets {
+		facet := &dim.Facet{Field: name, Total: f.Total, Missing: f.Missing, Other: f.Other}
+		for _, t := range f.Terms {
+			facet.Terms = append(facet.Terms, dim.FacetTerm{Term: t.Term, Count: t.Count})
+		}
+		for _, r := range f.DateRanges {
+			facet.Terms = append(facet.Terms, dim.FacetTerm{Term: r.Name, Count: r.Count})
+		}
+		for _, r := range f.NumericRanges {
+			facet.Terms = append(facet.Terms, dim.FacetTerm{Term: r.Name, Count: r.Count})
+		}
+		results[name] = facet
+	}
+	return results
+}
@@ -33,6 +33,7 @@ func Parse(name string, img *dim.RegistryImage) *dim.IndexImage {
 		ID:      img.Digest,
 		Name:    name,
 		Tag:     img.Tag,
+		Tags:    []string{img.Tag},
 		Comment: img.Comment,
 		Created: img.Created,
 		Author:  img.Author,
@@ -41,6 +42,8 @@ func Parse(name string, img *dim.RegistryImage) *dim.IndexImage {
 
 	parsed.Label = utils.FilterValues(img.Config.Labels, "")
 	parsed.Labels = utils.Keys(parsed.Label)
+	parsed.Annotation = utils.FilterValues(img.Annotations, "")
+	parsed.Annotations = utils.Keys(parsed.Annotation)
 
 	volumes := make([]string, 0, len(img.Config.Volumes))
 	for v := range img.Config.Volumes {
@@ -64,12 +67,73 @@ func Parse(name string, img *dim.RegistryImage) *dim.IndexImage {
 	}
 	parsed.ExposedPorts = ports
 
+	parsed.Entrypoint = img.Config.Entrypoint
+	parsed.Cmd = img.Config.Cmd
+	// Processes of images without USER instruction run as root
+	if parsed.User = img.Config.User; parsed.User == "" {
+		parsed.User = "root"
+	}
+	parsed.WorkingDir = img.Config.WorkingDir
+	parsed.OS = img.OS
+	parsed.Architecture = img.Architecture
+	parsed.Variant = img.Variant
+	parsed.Platform = img.Platform()
+	parsed.ListDigest = img.ListDigest
+	// A NONE healthcheck disables the one inherited from the base image
+	if hc := img.Config.Healthcheck; hc != nil && len(hc.Test) > 1 && hc.Test[0] != "NONE" {
+		parsed.Healthcheck = strings.Join(hc.Test[1:], " ")
+	}
+
 	parsed.Size = img.Size
 
+	parsed.History = make([]string, 0, len(img.History))
+	for _, h := range img.History {
+		if h.CreatedBy != "" {
+			parsed.History = append(parsed.History, h.CreatedBy)
+		}
+	}
+
+	parsed.Layers = make([]string, len(img.Layers))
+	parsed.LayerSizes = make([]int64, len(img.Layers))
+	for i, layer := range img.Layers {
+		parsed.Layers[i] = layer.Digest
+		parsed.LayerSizes[i] = layer.Size
+	}
+
 	logrus.WithField("image", parsed).Debugln("Docker image parsed")
 	return parsed
 }
 
+// imageDocument is the document indexed for an image.
+// It repeats the author and the values of the Label, Annotation and Env maps in fields indexing them as whole values, used by facets and exact matches
+type imageDocument struct {
+	dim.IndexImage
+	// AuthorValue is empty when the image has no author, so that it is counted as missing in facets
+	AuthorValue []string
+	// LayersKey identifies the list of layers of the image, so that the images built on it are found with a prefix query
+	LayersKey       []string
+	LabelValue      map[string]string
+	AnnotationValue map[string]string
+	EnvValue        map[string]string
+}
+
+// newImageDocument returns the document to index for an image
+func newImageDocument(image *dim.IndexImage) *imageDocument {
+	doc := &imageDocument{
+		IndexImage:      *image,
+		LabelValue:      image.Label,
+		AnnotationValue: image.Annotation,
+		EnvValue:        image.Env,
+	}
+	if image.Author != "" {
+		doc.AuthorValue = []string{image.Author}
+	}
+	if len(image.Layers) > 0 {
+		doc.LayersKey = []string{layersKey(image.Layers)}
+	}
+	return doc
+}
+
 // ImageMapping is a document mapping that specifies how to index an image
 var ImageMapping *bleve.DocumentMapping
 
@@ -81,14 +145,26 @@ func init() {
 	tagMapping.Analyzer = keyword_analyzer.Name
 	tagMapping.IncludeInAll = true
 	tagMapping.Store = true
-	ImageMapping.AddFieldMappingsAt("Tag", tagMapping)
+	// Tag indexes every tag of the image so that Tag:latest matches whatever tags the image has
+	tagAliasMapping := bleve.NewTextFieldMapping()
+	tagAliasMapping.Name = "Tag"
+	tagAliasMapping.Analyzer = keyword_analyzer.Name
+	tagAliasMapping.IncludeInAll = false
+	tagAliasMapping.Store = false
+	ImageMapping.AddFieldMappingsAt("Tags", tagMapping, tagAliasMapping)
 
 	nameMapping := bleve.NewTextFieldMapping()
 	nameMapping.Analyzer = simple_analyzer.Name
 	nameMapping.IncludeInAll = true
 	nameMapping.Store = true
-	ImageMapping.AddFieldMappingsAt("Name", nameMapping)
-	ImageMapping.AddFieldMappingsAt("FullName", nameMapping)
+
+	// Repository indexes the whole name as a single term so it can be used in facets
+	repositoryMapping := bleve.NewTextFieldMapping()
+	repositoryMapping.Name = "Repository"
+	repositoryMapping.Analyzer = keyword_analyzer.Name
+	repositoryMapping.IncludeInAll = false
+	repositoryMapping.Store = false
+	ImageMapping.AddFieldMappingsAt("Name", nameMapping, repositoryMapping)
 
 	idMapping := bleve.NewTextFieldMapping()
 	idMapping.Analyzer = keyword_analyzer.Name
@@ -96,6 +172,21 @@ func init() {
 	idMapping.IncludeInAll = false
 	idMapping.Index = true
 	ImageMapping.AddFieldMappingsAt("ID", idMapping)
+	ImageMapping.AddFieldMappingsAt("Registry", idMapping)
+	ImageMapping.AddFieldMappingsAt("Layers", idMapping)
+	ImageMapping.AddFieldMappingsAt("User", idMapping)
+	ImageMapping.AddFieldMappingsAt("WorkingDir", idMapping)
+	ImageMapping.AddFieldMappingsAt("OS", idMapping)
+	ImageMapping.AddFieldMappingsAt("Architecture", idMapping)
+	ImageMapping.AddFieldMappingsAt("Variant", idMapping)
+	ImageMapping.AddFieldMappingsAt("Platform", idMapping)
+	ImageMapping.AddFieldMappingsAt("ListDigest", idMapping)
+	ImageMapping.AddFieldMappingsAt("Parent", idMapping)
+	ImageMapping.AddFieldMappingsAt("BasedOn", idMapping)
+	// Keys of the maps are indexed whole so that they can be suggested and counted in facets
+	ImageMapping.AddFieldMappingsAt("Labels", idMapping)
+	ImageMapping.AddFieldMappingsAt("Annotations", idMapping)
+	ImageMapping.AddFieldMappingsAt("Envs", idMapping)
 
 	authorMapping := bleve.NewTextFieldMapping()
 	authorMapping.Analyzer = simple_analyzer.Name
@@ -103,12 +194,26 @@ func init() {
 	authorMapping.Store = true
 	ImageMapping.AddFieldMappingsAt("Author", authorMapping)
 	ImageMapping.AddFieldMappingsAt("Volumes", authorMapping)
-	ImageMapping.AddFieldMappingsAt("Labels", authorMapping)
-	ImageMapping.AddFieldMappingsAt("Labels", idMapping)
 	ImageMapping.AddFieldMappingsAt("Label", authorMapping)
-	ImageMapping.AddFieldMappingsAt("Envs", authorMapping)
-	ImageMapping.AddFieldMappingsAt("Envs", idMapping)
+	ImageMapping.AddFieldMappingsAt("Annotation", authorMapping)
 	ImageMapping.AddFieldMappingsAt("Env", authorMapping)
+	ImageMapping.AddFieldMappingsAt("Entrypoint", authorMapping)
+	ImageMapping.AddFieldMappingsAt("Cmd", authorMapping)
+	ImageMapping.AddFieldMappingsAt("Healthcheck", authorMapping)
+
+	// Keys of the maps are only known at indexing time, so their whole values are indexed by dynamic keyword fields
+	for _, m := range mapFields {
+		valueMapping := bleve.NewDocumentMapping()
+		valueMapping.DefaultAnalyzer = keyword_analyzer.Name
+		ImageMapping.AddSubDocumentMapping(strings.TrimSuffix(m.exact, "."), valueMapping)
+	}
+
+	valueMapping := bleve.NewTextFieldMapping()
+	valueMapping.Analyzer = keyword_analyzer.Name
+	valueMapping.IncludeInAll = false
+	valueMapping.Store = false
+	ImageMapping.AddFieldMappingsAt("AuthorValue", valueMapping)
+	ImageMapping.AddFieldMappingsAt("LayersKey", valueMapping)
 
 	commentMapping := bleve.NewTextFieldMapping()
 	commentMapping.Analyzer = standard_analyzer.Name
@@ -116,6 +221,12 @@ func init() {
 	commentMapping.Store = true
 	ImageMapping.AddFieldMappingsAt("Comment", commentMapping)
 
+	historyMapping := bleve.NewTextFieldMapping()
+	historyMapping.Analyzer = standard_analyzer.Name
+	historyMapping.IncludeInAll = false
+	historyMapping.Store = true
+	ImageMapping.AddFieldMappingsAt("History", historyMapping)
+
 	dateMapping := bleve.NewDateTimeFieldMapping()
 	dateMapping.DateFormat = datetime_optional.Name
 	dateMapping.Store = true
@@ -128,6 +239,12 @@ func init() {
 	ImageMapping.AddFieldMappingsAt("ExposedPorts", portsMapping)
 	ImageMapping.AddFieldMappingsAt("Size", portsMapping)
 
+	layerSizesMapping := bleve.NewNumericFieldMapping()
+	layerSizesMapping.Store = true
+	layerSizesMapping.Index = false
+	layerSizesMapping.IncludeInAll = false
+	ImageMapping.AddFieldMappingsAt("LayerSizes", layerSizesMapping)
+
 	ImageMapping.DefaultAnalyzer = simple_analyzer.Name
 
 }
@@ -36,12 +36,20 @@ var img = &dim.RegistryImage{
 
 	Image: &image.Image{
 		V1Image: image.V1Image{
-			ID:      "imageID",
-			Parent:  "alpine:latest",
-			Comment: "comment",
-			Created: time.Now(),
-			Author:  "authorName",
+			ID:           "imageID",
+			Parent:       "alpine:latest",
+			Comment:      "comment",
+			Created:      time.Now(),
+			Author:       "authorName",
+			OS:           "linux",
+			Architecture: "arm64",
 			Config: &container.Config{
+				Entrypoint: []string{"httpd-foreground"},
+				Cmd:        []string{"-DFOREGROUND"},
+				WorkingDir: "/usr/local/apache2",
+				Healthcheck: &container.HealthConfig{
+					Test: []string{"CMD-SHELL", "curl -f http://localhost/"},
+				},
 				Env: []string{"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin:/usr/local/apache2/bin",
 					"HTTPD_PREFIX=usr/local/apache2",
 					"HTTPD_VERSION=2.4.18",
@@ -61,9 +69,21 @@ var img = &dim.RegistryImage{
 			},
 			Size: int64(2048),
 		},
+		History: []image.History{
+			{CreatedBy: "/bin/sh -c #(nop) ADD file:1234 in /"},
+			{Comment: "imported"},
+			{CreatedBy: "/bin/sh -c apt-get install -y openjdk-8-jdk"},
+		},
 	},
 	Tag:    "latest",
 	Digest: "imageDigest",
+	Layers: []dim.Layer{
+		{Digest: "sha256:base", Size: 1024},
+		{Digest: "sha256:top", Size: 512},
+	},
+	Annotations: map[string]string{
+		"org.opencontainers.image.source": "https://github.com/docker-library/httpd",
+	},
 }
 
 func (s *ImageTestSuite) TestParse(c *C) {
@@ -88,7 +108,40 @@ func (s *ImageTestSuite) TestParse(c *C) {
 	c.Assert(parsed.Label["label1"], Equals, "value1")
 	c.Assert(parsed.Label["label2.three.levels"], Equals, "value2")
 	c.Assert(parsed.Label["label3_2levels"], Equals, "value3")
+	c.Assert(parsed.Annotations, DeepEquals, []string{"org.opencontainers.image.source"})
+	c.Assert(parsed.Annotation["org.opencontainers.image.source"], Equals, "https://github.com/docker-library/httpd")
 	c.Assert(parsed.Size, Equals, img.Size)
+	c.Assert(parsed.Entrypoint, DeepEquals, []string{"httpd-foreground"})
+	c.Assert(parsed.Cmd, DeepEquals, []string{"-DFOREGROUND"})
+	c.Assert(parsed.User, Equals, "root")
+	c.Assert(parsed.WorkingDir, Equals, "/usr/local/apache2")
+	c.Assert(parsed.OS, Equals, "linux")
+	c.Assert(parsed.Architecture, Equals, "arm64")
+	c.Assert(parsed.Healthcheck, Equals, "curl -f http://localhost/")
+	c.Assert(parsed.Layers, DeepEquals, []string{"sha256:base", "sha256:top"})
+	c.Assert(parsed.LayerSizes, DeepEquals, []int64{1024, 512})
+	c.Assert(parsed.History, DeepEquals, []string{"/bin/sh -c #(nop) ADD file:1234 in /", "/bin/sh -c apt-get install -y openjdk-8-jdk"})
+}
+
+func (s *ImageTestSuite) TestParseRuntimeConfig(c *C) {
+	scenarii := []struct {
+		user                string
+		healthcheck         *container.HealthConfig
+		expectedUser        string
+		expectedHealthcheck string
+	}{
+		{"", nil, "root", ""},
+		{"www-data", nil, "www-data", ""},
+		{"1000:1000", &container.HealthConfig{Test: []string{"NONE"}}, "1000:1000", ""},
+		{"", &container.HealthConfig{Test: []string{"CMD", "pg_isready", "-U", "postgres"}}, "root", "pg_isready -U postgres"},
+	}
+
+	for _, scenario := range scenarii {
+		i := &dim.RegistryImage{Image: &image.Image{V1Image: image.V1Image{Config: &container.Config{User: scenario.user, Healthcheck: scenario.healthcheck}}}}
+		parsed := Parse("image", i)
+		c.Assert(parsed.User, Equals, scenario.expectedUser)
+		c.Assert(parsed.Healthcheck, Equals, scenario.expectedHealthcheck)
+	}
 }
 
 func SliceContains(s []int, c int) bool {
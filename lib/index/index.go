@@ -15,14 +15,12 @@ package index
 
 import (
 	"fmt"
-	"strings"
+	"regexp"
 	"sync"
 
 	"time"
 
 	"github.com/Sirupsen/logrus"
-	"github.com/blevesearch/bleve"
-	"github.com/blevesearch/bleve/search"
 	"github.com/docker/distribution/digest"
 	"github.com/docker/docker/reference"
 	"github.com/nhurel/dim/lib"
@@ -31,35 +29,127 @@ import (
 
 // Index manages indexation of docker images
 type Index struct {
-	// Index is the bleve.Index instance
-	bleve.Index
-	Config        *Config
-	RegClient     dim.RegistryClient
-	notifications chan *dim.NotificationJob
+	// Backend stores the indexed images and answers the searches
+	Backend   Backend
+	Config    *Config
+	RegClient dim.RegistryClient
+	// Registries are the clients of the indexed registries by name when several registries are indexed. RegClient is used otherwise
+	Registries    map[string]dim.RegistryClient
+	notifications chan *QueuedJob
+	queue         *Queue
+	// reconciling prevents reconciliations from running concurrently
+	reconciling sync.Mutex
+	// changes serializes the change sets, from the reading of the indexed documents to their writing
+	changes sync.Mutex
+	// drift is the report of the last reconciliation
+	drift   *dim.DriftReport
+	driftMu sync.RWMutex
+	stop    chan struct{}
+	// workers tracks the goroutines that must end before the index is closed
+	workers sync.WaitGroup
+	// progress tracks the current or last crawl of the registry
+	progress progress
+	searches savedSearches
 }
 
 type repoImage struct {
+	registry string
 	repoName string
 	image    *dim.RegistryImage
 }
 
-// New create a new instance to manage a index of a given registry into a specific directory
+// New create a new instance to manage a index of a given registry into a specific directory.
+// An index already present in the directory is reused unless cfg.Rebuild is set or it was created with an older mapping
 func New(cfg *Config, regClient dim.RegistryClient) (*Index, error) {
-	var i bleve.Index
+	return newIndex(cfg, regClient, nil)
+}
+
+// NewFederated creates a new instance to manage a single index of several registries, by name.
+// Each indexed image records the name of its registry and notifications must give the registry they come from
+func NewFederated(cfg *Config, registries map[string]dim.RegistryClient) (*Index, error) {
+	if len(registries) == 0 {
+		return nil, fmt.Errorf("No registry to index")
+	}
+	for name := range registries {
+		if !registryNameRegexp.MatchString(name) {
+			return nil, fmt.Errorf("Invalid registry name %s. Only letters, digits, '.', '_' and '-' are allowed", name)
+		}
+	}
+	return newIndex(cfg, nil, registries)
+}
+
+// registryNameRegexp matches the names allowed for federated registries
+var registryNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+
+func newIndex(cfg *Config, regClient dim.RegistryClient, registries map[string]dim.RegistryClient) (*Index, error) {
+	var b Backend
 	var err error
 
-	mapping := bleve.NewIndexMapping()
-	mapping.AddDocumentMapping("image", ImageMapping)
-	if i, err = bleve.New(cfg.Directory, mapping); err != nil {
+	if b, err = openBackend(cfg); err != nil {
+		return nil, err
+	}
+
+	notifications := make(chan *QueuedJob, 3)
+	index := &Index{Backend: b, RegClient: regClient, Registries: registries, notifications: notifications, Config: cfg, stop: make(chan struct{})}
+
+	if cfg.QueuePath != "" {
+		if index.queue, err = OpenQueue(cfg.QueuePath); err != nil {
+			b.Close()
+			return nil, err
+		}
+		if cfg.MaxAttempts > 0 {
+			index.queue.MaxAttempts = cfg.MaxAttempts
+		}
+		if cfg.MaxDeadLetters > 0 {
+			index.queue.MaxDeadLetters = cfg.MaxDeadLetters
+		}
+	}
+
+	if err = index.loadSearches(); err != nil {
+		if index.queue != nil {
+			index.queue.Close()
+		}
+		b.Close()
 		return nil, err
 	}
 
-	notifications := make(chan *dim.NotificationJob, 3)
-	index := &Index{Index: i, RegClient: regClient, notifications: notifications, Config: cfg}
+	if index.queue != nil {
+		index.run(index.dispatch)
+	}
+
 	index.loop(3)
+	if cfg.ResyncInterval > 0 {
+		index.run(func() { index.resync(cfg.ResyncInterval) })
+	}
+	if cfg.PollInterval > 0 {
+		index.run(func() { index.poll(cfg.PollInterval) })
+	}
 	return index, nil
 }
 
+// run starts a goroutine that Close waits for
+func (idx *Index) run(f func()) {
+	idx.workers.Add(1)
+	go func() {
+		defer idx.workers.Done()
+		f()
+	}()
+}
+
+// Close stops the notification workers, the periodic resync and polling, waits for them to end and closes the index and the notification queue
+func (idx *Index) Close() error {
+	if idx.stop != nil {
+		close(idx.stop)
+	}
+	idx.workers.Wait()
+	if idx.queue != nil {
+		if err := idx.queue.Close(); err != nil {
+			logrus.WithError(err).Errorln("Failed to close notification queue")
+		}
+	}
+	return idx.Backend.Close()
+}
+
 // Build creates a full index from the registry.
 // The returned channel is closed once all images are indexed so the caller can block until the index is built if needed
 func (idx *Index) Build() <-chan bool {
@@ -67,50 +157,56 @@ func (idx *Index) Build() <-chan bool {
 	done := make(chan bool, 1)
 
 	go func() {
-
-		repositories := idx.RegClient.WalkRepositories()
+		idx.progress.start(dim.IndexBuilding)
+		defer idx.progress.done()
 
 		// Channel to browse all repository images
 		images := make(chan *repoImage, 10)
 
-		// Waitgoup to watch when all repo images have been read and pushed to images channel
-		browseImgWg := sync.WaitGroup{}
-		for repository := range repositories {
-			browseImgWg.Add(1)
-			go func(repo dim.Repository) {
-				defer browseImgWg.Done()
-				for img := range repo.WalkImages() {
-					images <- &repoImage{repo.Named().Name(), img}
-				}
-			}(repository)
-		}
-
 		// Channel to push parsed images, erady o be indexed
 		tasks := make(chan *dim.IndexImage, 5)
 
+		// Parse and index the images while the catalog is walked, so that the crawl never waits for the whole catalog
+		go func() {
+			idx.indexTasks(tasks)
+			close(done)
+		}()
+
 		// Waitgroup to watch when all image have been parsed and pushed i tasks channel
 		parseImgWg := sync.WaitGroup{}
-		parseImgWg.Add(3)
-		for i := 0; i < 3; i++ {
+		workers := idx.Config.parseWorkers()
+		parseImgWg.Add(workers)
+		for i := 0; i < workers; i++ {
 			go func() {
 				defer parseImgWg.Done()
 				for img := range images {
-					logrus.WithField("reponame", img.repoName).Infoln("Indexing image")
-					tasks <- Parse(img.repoName, img.image)
+					logrus.WithFields(logrus.Fields{"registry": img.registry, "reponame": img.repoName}).Infoln("Indexing image")
+					tasks <- parseFrom(img.registry, img.repoName, img.image)
 				}
 			}()
 		}
 
-		batch := idx.NewBatch()
-		go func() {
-			for task := range tasks {
-				batch.Index(task.FullName, task)
-			}
-			if err := idx.Batch(batch); err != nil {
-				logrus.WithError(err).Errorln("Failed to index initial repository state")
+		// Bounds the number of concurrent requests sent to the registries
+		registrySlots := make(chan struct{}, idx.Config.tagWorkers())
+		// Bounds the number of repositories crawled at the same time
+		repoSlots := make(chan struct{}, idx.Config.tagWorkers())
+
+		// Waitgoup to watch when all repo images have been read and pushed to images channel
+		browseImgWg := sync.WaitGroup{}
+		for name, client := range idx.clients() {
+			for repository := range client.WalkRepositories() {
+				idx.progress.addRepository()
+				browseImgWg.Add(1)
+				repoSlots <- struct{}{}
+				go func(registry string, repo dim.Repository) {
+					defer func() {
+						<-repoSlots
+						browseImgWg.Done()
+					}()
+					idx.walkImages(registry, repo, images, registrySlots)
+				}(name, repository)
 			}
-			close(done)
-		}()
+		}
 
 		// When all images have been pushd to the channel, close it
 		browseImgWg.Wait()
@@ -124,290 +220,684 @@ func (idx *Index) Build() <-chan bool {
 	return done
 }
 
-// GetImage returns the docker image ready to be indexed
-func (idx *Index) GetImage(repository, tag string, dg digest.Digest) (*dim.IndexImage, error) {
-	named, _ := reference.ParseNamed(repository)
-	var repo dim.Repository
-	var err error
-	if repo, err = idx.RegClient.NewRepository(named); err != nil {
-		logrus.WithError(err).WithField("Repository", repository).Errorln("Failed get repository info")
-		return nil, err
-	}
+// indexTasks writes the parsed images to the index in batches of Config.BatchSize documents, or every Config.BatchInterval,
+// so that images become searchable while the registries are crawled
+func (idx *Index) indexTasks(tasks <-chan *dim.IndexImage) {
+	// Tags pointing to the same manifest are merged in a single document.
+	// Only the current batch is kept in memory : the documents already written are read back by the change set, so that a tag crawled later is added to them
+	batch := make(map[string]*dim.IndexImage)
 
-	var img *dim.RegistryImage
-	if img, err = repo.ImageFromManifest(dg, tag); err != nil {
-		logrus.WithError(err).Errorln("Failed to get image info from manifest")
-		return nil, err
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		images := make([]*dim.IndexImage, 0, len(batch))
+		for _, image := range batch {
+			images = append(images, image)
+		}
+		idx.changes.Lock()
+		cs := idx.newChangeSet()
+		err := cs.add(images)
+		if err == nil {
+			err = cs.commit()
+		}
+		idx.changes.Unlock()
+		if err != nil {
+			logrus.WithError(err).Errorln("Failed to index initial repository state")
+			idx.progress.fail(fmt.Errorf("Failed to index initial repository state : %v", err))
+		}
+		batch = make(map[string]*dim.IndexImage)
 	}
-	return Parse(repository, img), nil
-}
 
-// IndexImage adds a given image into the index
-func (idx *Index) IndexImage(image *dim.IndexImage) {
-	logrus.WithFields(logrus.Fields{"imageID": image.ID, "image.FullName": image.FullName}).Debugln("Indexing image")
-	idx.Index.Index(image.FullName, image)
+	ticker := time.NewTicker(idx.Config.batchInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case task, ok := <-tasks:
+			if !ok {
+				flush()
+				return
+			}
+			id := documentID(task)
+			if previous, ok := batch[id]; ok {
+				task.Tags = mergeTags(previous.Tags, task.Tags)
+			}
+			batch[id] = task
+			if len(batch) >= idx.Config.batchSize() {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
 }
 
-// DeleteImage removes an image from the index
-func (idx *Index) DeleteImage(id string) {
-	l := logrus.WithField("imageID", id)
-	l.Debugln("Removing image from index")
-	q := bleve.NewTermQuery(id).SetField("ID")
-	rq := bleve.NewSearchRequest(q)
-	rq.Fields = []string{"FullName"}
-	var sr *bleve.SearchResult
-	var err error
-	if sr, err = idx.Search(rq); err != nil || sr.Total == 0 {
-		l.WithError(err).WithField("#hits", sr.Total).Errorln("Failed to get image id to remove from index")
+// walkImages pushes the images of all the tags of a repository to the images channel and tracks the crawl progress.
+// Each request sent to the registry holds one of the slots, so that the number of concurrent requests is bounded
+func (idx *Index) walkImages(registry string, repo dim.Repository, images chan<- *repoImage, slots chan struct{}) {
+	name := qualifiedName(registry, repo.Named().Name())
+	l := logrus.WithField("repository", name)
+	defer idx.progress.repositoryCrawled()
+
+	slots <- struct{}{}
+	tags, err := repo.AllTags()
+	<-slots
+	if err != nil {
+		l.WithError(err).Errorln("Failed to get tags")
+		idx.progress.fail(fmt.Errorf("Failed to get tags of %s : %v", name, err))
 		return
 	}
-	if sr.Total > 1 {
-		l.WithField("#hits", sr.Total).Warnln("Removing multiple images from index for this imageID")
+	idx.progress.addTags(len(tags))
+
+	wg := sync.WaitGroup{}
+	wg.Add(len(tags))
+	for _, tag := range tags {
+		slots <- struct{}{}
+		go func(tag string) {
+			defer wg.Done()
+			l.WithField("tag", tag).Debugln("Getting image details")
+			imgs, err := repo.Images(tag)
+			<-slots
+			if err != nil {
+				l.WithError(err).WithField("tag", tag).Errorln("Failed to get image")
+				idx.progress.fail(fmt.Errorf("Failed to get image %s:%s : %v", name, tag, err))
+			}
+			for _, img := range imgs {
+				images <- &repoImage{registry, repo.Named().Name(), img}
+			}
+			idx.progress.tagCrawled()
+		}(tag)
 	}
+	wg.Wait()
+}
 
-	for _, h := range sr.Hits {
-		l.WithField("image.FullName", h.Fields["FullName"].(string)).Infoln("Removing image from index")
-		idx.Index.Delete(h.Fields["FullName"].(string))
-	}
+// repoDiff lists the changes found in a repository while reconciling the index
+type repoDiff struct {
+	registry string
+	name     string
+	tags     []string
+	// images are the images of the tags that changed, by tag
+	images map[string][]*dim.IndexImage
+	failed bool
+}
+
+// Reconcile updates the index so it matches the registry content.
+// Tags whose manifest digest differs from the indexed ID are moved to their new image, tags that vanished are removed from the index.
+// When the index is empty, it runs a full Build instead.
+// The returned channel is closed once the index is up to date
+func (idx *Index) Reconcile() <-chan bool {
+	done := make(chan bool, 1)
+	go func() {
+		defer close(done)
+		idx.reconcile()
+	}()
+	return done
 }
 
-// BuildQuery returns the query object corresponding to given parameters
-func BuildQuery(nameTag, advanced string) bleve.Query {
-	l := logrus.WithFields(logrus.Fields{"nameTag": nameTag, "advanced": advanced})
-	l.Debugln("Building query clause")
+// reconcile runs a reconciliation and returns the report of what it changed. Only one reconciliation runs at a time
+func (idx *Index) reconcile() *dim.DriftReport {
+	idx.reconciling.Lock()
+	defer idx.reconciling.Unlock()
+
+	report := &dim.DriftReport{Started: time.Now()}
+	defer func() {
+		report.Duration = time.Since(report.Started)
+		idx.setDrift(report)
+	}()
 
-	if nameTag == "*" || advanced == "*" {
-		return bleve.NewMatchAllQuery()
+	var stored map[string]map[string]string
+	var err error
+	if stored, err = idx.indexedTags(); err != nil {
+		logrus.WithError(err).Errorln("Failed to read indexed images. Running a full build")
+	}
+	if err != nil || len(stored) == 0 {
+		report.FullBuild = true
+		<-idx.Build()
+		return report
 	}
 
-	bq := make([]bleve.Query, 0, 3)
+	idx.progress.start(dim.IndexReconciling)
+	defer idx.progress.done()
 
-	name := nameTag
-	tag := nameTag
+	// Changes are applied once all the repositories are read, so that notifications are not held while the registries are crawled.
+	// Tags that notifications changed meanwhile are skipped, the crawl being older than them
+	diffs := make(chan *repoDiff, 5)
+	repoDiffs := make([]*repoDiff, 0, 10)
+	collected := make(chan struct{})
+	go func() {
+		defer close(collected)
+		for diff := range diffs {
+			repoDiffs = append(repoDiffs, diff)
+		}
+	}()
 
-	if split := strings.Split(nameTag, ":"); len(split) == 2 {
-		name = split[0]
-		tag = split[1]
+	// Bounds the number of repositories crawled at the same time
+	repoSlots := make(chan struct{}, idx.Config.tagWorkers())
+	wg := sync.WaitGroup{}
+	for name, client := range idx.clients() {
+		for repository := range client.WalkRepositories() {
+			idx.progress.addRepository()
+			wg.Add(1)
+			repoSlots <- struct{}{}
+			go func(registry string, repo dim.Repository) {
+				defer func() {
+					<-repoSlots
+					wg.Done()
+				}()
+				defer idx.progress.repositoryCrawled()
+				diffs <- diffRepository(registry, repo, stored[registry], &idx.progress)
+			}(name, repository)
+		}
+	}
+	wg.Wait()
+	close(diffs)
+	<-collected
+
+	idx.changes.Lock()
+	defer idx.changes.Unlock()
+
+	// Tags and repositories are identified by their name qualified with their registry
+	cs := idx.newChangeSet()
+	seen := make(map[string]bool)
+	failed := make(map[string]bool)
+	for _, diff := range repoDiffs {
+		if diff.failed {
+			failed[qualifiedName(diff.registry, diff.name)] = true
+			report.FailedRepositories = append(report.FailedRepositories, qualifiedName(diff.registry, diff.name))
+		}
+		for _, tag := range diff.tags {
+			seen[qualifiedName(diff.registry, fmt.Sprintf("%s:%s", diff.name, tag))] = true
+		}
+		for tag, images := range diff.images {
+			fullName := fmt.Sprintf("%s:%s", diff.name, tag)
+			if !idx.unchangedSince(diff.registry, fullName, stored[diff.registry][fullName]) {
+				continue
+			}
+			// The tag leaves the image it pointed to, which is removed if it has no other tag
+			if _, err = cs.untag(diff.registry, diff.name, tag); err != nil {
+				logrus.WithError(err).Errorln("Failed to update moved tag")
+				continue
+			}
+			if err = cs.add(images); err != nil {
+				logrus.WithError(err).Errorln("Failed to update moved tag")
+				continue
+			}
+			if _, ok := stored[diff.registry][fullName]; ok {
+				report.Updated = append(report.Updated, qualifiedName(diff.registry, fullName))
+			} else {
+				report.Added = append(report.Added, qualifiedName(diff.registry, fullName))
+			}
+		}
 	}
 
-	if nameTag != "" {
-		l.WithFields(logrus.Fields{"name": name, "tag": tag}).Debugln("Adding name and tag clauses")
-		bq = append(bq, bleve.NewFuzzyQuery(name).SetField("Name"), bleve.NewMatchQuery(tag).SetField("Tag"))
+	// Tags of registries that are not indexed anymore vanish as well
+	for registry, tags := range stored {
+		for fullName := range tags {
+			name, tag := splitFullName(fullName)
+			if seen[qualifiedName(registry, fullName)] || failed[qualifiedName(registry, name)] {
+				continue
+			}
+			if !idx.unchangedSince(registry, fullName, tags[fullName]) {
+				continue
+			}
+			logrus.WithFields(logrus.Fields{"registry": registry, "image.FullName": fullName}).Infoln("Removing vanished tag from index")
+			if _, err = cs.untag(registry, name, tag); err != nil {
+				logrus.WithError(err).Errorln("Failed to remove vanished tag")
+				continue
+			}
+			report.Removed = append(report.Removed, qualifiedName(registry, fullName))
+		}
 	}
 
-	if advanced != "" {
-		l.Debugln("Adding advanced clause")
-		bq = append(bq, bleve.NewQueryStringQuery(advanced))
+	if err = cs.commit(); err != nil {
+		logrus.WithError(err).Errorln("Failed to reconcile index with registry")
+		idx.progress.fail(fmt.Errorf("Failed to reconcile index with registry : %v", err))
+		report.Error = err.Error()
+		return report
 	}
+	report.Sort()
 
-	logrus.WithField("queries", bq).Debugln("Returning query with should clauses")
-	return bleve.NewBooleanQuery(nil, bq, nil)
+	l := logrus.WithFields(logrus.Fields{"added": report.Added, "updated": report.Updated, "removed": report.Removed})
+	if report.Drifted() {
+		l.Warnln("Index drifted from registry and was corrected")
+	} else {
+		l.Infoln("Index reconciled with registry")
+	}
+	return report
+}
 
+// unchangedSince tells whether a tag still points to the digest read before the registries were crawled.
+// Tags pushed or deleted meanwhile are left as is, the notifications having already updated them
+func (idx *Index) unchangedSince(registry, fullName, previous string) bool {
+	name, tag := splitFullName(fullName)
+	current, err := idx.indexedDigests(registry, name, tag)
+	if err != nil {
+		logrus.WithError(err).WithField("image.FullName", fullName).Errorln("Failed to read indexed tag, keeping it")
+		return false
+	}
+	// A drifted tag may be held by several images, while notifications leave it on a single manifest
+	if (previous == "" && len(current) > 0) || (previous != "" && !utils.ListContains(current, previous)) {
+		logrus.WithFields(logrus.Fields{"registry": registry, "image.FullName": fullName}).Infoln("Tag changed while reconciling, keeping it")
+		return false
+	}
+	return true
 }
 
-// SearchImages returns the images matching query.
-// If fields is not empty, it fetches all given fields as well
-func (idx *Index) SearchImages(q, a string, fields []string, offset, maxResults int) (*dim.IndexResults, error) {
+// indexedDigests returns the manifest digests of the indexed images holding a tag
+func (idx *Index) indexedDigests(registry, repository, tag string) ([]string, error) {
+	filter := Filter{"Name": repository, "Tags": tag}
+	if registry != "" {
+		filter["Registry"] = registry
+	}
+	images, err := idx.Backend.Find(filter)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to find indexed images of %s:%s : %v", qualifiedName(registry, repository), tag, err)
+	}
+	digests := make([]string, 0, len(images))
+	for _, image := range images {
+		if image.Registry != registry {
+			continue
+		}
+		if image.ListDigest != "" {
+			digests = append(digests, image.ListDigest)
+		} else {
+			digests = append(digests, image.ID)
+		}
+	}
+	return digests, nil
+}
+
+// diffRepository compares the tags of a repository of a registry with the stored digests and parses the images that changed
+func diffRepository(registry string, repo dim.Repository, stored map[string]string, p *progress) *repoDiff {
+	diff := &repoDiff{registry: registry, name: repo.Named().Name(), images: make(map[string][]*dim.IndexImage)}
+	l := logrus.WithFields(logrus.Fields{"registry": registry, "repository": diff.name})
+
+	var tags []string
 	var err error
-	var sr *bleve.SearchResult
-	request := bleve.NewSearchRequestOptions(BuildQuery(q, a), maxResults, offset, false)
-	request.Fields = []string{"Name", "Tag", "FullName", "Labels", "Envs"}
-	l := logrus.WithField("request", request).WithField("query", request.Query)
-	l.Debugln("Running search")
-	if sr, err = idx.Search(request); err != nil {
-		return nil, fmt.Errorf("Error occured when processing search : %v", err)
-	}
-
-	if fields != nil && len(fields) > 0 {
-		detailFields := make([]string, len(fields))
-		copy(detailFields, fields)
-		for _, f := range []string{"Name", "Tag", "FullName"} {
-			if !utils.ListContains(detailFields, f) {
-				detailFields = append(detailFields, f)
-			}
+	if tags, err = repo.AllTags(); err != nil {
+		l.WithError(err).Errorln("Failed to get tags, keeping indexed images")
+		p.fail(fmt.Errorf("Failed to get tags of %s : %v", qualifiedName(registry, diff.name), err))
+		diff.failed = true
+		return diff
+	}
+	p.addTags(len(tags))
+
+	for _, tag := range tags {
+		fullName := fmt.Sprintf("%s:%s", diff.name, tag)
+		diff.tags = append(diff.tags, tag)
+		p.tagCrawled()
+
+		var dg digest.Digest
+		if dg, err = repo.TagDigest(tag); err != nil {
+			l.WithError(err).WithField("tag", tag).Errorln("Failed to get tag digest")
+			p.fail(fmt.Errorf("Failed to get digest of %s : %v", qualifiedName(registry, fullName), err))
+			continue
+		}
+		if previous, ok := stored[fullName]; ok && previous == dg.String() {
+			continue
 		}
 
-		for i, h := range sr.Hits {
-			if sr.Hits[i], err = idx.searchDetails(h, detailFields); err != nil {
-				return nil, fmt.Errorf("Error occured while searching details of an image : %x", err)
-			}
+		var imgs []*dim.RegistryImage
+		if imgs, err = repo.ImagesFromManifest(dg, tag); err != nil {
+			l.WithError(err).WithField("tag", tag).Errorln("Failed to get image")
+			p.fail(fmt.Errorf("Failed to get image %s : %v", qualifiedName(registry, fullName), err))
+			continue
+		}
+		l.WithField("tag", tag).Infoln("Indexing image")
+		for _, img := range imgs {
+			diff.images[tag] = append(diff.images[tag], parseFrom(registry, diff.name, img))
 		}
 	}
+	return diff
+}
 
-	results := &dim.IndexResults{Total: sr.Total}
-	results.Images = buildResults(sr)
+// indexedTags returns the manifest digest of every indexed tag, by registry name and image full name
+func (idx *Index) indexedTags() (map[string]map[string]string, error) {
+	tags := make(map[string]map[string]string)
+	err := idx.Backend.Walk(func(image *dim.IndexImage) error {
+		dg := image.ID
+		if image.ListDigest != "" {
+			dg = image.ListDigest
+		}
+		if tags[image.Registry] == nil {
+			tags[image.Registry] = make(map[string]string)
+		}
+		for _, tag := range image.Tags {
+			tags[image.Registry][fmt.Sprintf("%s:%s", image.Name, tag)] = dg
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
 
-	return results, nil
+// GetImages returns the docker images ready to be indexed. A manifest list returns one image per platform.
+// Federated indexes read the images from the registry named in notifications instead
+func (idx *Index) GetImages(repository, tag string, dg digest.Digest) ([]*dim.IndexImage, error) {
+	return idx.getImages("", repository, tag, dg)
 }
 
-func buildResults(sr *bleve.SearchResult) []*dim.IndexImage {
-	images := make([]*dim.IndexImage, 0, sr.Total)
-	for _, h := range sr.Hits {
-		images = append(images, DocumentToImage(h))
+// getImages returns the docker images of a registry ready to be indexed
+func (idx *Index) getImages(registry, repository, tag string, dg digest.Digest) ([]*dim.IndexImage, error) {
+	client, err := idx.client(registry)
+	if err != nil {
+		return nil, err
+	}
+	named, _ := reference.ParseNamed(repository)
+	var repo dim.Repository
+	if repo, err = client.NewRepository(named); err != nil {
+		logrus.WithError(err).WithField("Repository", repository).Errorln("Failed get repository info")
+		return nil, err
 	}
-	return images
-}
 
-func (idx *Index) searchDetails(doc *search.DocumentMatch, fields []string) (*search.DocumentMatch, error) {
-	logrus.WithField("doc", doc).WithField("fields", fields).Debugln("Entering searchDetails")
-	request := bleve.NewSearchRequest(bleve.NewDocIDQuery([]string{doc.ID}))
-	request.Fields = fields
-	if doc.Fields["Labels"] != nil && utils.ListContains(fields, "Labels") {
-		switch f := doc.Fields["Labels"].(type) {
-		case string:
-			request.Fields = append(request.Fields, fmt.Sprintf("Label.%s", f))
-		case []interface{}:
-			for _, f := range doc.Fields["Labels"].([]interface{}) {
-				request.Fields = append(request.Fields, fmt.Sprintf("Label.%s", f))
-			}
-		}
+	var imgs []*dim.RegistryImage
+	if imgs, err = repo.ImagesFromManifest(dg, tag); err != nil {
+		logrus.WithError(err).Errorln("Failed to get image info from manifest")
+		return nil, err
 	}
-	if doc.Fields["Envs"] != nil && utils.ListContains(fields, "Envs") {
-		switch f := doc.Fields["Envs"].(type) {
-		case string:
-			request.Fields = append(request.Fields, fmt.Sprintf("Env.%s", f))
-		case []interface{}:
-			for _, f := range doc.Fields["Envs"].([]interface{}) {
-				request.Fields = append(request.Fields, fmt.Sprintf("Env.%s", f))
-			}
-		}
+	images := make([]*dim.IndexImage, len(imgs))
+	for i, img := range imgs {
+		images[i] = parseFrom(registry, repository, img)
 	}
+	return images, nil
+}
 
-	var sr *bleve.SearchResult
-	var err error
-	if sr, err = idx.Search(request); err != nil {
-		return nil, fmt.Errorf("Failed to fetch all image info : %v", err)
+// parseFrom parses an image of a repository hosted by the given registry
+func parseFrom(registry, repository string, img *dim.RegistryImage) *dim.IndexImage {
+	image := Parse(repository, img)
+	image.Registry = registry
+	return image
+}
+
+// clients returns the clients of the indexed registries by name. The registry of an index that is not federated has no name
+func (idx *Index) clients() map[string]dim.RegistryClient {
+	if idx.Registries != nil {
+		return idx.Registries
 	}
+	return map[string]dim.RegistryClient{"": idx.RegClient}
+}
 
-	return sr.Hits[0], err
+// client returns the client of the registry with the given name
+func (idx *Index) client(registry string) (dim.RegistryClient, error) {
+	client, ok := idx.clients()[registry]
+	if !ok {
+		return nil, fmt.Errorf("Unknown registry %s", registry)
+	}
+	return client, nil
 }
 
-// FindImage returns the image from the index with the given id
-func (idx *Index) FindImage(id string) (*dim.IndexImage, error) {
-	l := logrus.WithField("id", id)
-	l.Debugln("Entering FindImage")
-	q := bleve.NewTermQuery(id).SetField("ID")
-	rq := bleve.NewSearchRequest(q)
-	rq.Fields = []string{"ID", "Name", "FullName", "Tag", "Comment", "Created", "Author", "Label", "Labels", "Volumes", "ExposedPorts", "Env", "Envs", "Size"}
+// IndexImage adds a given image into the index
+func (idx *Index) IndexImage(image *dim.IndexImage) {
+	normalizeTags(image)
+	logrus.WithFields(logrus.Fields{"imageID": image.ID, "image.FullName": image.FullName}).Debugln("Indexing image")
+	if err := idx.Backend.Write(map[string]*dim.IndexImage{documentID(image): image}, nil); err != nil {
+		logrus.WithError(err).WithField("image.FullName", image.FullName).Errorln("Failed to index image")
+	}
+}
 
-	var sr *bleve.SearchResult
-	var err error
-	if sr, err = idx.Search(rq); err != nil || sr.Total == 0 {
-		return nil, fmt.Errorf("No image found for given id : %v", err)
+// replaceTag moves a tag of a repository of a registry to the given images.
+// The images previously indexed for the tag lose it and are removed if they have no other tag
+func (idx *Index) replaceTag(registry, repository, tag string, images []*dim.IndexImage) error {
+	idx.changes.Lock()
+	defer idx.changes.Unlock()
+	cs := idx.newChangeSet()
+	if _, err := cs.untag(registry, repository, tag); err != nil {
+		return err
 	}
-	if sr.Total > 1 {
-		return nil, fmt.Errorf("Found many images for given id")
+	if err := cs.add(images); err != nil {
+		return err
 	}
+	return cs.commit()
+}
 
-	return DocumentToImage(sr.Hits[0]), nil
+// DeleteImage removes the images of a repository having the given digest and returns them.
+// The id may be the digest of an image or of a manifest list. When repository is empty, the images of all repositories are removed.
+// Images are removed from all the registries of a federated index
+func (idx *Index) DeleteImage(repository, id string) ([]*dim.IndexImage, error) {
+	return idx.deleteImage("", repository, id)
 }
 
-// DocumentToImage reads all fields of the given DocumentMatch and returns an image
-func DocumentToImage(h *search.DocumentMatch) *dim.IndexImage {
-	logrus.WithField("hit", h).Debugln("Entering documentToSearchResult")
-	result := &dim.IndexImage{
-		Name:     h.Fields["Name"].(string),
-		Tag:      h.Fields["Tag"].(string),
-		FullName: h.Fields["FullName"].(string),
+// deleteImage removes the images of a repository of a registry having the given digest and returns them.
+// When registry is empty, the images of all registries are removed
+func (idx *Index) deleteImage(registry, repository, id string) ([]*dim.IndexImage, error) {
+	l := logrus.WithFields(logrus.Fields{"registry": registry, "repository": repository, "imageID": id})
+	l.Debugln("Removing image from index")
+	filter := Filter{}
+	if registry != "" {
+		filter["Registry"] = registry
+	}
+	if repository != "" {
+		filter["Name"] = repository
+	} else {
+		l.Warnln("No repository given, removing the image from all repositories")
 	}
 
-	if h.Fields["Created"] != nil {
-		if t, err := time.Parse(time.RFC3339, h.Fields["Created"].(string)); err == nil {
-			result.Created = t
-		} else {
-			logrus.WithError(err).WithField("time", h.Fields["Created"].(string)).Errorln("Failed to parse time")
-		}
+	idx.changes.Lock()
+	defer idx.changes.Unlock()
+	var images []*dim.IndexImage
+	var err error
+	if images, err = idx.findDigest(id, filter); err != nil {
+		return nil, fmt.Errorf("Failed to find images to remove from index : %v", err)
+	}
+	if len(images) == 0 {
+		l.Infoln("No indexed image to remove")
+		return images, nil
 	}
 
-	labels := make(map[string]string, 10)
-	envs := make(map[string]string, 10)
-	for k, v := range h.Fields {
-		if strings.HasPrefix(k, "Label.") {
-			labels[strings.TrimPrefix(k, "Label.")] = v.(string)
-		} else if strings.HasPrefix(k, "Env.") {
-			envs[strings.TrimPrefix(k, "Env.")] = v.(string)
-		}
+	// Images left without tag are deleted when the change set is committed
+	cs := idx.newChangeSet()
+	for _, image := range images {
+		l.WithField("image.Tags", image.Tags).Infoln("Removing image from index")
+		deleted := *image
+		deleted.Tags = nil
+		cs.docs[documentID(image)] = &deleted
 	}
+	if err = cs.commit(); err != nil {
+		return nil, fmt.Errorf("Failed to remove images from index : %v", err)
+	}
+	return images, nil
+}
 
-	if len(labels) > 0 {
-		result.Label = labels
+// untagImage removes a tag from a repository of a registry and returns the images that had it.
+// Images left without tag are removed from the index
+func (idx *Index) untagImage(registry, repository, tag string) ([]*dim.IndexImage, error) {
+	idx.changes.Lock()
+	defer idx.changes.Unlock()
+	cs := idx.newChangeSet()
+	untagged, err := cs.untag(registry, repository, tag)
+	if err != nil {
+		return nil, err
 	}
-	if h.Fields["Volumes"] != nil {
-		switch vol := h.Fields["Volumes"].(type) {
-		case string:
-			result.Volumes = []string{vol}
-		case []interface{}:
-			result.Volumes = make([]string, len(vol))
-			for i, volume := range vol {
-				result.Volumes[i] = volume.(string)
-			}
+	return untagged, cs.commit()
+}
+
+// findDigest returns the images matching the filter that have the given digest or belong to the manifest list having this digest
+func (idx *Index) findDigest(dg string, filter Filter) ([]*dim.IndexImage, error) {
+	images := make([]*dim.IndexImage, 0, 1)
+	found := make(map[string]bool)
+	for _, field := range []string{"ID", "ListDigest"} {
+		f := Filter{field: dg}
+		for k, v := range filter {
+			f[k] = v
 		}
-	}
-	if h.Fields["ExposedPorts"] != nil {
-		switch ports := h.Fields["ExposedPorts"].(type) {
-		case float64:
-			result.ExposedPorts = []int{int(ports)}
-		case []interface{}:
-			result.ExposedPorts = make([]int, len(ports))
-			for i, port := range ports {
-				result.ExposedPorts[i] = int(port.(float64))
+		matches, err := idx.Backend.Find(f)
+		if err != nil {
+			return nil, err
+		}
+		for _, image := range matches {
+			if id := documentID(image); !found[id] {
+				found[id] = true
+				images = append(images, image)
 			}
 		}
 	}
-	if len(envs) > 0 {
-		result.Env = envs
+	return images, nil
+}
+
+// SearchImages returns the images matching query.
+// If fields is not empty, it fetches all given fields as well.
+// Each facet specification adds the count of matching images per value of a field (see NewFacetRequest)
+// Results are sorted by score unless sort keys are given (see NewSortOrder)
+// If platform is not empty, only images of this platform (os/arch[/variant]) are returned
+func (idx *Index) SearchImages(q, a, platform string, fields, facets, sort []string, offset, maxResults int) (*dim.IndexResults, error) {
+	return idx.Backend.Search(&SearchRequest{Query: q, Advanced: a, Platform: platform, Fields: fields, Facets: facets, Sort: sort, Offset: offset, Size: maxResults})
+}
+
+// FindImage returns the image from the index with the given id
+func (idx *Index) FindImage(id string) (*dim.IndexImage, error) {
+	l := logrus.WithField("id", id)
+	l.Debugln("Entering FindImage")
+	images, err := idx.Backend.Find(Filter{"ID": id})
+	if err != nil || len(images) == 0 {
+		return nil, fmt.Errorf("No image found for given id : %v", err)
 	}
-	if h.Fields["Size"] != nil {
-		result.Size = int64(h.Fields["Size"].(float64))
+	if len(images) > 1 {
+		return nil, fmt.Errorf("Found many images for given id")
 	}
 
-	return result
+	return images[0], nil
 }
 
-//Submit pushes a NotificationJob that will be applied to the index
+// Submit pushes a NotificationJob that will be applied to the index
 func (idx *Index) Submit(job *dim.NotificationJob) {
-	idx.notifications <- job
+	if _, err := idx.client(job.Registry); err != nil {
+		logrus.WithError(err).WithField("Event", job).Errorln("Ignoring notification")
+		return
+	}
+	if idx.queue != nil {
+		added, err := idx.queue.Push(job)
+		if err == nil {
+			if !added {
+				logrus.WithField("Event", job).Infoln("Ignoring already received notification")
+			}
+			return
+		}
+		logrus.WithError(err).WithField("Event", job).Errorln("Failed to persist notification, processing it without retry")
+	}
+	select {
+	case idx.notifications <- &QueuedJob{Job: job}:
+	case <-idx.stop:
+		logrus.WithField("Event", job).Warnln("Index is closed, ignoring notification")
+	}
 }
 
 func (idx *Index) loop(parallels int) {
 	for i := 0; i < parallels; i++ {
-		go idx.handleNotifications()
+		idx.run(idx.handleNotifications)
+	}
+}
+
+// dispatch feeds the notification workers with the jobs of the queue when they are due, until the index is closed
+func (idx *Index) dispatch() {
+	var lastPrune time.Time
+	for ; ; idx.queue.Wait(time.Second) {
+		select {
+		case <-idx.stop:
+			return
+		default:
+		}
+
+		now := time.Now()
+		due, err := idx.queue.Due(now)
+		if err != nil {
+			logrus.WithError(err).Errorln("Failed to read notification queue")
+		}
+		for _, qj := range due {
+			select {
+			case idx.notifications <- qj:
+			case <-idx.stop:
+				return
+			}
+		}
+
+		if now.Sub(lastPrune) > time.Hour {
+			if err = idx.queue.PruneEvents(now); err != nil {
+				logrus.WithError(err).Errorln("Failed to prune received events")
+			}
+			lastPrune = now
+		}
 	}
 }
 
+// handleNotifications applies the notification jobs until the index is closed
 func (idx *Index) handleNotifications() {
-	for job := range idx.notifications {
-		l := logrus.WithField("Event", job)
-
-		hooks := idx.Config.GetHooks(job.Action)
-		switch job.Action {
-		case dim.DeleteAction:
-			if len(hooks) > 0 {
-				l.Debugln("Calling delete hooks")
-				if img, err := idx.FindImage(job.Digest.String()); err == nil {
-					triggerHooks(hooks, img)
-				} else {
-					l.WithError(err).Errorln("Failed to handle delete hook")
-				}
-			} else {
-				l.Debugln("No delete hook found")
+	for {
+		var qj *QueuedJob
+		var ok bool
+		select {
+		case qj, ok = <-idx.notifications:
+			if !ok {
+				return
 			}
-			idx.DeleteImage(job.Digest.String())
-		case dim.PushAction:
-			if img, err := idx.GetImage(job.Repository, job.Tag, job.Digest); err == nil {
-				if len(hooks) > 0 {
-					l.Debugln("Calling delete hooks")
-					triggerHooks(hooks, img)
-				} else {
-					l.Debugln("No push hook found")
-				}
-				idx.IndexImage(img)
+		case <-idx.stop:
+			return
+		}
+		err := idx.apply(qj.Job)
+		if idx.queue == nil || qj.ID == 0 {
+			continue
+		}
+		if err == nil {
+			err = idx.queue.Ack(qj)
+		} else {
+			err = idx.queue.Fail(qj, err)
+		}
+		if err != nil {
+			logrus.WithError(err).WithField("Event", qj.Job).Errorln("Failed to update notification queue")
+		}
+	}
+}
 
-			} else {
-				logrus.WithField("Event", job).WithError(err).Errorln("Failed to handle push hook")
+// apply updates the index and triggers the hooks for the given job. Returned errors are worth retrying
+func (idx *Index) apply(job *dim.NotificationJob) error {
+	l := logrus.WithField("Event", job)
+
+	hooks := idx.Config.GetHooks(job.Action)
+	switch job.Action {
+	case dim.DeleteAction:
+		// Deleting a tag only removes it from the image, deleting a manifest removes the image with all its tags
+		var imgs []*dim.IndexImage
+		var err error
+		if job.Tag != "" {
+			imgs, err = idx.untagImage(job.Registry, job.Repository, job.Tag)
+		} else {
+			imgs, err = idx.deleteImage(job.Registry, job.Repository, job.Digest.String())
+		}
+		if err != nil {
+			l.WithError(err).Errorln("Failed to remove image from index")
+			return err
+		}
+		if len(hooks) > 0 {
+			l.Debugln("Calling delete hooks")
+			for _, img := range imgs {
+				triggerHooks(hooks, img)
 			}
+		} else {
+			l.Debugln("No delete hook found")
+		}
+	case dim.PushAction:
+		imgs, err := idx.getImages(job.Registry, job.Repository, job.Tag, job.Digest)
+		if err != nil {
+			l.WithError(err).Errorln("Failed to handle push hook")
+			return err
+		}
+		// Hooks are triggered once the image is indexed so that they get all its tags
+		if err = idx.replaceTag(job.Registry, job.Repository, job.Tag, imgs); err != nil {
+			l.WithError(err).Errorln("Failed to index pushed image")
+			return err
+		}
+		if len(hooks) > 0 {
+			l.Debugln("Calling push hooks")
+			for _, img := range imgs {
+				triggerHooks(hooks, img)
+			}
+		} else {
+			l.Debugln("No push hook found")
 		}
+		idx.matchSearches(imgs)
 	}
+	return nil
 }
 
 func triggerHooks(hooks []*Hook, img *dim.IndexImage) {
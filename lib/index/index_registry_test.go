@@ -22,7 +22,6 @@ import (
 	"sync"
 
 	"github.com/Sirupsen/logrus"
-	"github.com/blevesearch/bleve"
 	"github.com/docker/distribution/digest"
 	"github.com/docker/distribution/reference"
 	"github.com/docker/docker/api/types/container"
@@ -112,9 +111,8 @@ var _ = Suite(&RegistrySuite{})
 func (s *RegistrySuite) SetUpTest(c *C) {
 
 	logrus.SetLevel(logrus.DebugLevel)
-	var i bleve.Index
-	var err error
-	if i, err = indextest.MockIndex(ImageMapping); err != nil {
+	i, err := indextest.MockIndex(ImageMapping)
+	if err != nil {
 		logrus.WithError(err).Errorln("Failed to create index")
 		return
 	}
@@ -131,19 +129,22 @@ func (s *RegistrySuite) SetUpTest(c *C) {
 
 	mockRegistryRepository := map[string]*mock.NoOpRegistryRepository{
 		"httpd": {
-			ImageFn: func(tag string) (*dim.RegistryImage, error) {
+			ImagesFn: func(tag string) ([]*dim.RegistryImage, error) {
 				dg := fmt.Sprintf("httpd:%s", tag)
-				return repoImages[dg], nil
+				return []*dim.RegistryImage{repoImages[dg]}, nil
 			},
-			ImageFromManifestFn: func(tagDigest digest.Digest, digest string) (img *dim.RegistryImage, err error) {
-				if digest == "5.7" {
+			TagDigestFn: func(tag string) (digest.Digest, error) {
+				return digest.Digest(fmt.Sprintf("httpd:%s", tag)), nil
+			},
+			ImagesFromManifestFn: func(tagDigest digest.Digest, digest string) ([]*dim.RegistryImage, error) {
+				img := repoImages[string(tagDigest)]
+				if img == nil && digest == "5.7" {
 					img = repoImages["mysql:5.7"]
 				}
-				err = nil
 				if img == nil {
-					err = fmt.Errorf("Image %s not found", digest)
+					return nil, fmt.Errorf("Image %s not found", digest)
 				}
-				return
+				return []*dim.RegistryImage{img}, nil
 			},
 			NamedFn: func() reference.Named {
 				n, _ := reference.ParseNamed("httpd")
@@ -154,19 +155,22 @@ func (s *RegistrySuite) SetUpTest(c *C) {
 			},
 		},
 		"mysql": {
-			ImageFn: func(tag string) (*dim.RegistryImage, error) {
+			ImagesFn: func(tag string) ([]*dim.RegistryImage, error) {
 				dg := fmt.Sprintf("mysql:%s", tag)
-				return repoImages[dg], nil
+				return []*dim.RegistryImage{repoImages[dg]}, nil
+			},
+			TagDigestFn: func(tag string) (digest.Digest, error) {
+				return digest.Digest(fmt.Sprintf("mysql:%s", tag)), nil
 			},
-			ImageFromManifestFn: func(tagDigest digest.Digest, digest string) (img *dim.RegistryImage, err error) {
-				if digest == "5.7" {
+			ImagesFromManifestFn: func(tagDigest digest.Digest, digest string) ([]*dim.RegistryImage, error) {
+				img := repoImages[string(tagDigest)]
+				if img == nil && digest == "5.7" {
 					img = repoImages["mysql:5.7"]
 				}
-				err = nil
 				if img == nil {
-					err = fmt.Errorf("Image %s not found", digest)
+					return nil, fmt.Errorf("Image %s not found", digest)
 				}
-				return
+				return []*dim.RegistryImage{img}, nil
 			},
 			NamedFn: func() reference.Named {
 				n, _ := reference.ParseNamed("mysql")
@@ -185,26 +189,210 @@ func (s *RegistrySuite) SetUpTest(c *C) {
 		return mockRegistryRepository[parsedName.Name()], nil
 	}
 
-	s.index = &Index{Index: i, RegClient: mockRegistryClient, Config: &Config{}}
+	s.index = &Index{Backend: NewBleveBackend(i), RegClient: mockRegistryClient, Config: &Config{}}
 }
 
 func (s *RegistrySuite) TearDownSuite(c *C) {
-	s.index.Index.Close()
+	s.index.Close()
 }
 
 func (s *RegistrySuite) TestBuild(c *C) {
 	done := s.index.Build()
 	_ = <-done
-	srq := bleve.NewSearchRequest(bleve.NewMatchAllQuery())
-	srs, err := s.index.Search(srq)
+	count, err := s.index.Backend.Count()
+	c.Assert(err, IsNil)
+	c.Assert(count, Equals, uint64(4))
+
+	status := s.index.Status()
+	c.Assert(status.State, Equals, dim.IndexReady)
+	c.Assert(status.Repositories, Equals, 2)
+	c.Assert(status.RepositoriesCrawled, Equals, 2)
+	c.Assert(status.Tags, Equals, 4)
+	c.Assert(status.TagsCrawled, Equals, 4)
+	c.Assert(status.Documents, Equals, uint64(4))
+	c.Assert(status.Errors, HasLen, 0)
+}
+
+func (s *RegistrySuite) TestBuildInBatches(c *C) {
+	s.index.Config.BatchSize = 1
+	s.index.Config.ParseWorkers = 1
+	s.index.Config.TagWorkers = 1
+	_ = <-s.index.Build()
+	count, err := s.index.Backend.Count()
+	c.Assert(err, IsNil)
+	c.Assert(count, Equals, uint64(4))
+	c.Assert(s.index.Status().Errors, HasLen, 0)
+
+	// Tags of a manifest crawled after its document was written are merged into it
+	tasks := make(chan *dim.IndexImage, 2)
+	tasks <- &dim.IndexImage{ID: "v1", Name: "app", Tag: "1.0", Tags: []string{"1.0"}}
+	tasks <- &dim.IndexImage{ID: "v1", Name: "app", Tag: "latest", Tags: []string{"latest"}}
+	close(tasks)
+	s.index.indexTasks(tasks)
+	image, err := s.index.Backend.Get("app@v1")
 	c.Assert(err, IsNil)
-	c.Assert(srs.Total, Equals, uint64(4))
+	c.Assert(image, NotNil)
+	c.Assert(image.Tags, DeepEquals, []string{"1.0", "latest"})
+}
+
+func (s *RegistrySuite) TestReconcile(c *C) {
+	// Empty index is fully built
+	_ = <-s.index.Reconcile()
+	count, err := s.index.Backend.Count()
+	c.Assert(err, IsNil)
+	c.Assert(count, Equals, uint64(4))
+
+	// Drift the index : a stale digest, a vanished tag and a missing tag
+	s.index.IndexImage(&dim.IndexImage{ID: "stale", Name: "httpd", Tag: "2.2", FullName: "httpd:2.2"})
+	s.index.IndexImage(&dim.IndexImage{ID: "httpd:1.0", Name: "httpd", Tag: "1.0", FullName: "httpd:1.0"})
+	c.Assert(s.index.Backend.Write(nil, []string{"mysql@mysql:5.5"}), IsNil)
+
+	_ = <-s.index.Reconcile()
+
+	stored, err := s.index.indexedTags()
+	c.Assert(err, IsNil)
+	c.Assert(stored, DeepEquals, map[string]map[string]string{"": {
+		"httpd:2.2": "httpd:2.2",
+		"httpd:2.4": "httpd:2.4",
+		"mysql:5.5": "mysql:5.5",
+		"mysql:5.7": "mysql:5.7",
+	}})
+	count, err = s.index.Backend.Count()
+	c.Assert(err, IsNil)
+	c.Assert(count, Equals, uint64(4))
+
+	drift := s.index.Drift()
+	c.Assert(drift, NotNil)
+	c.Assert(drift.FullBuild, Equals, false)
+	c.Assert(drift.Added, DeepEquals, []string{"mysql:5.5"})
+	c.Assert(drift.Updated, DeepEquals, []string{"httpd:2.2"})
+	c.Assert(drift.Removed, DeepEquals, []string{"httpd:1.0"})
+}
+
+func (s *RegistrySuite) TestReconcileConcurrentChanges(c *C) {
+	_ = <-s.index.Build()
+	// Drift the index : a stale digest and a vanished tag
+	c.Assert(s.index.replaceTag("", "httpd", "2.2", []*dim.IndexImage{{ID: "stale", Name: "httpd", Tag: "2.2", Tags: []string{"2.2"}}}), IsNil)
+	s.index.IndexImage(&dim.IndexImage{ID: "httpd:1.0", Name: "httpd", Tag: "1.0", FullName: "httpd:1.0", Tags: []string{"1.0"}})
+
+	// Notifications are applied while httpd is crawled, so the crawl misses them
+	client := s.index.RegClient.(*mock.NoOpRegistryClient)
+	newRepository := client.NewRepositoryFn
+	client.NewRepositoryFn = func(parsedName dockerReference.Named) (dim.Repository, error) {
+		repo, err := newRepository(parsedName)
+		if parsedName.Name() != "httpd" {
+			return repo, err
+		}
+		httpd := *repo.(*mock.NoOpRegistryRepository)
+		allTags := httpd.AllTagsFn
+		httpd.AllTagsFn = func() ([]string, error) {
+			c.Check(s.index.replaceTag("", "httpd", "2.2", []*dim.IndexImage{{ID: "pushed", Name: "httpd", Tag: "2.2", Tags: []string{"2.2"}}}), IsNil)
+			c.Check(s.index.replaceTag("", "httpd", "1.0", nil), IsNil)
+			return allTags()
+		}
+		return &httpd, err
+	}
+
+	_ = <-s.index.Reconcile()
+
+	stored, err := s.index.indexedTags()
+	c.Assert(err, IsNil)
+	c.Assert(stored, DeepEquals, map[string]map[string]string{"": {
+		"httpd:2.2": "pushed",
+		"httpd:2.4": "httpd:2.4",
+		"mysql:5.5": "mysql:5.5",
+		"mysql:5.7": "mysql:5.7",
+	}})
+	drift := s.index.Drift()
+	c.Assert(drift.Updated, HasLen, 0)
+	c.Assert(drift.Removed, HasLen, 0)
+}
+
+func (s *RegistrySuite) TestReconcileUnreachableRepository(c *C) {
+	_ = <-s.index.Build()
+
+	// A repository that cannot be opened keeps its indexed images
+	client := s.index.RegClient.(*mock.NoOpRegistryClient)
+	newRepository := client.NewRepositoryFn
+	client.NewRepositoryFn = func(parsedName dockerReference.Named) (dim.Repository, error) {
+		if parsedName.Name() == "mysql" {
+			return nil, fmt.Errorf("unauthorized")
+		}
+		return newRepository(parsedName)
+	}
+
+	_ = <-s.index.Reconcile()
+
+	count, err := s.index.Backend.Count()
+	c.Assert(err, IsNil)
+	c.Assert(count, Equals, uint64(4))
+	drift := s.index.Drift()
+	c.Assert(drift.Removed, HasLen, 0)
+	c.Assert(drift.FailedRepositories, DeepEquals, []string{"mysql"})
+}
+
+func (s *RegistrySuite) TestTagMoves(c *C) {
+	manifests := map[string]*dim.RegistryImage{
+		"v1": {Image: &image.Image{V1Image: image.V1Image{Config: &container.Config{}}}, Digest: "v1"},
+		"v2": {Image: &image.Image{V1Image: image.V1Image{Config: &container.Config{}}}, Digest: "v2"},
+	}
+	s.index.RegClient = &mock.NoOpRegistryClient{
+		NewRepositoryFn: func(parsedName dockerReference.Named) (dim.Repository, error) {
+			return &mock.NoOpRegistryRepository{
+				ImagesFromManifestFn: func(tagDigest digest.Digest, tag string) ([]*dim.RegistryImage, error) {
+					img := *manifests[string(tagDigest)]
+					img.Tag = tag
+					return []*dim.RegistryImage{&img}, nil
+				},
+			}, nil
+		},
+	}
+	push := func(repository, tag, dg string) {
+		c.Assert(s.index.apply(&dim.NotificationJob{Action: dim.PushAction, Repository: repository, Tag: tag, Digest: digest.Digest(dg)}), IsNil)
+	}
+	tags := func(repository, dg string) []string {
+		image, err := s.index.Backend.Get(repository + "@" + dg)
+		c.Assert(err, IsNil)
+		if image == nil {
+			return nil
+		}
+		return image.Tags
+	}
+
+	// Tags pointing to the same manifest share a single document
+	push("app", "1.0", "v1")
+	push("app", "latest", "v1")
+	c.Assert(tags("app", "v1"), DeepEquals, []string{"1.0", "latest"})
+	image, err := s.index.FindImage("v1")
+	c.Assert(err, IsNil)
+	c.Assert(image.FullName, Equals, "app:1.0")
+
+	// A moved tag leaves its previous image
+	push("app", "latest", "v2")
+	push("app", "2.0", "v2")
+	c.Assert(tags("app", "v1"), DeepEquals, []string{"1.0"})
+	c.Assert(tags("app", "v2"), DeepEquals, []string{"2.0", "latest"})
+
+	// The image is removed with its last tag
+	push("app", "1.0", "v2")
+	c.Assert(tags("app", "v1"), IsNil)
+	c.Assert(tags("app", "v2"), DeepEquals, []string{"1.0", "2.0", "latest"})
+
+	// The same manifest in another repository is another document
+	push("other", "latest", "v2")
+	c.Assert(tags("other", "v2"), DeepEquals, []string{"latest"})
+	c.Assert(tags("app", "v2"), DeepEquals, []string{"1.0", "2.0", "latest"})
+
+	sr, err := s.index.SearchImages("", "Tag:2.0", "", []string{"Tags"}, nil, nil, 0, 10)
+	c.Assert(err, IsNil)
+	c.Assert(sr.Images, HasLen, 1)
+	c.Assert(sr.Images[0].Tags, DeepEquals, []string{"1.0", "2.0", "latest"})
 }
 
 func (s *RegistrySuite) TestSearchImages(c *C) {
 	done := s.index.Build()
 	_ = <-done
-	sr, err := s.index.SearchImages("", "+Name:mysql +Tag:5.7", []string{"Name", "Tag", "FullName", "Labels", "Envs"}, 0, 5)
+	sr, err := s.index.SearchImages("", "+Name:mysql +Tag:5.7", "", []string{"Name", "Tags", "Labels", "Envs"}, nil, nil, 0, 5)
 	c.Assert(err, IsNil)
 	c.Assert(sr.Total, Equals, uint64(1))
 	c.Assert(sr.Images[0].Label["family"], Equals, "mysql")
@@ -213,10 +401,11 @@ func (s *RegistrySuite) TestSearchImages(c *C) {
 }
 
 func (s *RegistrySuite) TestGetImageAndIndex(c *C) {
-	img, err := s.index.GetImage("mysql", "5.7", digest.FromBytes([]byte("digest")))
+	imgs, err := s.index.GetImages("mysql", "5.7", digest.FromBytes([]byte("digest")))
 	c.Assert(err, IsNil)
-	s.index.IndexImage(img)
-	sr, err := s.index.SearchImages("", "+Name:mysql +Tag:5.7", []string{"Name", "Tag", "FullName", "Labels", "Envs"}, 0, 5)
+	c.Assert(imgs, HasLen, 1)
+	s.index.IndexImage(imgs[0])
+	sr, err := s.index.SearchImages("", "+Name:mysql +Tag:5.7", "", []string{"Name", "Tags", "Labels", "Envs"}, nil, nil, 0, 5)
 	c.Assert(err, IsNil)
 	c.Assert(sr.Total, Equals, uint64(1))
 	c.Assert(sr.Images[0].Label["family"], Equals, "mysql")
@@ -230,12 +419,12 @@ func (s *RegistrySuite) TestHandleNotifications(c *C) {
 	s.index.RegClient = &mock.NoOpRegistryClient{
 		NewRepositoryFn: func(parsedName dockerReference.Named) (dim.Repository, error) {
 			return &mock.NoOpRegistryRepository{
-				ImageFn: func(tag string) (*dim.RegistryImage, error) {
+				ImagesFn: func(tag string) ([]*dim.RegistryImage, error) {
 					dg := fmt.Sprintf("mongo:%s", tag)
-					return repoImages[dg], nil
+					return []*dim.RegistryImage{repoImages[dg]}, nil
 				},
-				ImageFromManifestFn: func(tagDigest digest.Digest, digest string) (img *dim.RegistryImage, err error) {
-					return repoImages["mongo:3.2"], nil
+				ImagesFromManifestFn: func(tagDigest digest.Digest, digest string) ([]*dim.RegistryImage, error) {
+					return []*dim.RegistryImage{repoImages["mongo:3.2"]}, nil
 				},
 				NamedFn: func() reference.Named {
 					n, _ := reference.ParseNamed("mongo")
@@ -247,7 +436,7 @@ func (s *RegistrySuite) TestHandleNotifications(c *C) {
 			}, nil
 		},
 	}
-	s.index.notifications = make(chan *dim.NotificationJob)
+	s.index.notifications = make(chan *QueuedJob)
 	defer close(s.index.notifications)
 	calls := make(map[string]int)
 	wg := sync.WaitGroup{}
@@ -264,11 +453,177 @@ func (s *RegistrySuite) TestHandleNotifications(c *C) {
 	wg.Add(2)
 
 	go func() { s.index.handleNotifications() }()
-	s.index.notifications <- &dim.NotificationJob{Action: dim.PushAction, Tag: "3.2", Repository: "mongo"}
-	s.index.notifications <- &dim.NotificationJob{Action: dim.DeleteAction, Digest: "mongo:3.2"}
+	s.index.notifications <- &QueuedJob{Job: &dim.NotificationJob{Action: dim.PushAction, Tag: "3.2", Repository: "mongo"}}
+	s.index.notifications <- &QueuedJob{Job: &dim.NotificationJob{Action: dim.DeleteAction, Repository: "mongo", Digest: "mongo:3.2"}}
 	wg.Wait()
 
 	if calls["testCalls"] != 2 {
 		c.Errorf("handleNotifications should have beend called twice but was called %d times", calls["testCalls"])
 	}
 }
+
+func (s *RegistrySuite) TestDeleteInRepository(c *C) {
+	s.index.RegClient = &mock.NoOpRegistryClient{
+		NewRepositoryFn: func(parsedName dockerReference.Named) (dim.Repository, error) {
+			return &mock.NoOpRegistryRepository{
+				ImagesFromManifestFn: func(tagDigest digest.Digest, tag string) ([]*dim.RegistryImage, error) {
+					return []*dim.RegistryImage{{Image: &image.Image{V1Image: image.V1Image{Config: &container.Config{}}}, Tag: tag, Digest: string(tagDigest)}}, nil
+				},
+			}, nil
+		},
+	}
+	deleted := make(chan string, 3)
+	s.index.Config.Hooks = []*Hook{{Event: dim.DeleteAction, Action: "{{deleted .FullName}}"}}
+	s.index.Config.RegisterFunction("deleted", func(fullName string) error {
+		deleted <- fullName
+		return nil
+	})
+	c.Assert(s.index.Config.ParseHooks(), IsNil)
+
+	for _, job := range []*dim.NotificationJob{
+		{Action: dim.PushAction, Repository: "app", Tag: "1.0", Digest: "shared"},
+		{Action: dim.PushAction, Repository: "app", Tag: "latest", Digest: "shared"},
+		{Action: dim.PushAction, Repository: "fork", Tag: "1.0", Digest: "shared"},
+	} {
+		c.Assert(s.index.apply(job), IsNil)
+	}
+
+	// Deleting a tag keeps the image and its other tags
+	c.Assert(s.index.apply(&dim.NotificationJob{Action: dim.DeleteAction, Repository: "app", Tag: "latest", Digest: "shared"}), IsNil)
+	c.Assert(<-deleted, Equals, "app:latest")
+	image, err := s.index.FindImage("shared")
+	c.Assert(err, ErrorMatches, "Found many images.*")
+	c.Assert(image, IsNil)
+
+	// Deleting a manifest only removes it from the given repository
+	c.Assert(s.index.apply(&dim.NotificationJob{Action: dim.DeleteAction, Repository: "app", Digest: "shared"}), IsNil)
+	c.Assert(<-deleted, Equals, "app:1.0")
+	image, err = s.index.FindImage("shared")
+	c.Assert(err, IsNil)
+	c.Assert(image.FullName, Equals, "fork:1.0")
+}
+
+func (s *RegistrySuite) TestManifestList(c *C) {
+	platforms := map[string]*dim.RegistryImage{
+		"alpine:amd64": {Image: &image.Image{V1Image: image.V1Image{OS: "linux", Architecture: "amd64", Config: &container.Config{}}}, Digest: "alpine:amd64"},
+		"alpine:arm64": {Image: &image.Image{V1Image: image.V1Image{OS: "linux", Architecture: "arm64", Config: &container.Config{}}}, Variant: "v8", Digest: "alpine:arm64"},
+		"alpine:arm":   {Image: &image.Image{V1Image: image.V1Image{OS: "linux", Architecture: "arm", Config: &container.Config{}}}, Variant: "v7", Digest: "alpine:arm"},
+	}
+	lists := map[string][]string{
+		"list1": {"alpine:amd64", "alpine:arm64", "alpine:arm"},
+		"list2": {"alpine:amd64", "alpine:arm64"},
+	}
+	s.index.RegClient = &mock.NoOpRegistryClient{
+		NewRepositoryFn: func(parsedName dockerReference.Named) (dim.Repository, error) {
+			return &mock.NoOpRegistryRepository{
+				ImagesFromManifestFn: func(tagDigest digest.Digest, tag string) ([]*dim.RegistryImage, error) {
+					imgs := make([]*dim.RegistryImage, 0, 3)
+					for _, dg := range lists[string(tagDigest)] {
+						img := *platforms[dg]
+						img.Tag, img.ListDigest = tag, string(tagDigest)
+						imgs = append(imgs, &img)
+					}
+					return imgs, nil
+				},
+			}, nil
+		},
+	}
+
+	c.Assert(s.index.apply(&dim.NotificationJob{Action: dim.PushAction, Repository: "alpine", Tag: "3.4", Digest: "list1"}), IsNil)
+	scenarii := []struct {
+		platform string
+		expected int
+	}{
+		{"", 3},
+		{"linux/arm64", 1},
+		{"linux/arm", 1},
+		{"linux/arm/v7", 1},
+		{"linux/arm/v6", 0},
+		{"windows/amd64", 0},
+	}
+	for _, scenario := range scenarii {
+		sr, err := s.index.SearchImages("", "Name:alpine", scenario.platform, nil, nil, nil, 0, 10)
+		c.Assert(err, IsNil)
+		c.Assert(sr.Total, Equals, uint64(scenario.expected), Commentf("platform %s", scenario.platform))
+	}
+
+	// Pushing the tag again with less platforms removes the old ones
+	c.Assert(s.index.apply(&dim.NotificationJob{Action: dim.PushAction, Repository: "alpine", Tag: "3.4", Digest: "list2"}), IsNil)
+	stored, err := s.index.indexedTags()
+	c.Assert(err, IsNil)
+	c.Assert(stored[""]["alpine:3.4"], Equals, "list2")
+	count, err := s.index.Backend.Count()
+	c.Assert(err, IsNil)
+	c.Assert(count, Equals, uint64(2))
+
+	image, err := s.index.FindImage("alpine:arm64")
+	c.Assert(err, IsNil)
+	c.Assert(image.Platform, Equals, "linux/arm64/v8")
+	c.Assert(image.ListDigest, Equals, "list2")
+
+	// Deleting the manifest list removes all its platforms
+	c.Assert(s.index.apply(&dim.NotificationJob{Action: dim.DeleteAction, Digest: "list2"}), IsNil)
+	count, err = s.index.Backend.Count()
+	c.Assert(err, IsNil)
+	c.Assert(count, Equals, uint64(0))
+}
+
+func (s *RegistrySuite) TestPollChanges(c *C) {
+	known := map[string]string{
+		"httpd:1.0": "httpd:1.0",
+		"httpd:2.2": "httpd:2.2",
+		"mysql:5.5": "stale",
+	}
+
+	jobs, current := pollChanges("", s.index.RegClient, known)
+	c.Assert(jobs, DeepEquals, []*dim.NotificationJob{
+		{Action: dim.DeleteAction, Repository: "httpd", Tag: "1.0", Digest: "httpd:1.0"},
+		{Action: dim.PushAction, Repository: "httpd", Tag: "2.4", Digest: "httpd:2.4"},
+		{Action: dim.PushAction, Repository: "mysql", Tag: "5.5", Digest: "mysql:5.5"},
+		{Action: dim.PushAction, Repository: "mysql", Tag: "5.7", Digest: "mysql:5.7"},
+	})
+	c.Assert(current, DeepEquals, map[string]string{
+		"httpd:2.2": "httpd:2.2",
+		"httpd:2.4": "httpd:2.4",
+		"mysql:5.5": "mysql:5.5",
+		"mysql:5.7": "mysql:5.7",
+	})
+
+	// Nothing changed since the last poll
+	jobs, _ = pollChanges("", s.index.RegClient, current)
+	c.Assert(jobs, HasLen, 0)
+}
+
+func (s *RegistrySuite) TestFederation(c *C) {
+	s.index.Registries = map[string]dim.RegistryClient{"dev": s.index.RegClient, "prod": s.index.RegClient}
+	_ = <-s.index.Build()
+	count, err := s.index.Backend.Count()
+	c.Assert(err, IsNil)
+	c.Assert(count, Equals, uint64(8))
+
+	sr, err := s.index.SearchImages("", "Registry:prod", "", []string{"Registry"}, nil, nil, 0, 10)
+	c.Assert(err, IsNil)
+	c.Assert(sr.Images, HasLen, 4)
+	for _, image := range sr.Images {
+		c.Assert(image.Registry, Equals, "prod")
+	}
+
+	// Notifications only change the images of their registry
+	c.Assert(s.index.apply(&dim.NotificationJob{Registry: "prod", Action: dim.DeleteAction, Repository: "httpd", Tag: "2.2"}), IsNil)
+	stored, err := s.index.indexedTags()
+	c.Assert(err, IsNil)
+	c.Assert(stored["dev"], HasLen, 4)
+	c.Assert(stored["prod"], HasLen, 3)
+
+	// Reconciliation restores the missing tag and removes the images of registries that are not indexed anymore
+	delete(s.index.Registries, "dev")
+	_ = <-s.index.Reconcile()
+	stored, err = s.index.indexedTags()
+	c.Assert(err, IsNil)
+	c.Assert(stored, HasLen, 1)
+	c.Assert(stored["prod"], HasLen, 4)
+
+	drift := s.index.Drift()
+	c.Assert(drift.Added, DeepEquals, []string{"prod/httpd:2.2"})
+	c.Assert(drift.Removed, DeepEquals, []string{"dev/httpd:2.2", "dev/httpd:2.4", "dev/mysql:5.5", "dev/mysql:5.7"})
+}
@@ -14,6 +14,11 @@
 package index
 
 import (
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"sync"
 	"testing"
 
 	"fmt"
@@ -27,6 +32,8 @@ import (
 
 type TestSuite struct {
 	index *Index
+	// store is the bleve index of the backend, queried directly by the query tests
+	store bleve.Index
 }
 
 // Hook up gocheck into the "go test" runner.
@@ -37,11 +44,10 @@ var _ = Suite(&TestSuite{})
 var (
 	images = []dim.IndexImage{
 		{
-			ID:       "123456",
-			Name:     "centos",
-			Tag:      "centos6",
-			FullName: "centos:centos6",
-			Created:  indextest.ParseTime("2016-07-24T09:05:06Z"),
+			ID:      "123456",
+			Name:    "centos",
+			Tags:    []string{"centos6"},
+			Created: indextest.ParseTime("2016-07-24T09:05:06Z"),
 			Label: map[string]string{
 				"type":   "base",
 				"family": "rhel",
@@ -50,13 +56,18 @@ var (
 				"type",
 				"family",
 			},
+			User:         "root",
+			OS:           "linux",
+			Architecture: "amd64",
+			Layers:       []string{"sha256:centos"},
+			LayerSizes:   []int64{2048},
 		},
 		{
-			ID:       "234567",
-			Name:     "httpd",
-			Tag:      "2.4",
-			FullName: "httpd:2.4",
-			Created:  indextest.ParseTime("2016-06-23T09:05:06Z"),
+			ID:      "234567",
+			Name:    "httpd",
+			Tags:    []string{"2.4"},
+			Created: indextest.ParseTime("2016-06-23T09:05:06Z"),
+			Author:  "John Doe <john.doe@example.com>",
 			Label: map[string]string{
 				"type":      "web",
 				"family":    "debian",
@@ -67,6 +78,14 @@ var (
 				"family",
 				"framework",
 			},
+			Annotation: map[string]string{
+				"org.opencontainers.image.source":  "https://github.com/docker-library/httpd",
+				"org.opencontainers.image.version": "2.4.18",
+			},
+			Annotations: []string{
+				"org.opencontainers.image.source",
+				"org.opencontainers.image.version",
+			},
 			Volumes: []string{"/var/www/html"},
 			Env: map[string]string{
 				"PATH":          "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin:/usr/local/apache2/bin",
@@ -81,13 +100,24 @@ var (
 				"HTTPD_BZ2_URL",
 			},
 			ExposedPorts: []int{80, 443},
+			Cmd:          []string{"httpd-foreground"},
+			User:         "www-data",
+			WorkingDir:   "/usr/local/apache2",
+			OS:           "linux",
+			Architecture: "arm64",
+			Healthcheck:  "curl -f http://localhost/",
+			Layers:       []string{"sha256:debian", "sha256:httpd"},
+			LayerSizes:   []int64{1024, 512},
+			History: []string{
+				"/bin/sh -c #(nop) ADD file:debian in /",
+				"/bin/sh -c apt-get update && apt-get install -y libapr1 libaprutil1",
+			},
 		},
 		{
-			ID:       "354678",
-			Name:     "mysql",
-			Tag:      "5.7",
-			FullName: "mysql:5.7",
-			Created:  indextest.ParseTime("2016-06-30T09:05:06Z"),
+			ID:      "354678",
+			Name:    "mysql",
+			Tags:    []string{"5.7"},
+			Created: indextest.ParseTime("2016-06-30T09:05:06Z"),
 			Label: map[string]string{
 				"type":      "sql",
 				"family":    "debian",
@@ -110,6 +140,19 @@ var (
 				"MYSQL_VERSION",
 			},
 			ExposedPorts: []int{3306},
+			Entrypoint:   []string{"docker-entrypoint.sh"},
+			Cmd:          []string{"mysqld"},
+			User:         "root",
+			OS:           "linux",
+			Architecture: "amd64",
+			Healthcheck:  "mysqladmin ping",
+			Layers:       []string{"sha256:debian", "sha256:mysql"},
+			LayerSizes:   []int64{1024, 256},
+			History: []string{
+				"/bin/sh -c #(nop) ADD file:debian in /",
+				"/bin/sh -c #(nop)  ARG MYSQL_MAJOR=5.7",
+				"/bin/sh -c apt-get update && apt-get install -y mysql-server",
+			},
 		},
 	}
 )
@@ -123,19 +166,20 @@ func (s *TestSuite) SetUpSuite(c *C) {
 		return
 	}
 
-	s.index = &Index{Index: i, RegClient: nil, Config: &Config{}}
+	s.store = i
+	s.index = &Index{Backend: NewBleveBackend(i), RegClient: nil, Config: &Config{}}
 }
 
 func (s *TestSuite) SetUpTest(c *C) {
 	for _, image := range images {
-		if err := s.index.Index.Index(image.FullName, image); err != nil {
+		if err := s.store.Index(documentID(&image), newImageDocument(&image)); err != nil {
 			logrus.WithError(err).Errorln("Failed to index image")
 		}
 	}
 }
 
 func (s *TestSuite) TearDownSuite(c *C) {
-	s.index.Index.Close()
+	s.index.Close()
 }
 
 func (s *TestSuite) TestNameTagSearch(c *C) {
@@ -159,9 +203,11 @@ func (s *TestSuite) TestNameTagSearch(c *C) {
 
 	for _, t := range tests {
 		c.Logf("Test with query %s", t)
-		request := bleve.NewSearchRequest(BuildQuery(t.query, ""))
-		request.Fields = []string{"Name", "Tag"}
-		results, err := s.index.Search(request)
+		query, err := BuildQuery(t.query, "")
+		c.Assert(err, IsNil)
+		request := bleve.NewSearchRequest(query)
+		request.Fields = []string{"Name", "Tags"}
+		results, err := s.store.Search(request)
 		c.Assert(err, IsNil)
 		c.Log(results)
 		c.Assert(results.Total, Equals, uint64(len(t.resultNames)))
@@ -199,9 +245,11 @@ func (s *TestSuite) TestAdvancedSearch(c *C) {
 
 	for _, t := range tests {
 		c.Logf("Test with query %s", t)
-		request := bleve.NewSearchRequest(BuildQuery("", t.query))
-		request.Fields = []string{"Name", "Tag"}
-		results, err := s.index.Search(request)
+		query, err := BuildQuery("", t.query)
+		c.Assert(err, IsNil)
+		request := bleve.NewSearchRequest(query)
+		request.Fields = []string{"Name", "Tags"}
+		results, err := s.store.Search(request)
 		c.Assert(err, IsNil)
 		c.Log(results)
 		c.Assert(results.Total, Equals, uint64(len(t.resultNames)))
@@ -212,16 +260,81 @@ func (s *TestSuite) TestAdvancedSearch(c *C) {
 	}
 }
 
+func (s *TestSuite) TestParseQuery(c *C) {
+	now := indextest.ParseTime("2016-07-25T00:00:00Z")
+	var tests = []struct {
+		query       string
+		resultNames []string
+	}{
+		{"size>1KB", []string{}},
+		{"size<=200MB", []string{"centos", "httpd", "mysql"}},
+		{"created<7d", []string{"centos"}},
+		{"created<30d", []string{"centos", "mysql"}},
+		{"Created>30d", []string{"httpd"}},
+		{"created=2016-06-30", []string{"mysql"}},
+		{"created>=2016-06-30", []string{"centos", "mysql"}},
+		{"created<2016-06-30T09:05:06Z", []string{"httpd"}},
+		{"label:family=debian", []string{"httpd", "mysql"}},
+		{"label:type", []string{"centos", "httpd", "mysql"}},
+		{"label:frame*", []string{"httpd", "mysql"}},
+		{"label:framework~apache*", []string{"httpd"}},
+		{"label:framework~/my.*/", []string{"mysql"}},
+		{"env:HTTPD_VERSION~2.4*", []string{"httpd"}},
+		{"env:MYSQL_MAJOR", []string{"mysql"}},
+		{"annotation:org.opencontainers.image.source", []string{"httpd"}},
+		{"port:3306", []string{"mysql"}},
+		{"port:443", []string{"httpd"}},
+		{"Name:centos OR Name:mysql", []string{"centos", "mysql"}},
+		{"label:family=debian AND NOT port:80", []string{"mysql"}},
+		{"label:family=debian -port:80", []string{"mysql"}},
+		{"NOT label:family=debian", []string{"centos"}},
+		{"(Name:centos OR Name:mysql) -(port:3306 OR port:80)", []string{"centos"}},
+		{"+size<1KB +port:443", []string{"httpd"}},
+		{"size<1KB AND port:443", []string{"httpd"}},
+		{"Name:centos Name:mysql", []string{"centos", "mysql"}},
+		{"Name:centos +port:3306", []string{"mysql"}},
+		{"Name:centos Name:mysql AND port:3306", []string{"mysql"}},
+		{"+(Name:centos OR Name:mysql) -Name:centos", []string{"mysql"}},
+		{"label:framework=apache-httpd", []string{"httpd"}},
+		{"label:framework=apache", []string{}},
+		{`label:framework="apache-httpd"`, []string{"httpd"}},
+		{"Layers:sha256:mysql OR Labels:/frame.*/", []string{"httpd", "mysql"}},
+	}
+
+	for _, t := range tests {
+		c.Logf("Test with query %s", t)
+		query, err := ParseQuery(t.query, now)
+		c.Assert(err, IsNil)
+		request := bleve.NewSearchRequest(query)
+		request.Fields = []string{"Name"}
+		request.SortBy([]string{"_id"})
+		results, err := s.store.Search(request)
+		c.Assert(err, IsNil)
+		c.Assert(results.Hits, HasLen, len(t.resultNames))
+		for i, r := range t.resultNames {
+			c.Assert(results.Hits[i].Fields["Name"], Equals, r)
+		}
+	}
+
+	for _, query := range []string{"(Name:mysql", "Name:mysql)", "Name:mysql OR", "AND port:80", "-", "\"unclosed", "Labels:/frame", "size>big", "created=30d", "created<yesterday", "label:=debian", "port:http", "Size:>abc"} {
+		c.Logf("Test with invalid query %s", query)
+		_, err := ParseQuery(query, now)
+		c.Assert(err, FitsTypeOf, &dim.QueryError{})
+	}
+}
+
 func (s *TestSuite) TestSearchResults(c *C) {
-	request := bleve.NewSearchRequest(BuildQuery("mysql", ""))
-	request.Fields = []string{"Name", "Tag", "ExposedPorts", "Volumes", "Labels", "Envs"}
-	results, err := s.index.Search(request)
+	query, err := BuildQuery("mysql", "")
+	c.Assert(err, IsNil)
+	request := bleve.NewSearchRequest(query)
+	request.Fields = []string{"Name", "Tags", "ExposedPorts", "Volumes", "Labels", "Envs"}
+	results, err := s.store.Search(request)
 	c.Assert(err, IsNil)
 	c.Log(results)
 	c.Assert(results.Total, Equals, uint64(1))
 	c.Assert(results.Hits, HasLen, 1)
 	c.Assert(results.Hits[0].Fields["ExposedPorts"], Equals, float64(3306))
-	c.Assert(results.Hits[0].Fields["Tag"], Equals, "5.7")
+	c.Assert(results.Hits[0].Fields["Tags"], Equals, "5.7")
 	c.Assert(results.Hits[0].Fields["Volumes"], DeepEquals, "/var/lib/mysql")
 	c.Assert(results.Hits[0].Fields["Labels"], DeepEquals, []interface{}{"type", "family", "framework"})
 	c.Assert(results.Hits[0].Fields["Envs"], DeepEquals, []interface{}{"PATH", "MYSQL_MAJOR", "MYSQL_VERSION"})
@@ -230,14 +343,273 @@ func (s *TestSuite) TestSearchResults(c *C) {
 
 func (s *TestSuite) TestDeleteImage(c *C) {
 	for _, image := range images {
-		request := bleve.NewSearchRequest(BuildQuery("", fmt.Sprintf("+Name:%s +Tag:%s", image.Name, image.Tag)))
-		request.Fields = []string{"Name", "Tag"}
-		results, err := s.index.Search(request)
+		query, err := BuildQuery("", fmt.Sprintf("+Name:%s +Tag:%s", image.Name, image.Tags[0]))
+		c.Assert(err, IsNil)
+		request := bleve.NewSearchRequest(query)
+		request.Fields = []string{"Name", "Tags"}
+		results, err := s.store.Search(request)
 		c.Assert(err, IsNil)
 		c.Assert(results.Hits, HasLen, 1)
-		s.index.DeleteImage(image.ID)
-		results, err = s.index.Search(request)
+		removed, err := s.index.DeleteImage(image.Name, image.ID)
+		c.Assert(err, IsNil)
+		c.Assert(removed, HasLen, 1)
+		c.Assert(removed[0].Tags, DeepEquals, image.Tags)
+		results, err = s.store.Search(request)
 		c.Assert(err, IsNil)
 		c.Assert(results.Hits, HasLen, 0)
 	}
 }
+
+func (s *TestSuite) TestNewReusesIndex(c *C) {
+	dir, err := ioutil.TempDir("", "dim")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	cfg := &Config{Directory: path.Join(dir, "index")}
+	idx, err := New(cfg, nil)
+	c.Assert(err, IsNil)
+	idx.IndexImage(&images[0])
+	c.Assert(idx.Close(), IsNil)
+
+	idx, err = New(cfg, nil)
+	c.Assert(err, IsNil)
+	count, err := idx.Backend.Count()
+	c.Assert(err, IsNil)
+	c.Assert(count, Equals, uint64(1))
+	c.Assert(idx.Close(), IsNil)
+
+	cfg.Rebuild = true
+	idx, err = New(cfg, nil)
+	c.Assert(err, IsNil)
+	count, err = idx.Backend.Count()
+	c.Assert(err, IsNil)
+	c.Assert(count, Equals, uint64(0))
+	c.Assert(idx.Close(), IsNil)
+}
+
+func (s *TestSuite) TestNewReplacesLegacyIndexes(c *C) {
+	dir, err := ioutil.TempDir("", "dim")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	// Older versions created an index in a timestamped subdirectory at each start
+	legacy, err := bleve.New(path.Join(dir, "20160724090506.123"), bleve.NewIndexMapping())
+	c.Assert(err, IsNil)
+	c.Assert(legacy.Close(), IsNil)
+
+	idx, err := New(&Config{Directory: dir}, nil)
+	c.Assert(err, IsNil)
+	count, err := idx.Backend.Count()
+	c.Assert(err, IsNil)
+	c.Assert(count, Equals, uint64(0))
+	c.Assert(idx.Close(), IsNil)
+
+	// Any other content is not removed
+	other, err := ioutil.TempDir("", "dim")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(other)
+	c.Assert(os.Mkdir(path.Join(other, "data"), 0755), IsNil)
+	_, err = New(&Config{Directory: other}, nil)
+	c.Assert(err, NotNil)
+}
+
+func (s *TestSuite) TestConcurrentTags(c *C) {
+	i, err := indextest.MockIndex(ImageMapping)
+	c.Assert(err, IsNil)
+	defer i.Close()
+	idx := &Index{Backend: NewBleveBackend(i), Config: &Config{}}
+
+	// Tags pushed together for a manifest are all kept in its document
+	tags := []string{"1", "1.0", "1.0.1", "latest", "stable", "lts"}
+	wg := sync.WaitGroup{}
+	for _, tag := range tags {
+		wg.Add(1)
+		go func(tag string) {
+			defer wg.Done()
+			c.Check(idx.replaceTag("", "redis", tag, []*dim.IndexImage{{ID: "redis-digest", Name: "redis", Tag: tag, Tags: []string{tag}}}), IsNil)
+		}(tag)
+	}
+	wg.Wait()
+
+	image, err := idx.Backend.Get("redis@redis-digest")
+	c.Assert(err, IsNil)
+	c.Assert(image, NotNil)
+	sort.Strings(tags)
+	c.Assert(image.Tags, DeepEquals, tags)
+}
+
+func (s *TestSuite) TestSearchFacets(c *C) {
+	sr, err := s.index.SearchImages("", "*", "", nil, []string{"Label.family", "Repository:2", "Size", "Created"}, nil, 0, 10)
+	c.Assert(err, IsNil)
+	c.Assert(sr.Facets, HasLen, 4)
+
+	c.Assert(sr.Facets["Label.family"].Terms, DeepEquals, []dim.FacetTerm{{Term: "debian", Count: 2}, {Term: "rhel", Count: 1}})
+	c.Assert(sr.Facets["Repository"].Terms, HasLen, 2)
+	c.Assert(sr.Facets["Repository"].Other, Equals, 1)
+	c.Assert(sr.Facets["Size"].Terms, DeepEquals, []dim.FacetTerm{{Term: "< 10MB", Count: 3}})
+	c.Assert(sr.Facets["Created"].Terms, DeepEquals, []dim.FacetTerm{{Term: "> 1 year", Count: 3}})
+
+	// Facets count whole values rather than the words of the values
+	sr, err = s.index.SearchImages("", "*", "", nil, []string{"Label.framework", "Author"}, nil, 0, 10)
+	c.Assert(err, IsNil)
+	c.Assert(sr.Facets["Label.framework"].Field, Equals, "Label.framework")
+	c.Assert(sr.Facets["Label.framework"].Terms, DeepEquals, []dim.FacetTerm{{Term: "apache-httpd", Count: 1}, {Term: "mysql", Count: 1}})
+	c.Assert(sr.Facets["Author"].Terms, DeepEquals, []dim.FacetTerm{{Term: "John Doe <john.doe@example.com>", Count: 1}})
+
+	for _, spec := range []string{"Label.family:0", "Label.family:ten", ":3"} {
+		_, err = s.index.SearchImages("", "*", "", nil, []string{spec}, nil, 0, 10)
+		_, ok := err.(*dim.QueryError)
+		c.Assert(ok, Equals, true, Commentf("facet %s should be rejected", spec))
+	}
+}
+
+func (s *TestSuite) TestSearchSort(c *C) {
+	scenarii := []struct {
+		sort     []string
+		offset   int
+		expected []string
+	}{
+		{[]string{"-Created"}, 0, []string{"centos:centos6", "mysql:5.7", "httpd:2.4"}},
+		{[]string{"Created"}, 0, []string{"httpd:2.4", "mysql:5.7", "centos:centos6"}},
+		{[]string{"Name"}, 0, []string{"centos:centos6", "httpd:2.4", "mysql:5.7"}},
+		{[]string{"-Name"}, 1, []string{"httpd:2.4", "centos:centos6"}},
+		{[]string{"Size", "Tag"}, 0, []string{"httpd:2.4", "mysql:5.7", "centos:centos6"}},
+		{nil, 0, []string{"centos:centos6", "httpd:2.4", "mysql:5.7"}},
+	}
+
+	for _, scenario := range scenarii {
+		sr, err := s.index.SearchImages("", "*", "", nil, nil, scenario.sort, scenario.offset, 10)
+		c.Assert(err, IsNil)
+		names := make([]string, 0, len(sr.Images))
+		for _, image := range sr.Images {
+			names = append(names, image.FullName)
+		}
+		c.Assert(names, DeepEquals, scenario.expected, Commentf("sort %v", scenario.sort))
+	}
+
+	_, err := s.index.SearchImages("", "*", "", nil, nil, []string{"Volumes"}, 0, 10)
+	_, ok := err.(*dim.QueryError)
+	c.Assert(ok, Equals, true)
+}
+
+func (s *TestSuite) TestSearchLayers(c *C) {
+	scenarii := []struct {
+		query    string
+		expected []string
+	}{
+		{"Layers:sha256:debian", []string{"httpd:2.4", "mysql:5.7"}},
+		{"Layers:sha256:httpd", []string{"httpd:2.4"}},
+		{"+Layers:sha256:debian -Layers:sha256:mysql", []string{"httpd:2.4"}},
+		{"Layers:sha256:unknown", []string{}},
+	}
+
+	for _, scenario := range scenarii {
+		sr, err := s.index.SearchImages("", scenario.query, "", nil, nil, []string{"FullName"}, 0, 10)
+		c.Assert(err, IsNil)
+		names := make([]string, 0, len(sr.Images))
+		for _, image := range sr.Images {
+			names = append(names, image.FullName)
+		}
+		c.Assert(names, DeepEquals, scenario.expected, Commentf("query %s", scenario.query))
+	}
+
+	image, err := s.index.FindImage("234567")
+	c.Assert(err, IsNil)
+	c.Assert(image.Layers, DeepEquals, []string{"sha256:debian", "sha256:httpd"})
+	c.Assert(image.LayerSizes, DeepEquals, []int64{1024, 512})
+}
+
+func (s *TestSuite) TestSearchHistory(c *C) {
+	scenarii := []struct {
+		query    string
+		expected []string
+	}{
+		{`History:"apt-get install"`, []string{"httpd:2.4", "mysql:5.7"}},
+		{`History:"install -y mysql-server"`, []string{"mysql:5.7"}},
+		{`History:ARG`, []string{"mysql:5.7"}},
+		{`History:openjdk`, []string{}},
+	}
+
+	for _, scenario := range scenarii {
+		sr, err := s.index.SearchImages("", scenario.query, "", nil, nil, []string{"FullName"}, 0, 10)
+		c.Assert(err, IsNil)
+		names := make([]string, 0, len(sr.Images))
+		for _, image := range sr.Images {
+			names = append(names, image.FullName)
+		}
+		c.Assert(names, DeepEquals, scenario.expected, Commentf("query %s", scenario.query))
+	}
+}
+
+func (s *TestSuite) TestSearchRuntimeConfig(c *C) {
+	scenarii := []struct {
+		query    string
+		expected []string
+	}{
+		{"User:root", []string{"centos:centos6", "mysql:5.7"}},
+		{"Architecture:arm64", []string{"httpd:2.4"}},
+		{"-Healthcheck:*", []string{"centos:centos6"}},
+		{"+User:root -Healthcheck:*", []string{"centos:centos6"}},
+		{"Cmd:mysqld", []string{"mysql:5.7"}},
+		{`WorkingDir:"/usr/local/apache2"`, []string{"httpd:2.4"}},
+	}
+
+	for _, scenario := range scenarii {
+		sr, err := s.index.SearchImages("", scenario.query, "", nil, nil, []string{"FullName"}, 0, 10)
+		c.Assert(err, IsNil)
+		names := make([]string, 0, len(sr.Images))
+		for _, image := range sr.Images {
+			names = append(names, image.FullName)
+		}
+		c.Assert(names, DeepEquals, scenario.expected, Commentf("query %s", scenario.query))
+	}
+
+	image, err := s.index.FindImage("354678")
+	c.Assert(err, IsNil)
+	c.Assert(image.Entrypoint, DeepEquals, []string{"docker-entrypoint.sh"})
+	c.Assert(image.User, Equals, "root")
+	c.Assert(image.Architecture, Equals, "amd64")
+	c.Assert(image.Healthcheck, Equals, "mysqladmin ping")
+}
+
+func (s *TestSuite) TestSearchAnnotations(c *C) {
+	scenarii := []struct {
+		query    string
+		expected []string
+	}{
+		{"Annotations:org.opencontainers.image.version", []string{"httpd:2.4"}},
+		{"Annotation.org.opencontainers.image.version:2.4.18", []string{"httpd:2.4"}},
+		{`Annotation.org.opencontainers.image.source:"docker-library/httpd"`, []string{"httpd:2.4"}},
+		{"-Annotations:*", []string{"centos:centos6", "mysql:5.7"}},
+	}
+
+	for _, scenario := range scenarii {
+		sr, err := s.index.SearchImages("", scenario.query, "", nil, nil, []string{"FullName"}, 0, 10)
+		c.Assert(err, IsNil)
+		names := make([]string, 0, len(sr.Images))
+		for _, image := range sr.Images {
+			names = append(names, image.FullName)
+		}
+		c.Assert(names, DeepEquals, scenario.expected, Commentf("query %s", scenario.query))
+	}
+
+	sr, err := s.index.SearchImages("", "Name:httpd", "", []string{"Annotations"}, nil, nil, 0, 10)
+	c.Assert(err, IsNil)
+	c.Assert(sr.Images, HasLen, 1)
+	c.Assert(sr.Images[0].Annotation, DeepEquals, images[1].Annotation)
+}
+
+func (s *TestSuite) TestSearchFields(c *C) {
+	sr, err := s.index.SearchImages("", "Name:mysql", "", []string{"Labels", "Volumes"}, nil, nil, 0, 10)
+	c.Assert(err, IsNil)
+	c.Assert(sr.Images, HasLen, 1)
+	image := sr.Images[0]
+	c.Assert(image.Name, Equals, "mysql")
+	c.Assert(image.Tags, DeepEquals, []string{"5.7"})
+	c.Assert(image.Label, DeepEquals, images[2].Label)
+	c.Assert(image.Volumes, DeepEquals, images[2].Volumes)
+	// Fields that were not requested are not returned
+	c.Assert(image.Env, IsNil)
+	c.Assert(image.Entrypoint, IsNil)
+	c.Assert(image.ExposedPorts, IsNil)
+}
@@ -0,0 +1,137 @@
+// Copyright 2016
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package index
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/nhurel/dim/lib"
+)
+
+// link infers the lineage of the images of the change set and of the indexed images built on them.
+// An image is built on every image whose layers are a strict prefix of its own layers, and its parent is the closest of them.
+// Only the images related to the ones of the change set are read. The images whose lineage changes are added to the change set
+func (cs *changeSet) link() error {
+	changed := make(map[string]bool)
+	family := make(map[string]*dim.IndexImage)
+	for _, doc := range cs.docs {
+		key := layersKey(doc.Layers)
+		if len(doc.Layers) == 0 || changed[key] {
+			continue
+		}
+		changed[key] = true
+		related, err := cs.idx.Backend.Related(doc.Layers)
+		if err != nil {
+			return fmt.Errorf("Failed to find the images related to %s : %v", doc.Name, err)
+		}
+		for _, image := range related {
+			family[documentID(image)] = image
+		}
+	}
+
+	// Images of the change set replace the indexed ones. Their descendants may have a new lineage
+	for id, doc := range cs.docs {
+		if len(doc.Layers) > 0 {
+			family[id] = doc
+		}
+	}
+
+	// Images are grouped by layers to find the bases of an image among the prefixes of its layers
+	byLayers := make(map[string][]string, len(family))
+	for id, image := range family {
+		if len(image.Tags) == 0 {
+			continue
+		}
+		key := layersKey(image.Layers)
+		byLayers[key] = append(byLayers[key], id)
+	}
+
+	for id, image := range family {
+		if len(image.Tags) == 0 || !inherits(image.Layers, changed) {
+			continue
+		}
+		parent, basedOn := lineage(image.Layers, family, byLayers)
+		if parent == image.Parent && stringsEqual(basedOn, image.BasedOn) {
+			continue
+		}
+		doc, err := cs.doc(id)
+		if err != nil {
+			return err
+		}
+		doc.Parent, doc.BasedOn = parent, basedOn
+	}
+	return nil
+}
+
+// lineage returns the digest of the parent of an image with the given layers, and the names of all the images it is built on.
+// Images of a federated index are named with and without their registry
+func lineage(layers []string, family map[string]*dim.IndexImage, byLayers map[string][]string) (string, []string) {
+	parent := ""
+	names := make(map[string]bool)
+	for n := 1; n < len(layers); n++ {
+		ids := byLayers[layersKey(layers[:n])]
+		sort.Strings(ids)
+		for _, id := range ids {
+			base := family[id]
+			// The closest base has the most layers
+			parent = base.ID
+			// Names qualified with their registry are listed along with the plain ones, so that BasedOn:ubuntu matches in every registry
+			for _, name := range []string{base.Name, qualifiedName(base.Registry, base.Name)} {
+				names[name] = true
+				for _, tag := range base.Tags {
+					names[name+":"+tag] = true
+				}
+			}
+		}
+	}
+	if len(names) == 0 {
+		return "", nil
+	}
+	basedOn := make([]string, 0, len(names))
+	for name := range names {
+		basedOn = append(basedOn, name)
+	}
+	sort.Strings(basedOn)
+	return parent, basedOn
+}
+
+// inherits tells whether the lineage of an image with the given layers depends on the images having one of the changed layer lists
+func inherits(layers []string, changed map[string]bool) bool {
+	for n := 1; n <= len(layers); n++ {
+		if changed[layersKey(layers[:n])] {
+			return true
+		}
+	}
+	return false
+}
+
+// layersKey identifies a list of layers
+func layersKey(layers []string) string {
+	return strings.Join(layers, " ")
+}
+
+// stringsEqual tells whether two lists hold the same strings in the same order
+func stringsEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
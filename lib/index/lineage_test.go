@@ -0,0 +1,127 @@
+// Copyright 2016
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package index
+
+import (
+	"time"
+
+	"github.com/blevesearch/bleve"
+	"github.com/nhurel/dim/lib"
+	"github.com/nhurel/dim/lib/index/indextest"
+	. "gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestLineage(c *C) {
+	i, err := indextest.MockIndex(ImageMapping)
+	c.Assert(err, IsNil)
+	defer i.Close()
+	idx := &Index{Backend: NewBleveBackend(i), Config: &Config{}}
+
+	image := func(name, tag string, layers ...string) *dim.IndexImage {
+		return &dim.IndexImage{ID: name + "-digest", Name: name, Tag: tag, Tags: []string{tag}, Layers: layers}
+	}
+	lineageOf := func(name string) (string, []string) {
+		image, err := idx.Backend.Get(name + "@" + name + "-digest")
+		c.Assert(err, IsNil)
+		c.Assert(image, NotNil)
+		return image.Parent, image.BasedOn
+	}
+	search := func(query string) []string {
+		q, err := ParseQuery(query, time.Now())
+		c.Assert(err, IsNil)
+		rq := bleve.NewSearchRequest(q)
+		rq.SortBy([]string{"_id"})
+		sr, err := i.Search(rq)
+		c.Assert(err, IsNil)
+		ids := make([]string, 0, len(sr.Hits))
+		for _, h := range sr.Hits {
+			ids = append(ids, h.ID)
+		}
+		return ids
+	}
+
+	// Images indexed before their base images get their lineage when the base images are indexed
+	c.Assert(idx.replaceTag("", "app", "1.0", []*dim.IndexImage{image("app", "1.0", "debian", "httpd", "app")}), IsNil)
+	parent, basedOn := lineageOf("app")
+	c.Assert(parent, Equals, "")
+	c.Assert(basedOn, IsNil)
+
+	c.Assert(idx.replaceTag("", "debian", "8", []*dim.IndexImage{image("debian", "8", "debian")}), IsNil)
+	parent, basedOn = lineageOf("app")
+	c.Assert(parent, Equals, "debian-digest")
+	c.Assert(basedOn, DeepEquals, []string{"debian", "debian:8"})
+
+	c.Assert(idx.replaceTag("", "httpd", "2.4", []*dim.IndexImage{image("httpd", "2.4", "debian", "httpd")}), IsNil)
+	parent, basedOn = lineageOf("httpd")
+	c.Assert(parent, Equals, "debian-digest")
+	c.Assert(basedOn, DeepEquals, []string{"debian", "debian:8"})
+	parent, basedOn = lineageOf("app")
+	c.Assert(parent, Equals, "httpd-digest")
+	c.Assert(basedOn, DeepEquals, []string{"debian", "debian:8", "httpd", "httpd:2.4"})
+
+	c.Assert(search("BasedOn:debian:8"), DeepEquals, []string{"app@app-digest", "httpd@httpd-digest"})
+	c.Assert(search("basedon:httpd"), DeepEquals, []string{"app@app-digest"})
+	c.Assert(search("Parent:httpd-digest"), DeepEquals, []string{"app@app-digest"})
+
+	// New tags of a base image are inherited
+	c.Assert(idx.replaceTag("", "debian", "jessie", []*dim.IndexImage{image("debian", "jessie", "debian")}), IsNil)
+	_, basedOn = lineageOf("app")
+	c.Assert(basedOn, DeepEquals, []string{"debian", "debian:8", "debian:jessie", "httpd", "httpd:2.4"})
+
+	// Removing a base image removes it from the lineage
+	_, err = idx.deleteImage("", "debian", "debian-digest")
+	c.Assert(err, IsNil)
+	parent, basedOn = lineageOf("httpd")
+	c.Assert(parent, Equals, "")
+	c.Assert(basedOn, IsNil)
+	parent, basedOn = lineageOf("app")
+	c.Assert(parent, Equals, "httpd-digest")
+	c.Assert(basedOn, DeepEquals, []string{"httpd", "httpd:2.4"})
+
+	// Base images of federated registries are found with or without their registry
+	mirrored := image("ubuntu", "16.04", "ubuntu")
+	mirrored.Registry = "hub"
+	c.Assert(idx.replaceTag("hub", "ubuntu", "16.04", []*dim.IndexImage{mirrored}), IsNil)
+	c.Assert(idx.replaceTag("", "tool", "1.0", []*dim.IndexImage{image("tool", "1.0", "ubuntu", "tool")}), IsNil)
+	_, basedOn = lineageOf("tool")
+	c.Assert(basedOn, DeepEquals, []string{"hub/ubuntu", "hub/ubuntu:16.04", "ubuntu", "ubuntu:16.04"})
+	c.Assert(search("BasedOn:ubuntu:16.04"), DeepEquals, []string{"tool@tool-digest"})
+	c.Assert(search("BasedOn:hub/ubuntu:16.04"), DeepEquals, []string{"tool@tool-digest"})
+}
+
+func (s *TestSuite) TestRelated(c *C) {
+	i, err := indextest.MockIndex(ImageMapping)
+	c.Assert(err, IsNil)
+	defer i.Close()
+	idx := &Index{Backend: NewBleveBackend(i), Config: &Config{}}
+
+	for _, image := range []*dim.IndexImage{
+		{ID: "debian-digest", Name: "debian", Tags: []string{"8"}, Layers: []string{"debian"}},
+		{ID: "httpd-digest", Name: "httpd", Tags: []string{"2.4"}, Layers: []string{"debian", "httpd"}},
+		{ID: "app-digest", Name: "app", Tags: []string{"1.0"}, Layers: []string{"debian", "httpd", "app"}},
+		{ID: "mysql-digest", Name: "mysql", Tags: []string{"5.7"}, Layers: []string{"debian", "mysql"}},
+		{ID: "httpd2-digest", Name: "httpd2", Tags: []string{"2.4"}, Layers: []string{"debian", "httpd2"}},
+	} {
+		idx.IndexImage(image)
+	}
+
+	// Images sharing the base layer without being built on the image or under it are not read
+	related, err := idx.Backend.Related([]string{"debian", "httpd"})
+	c.Assert(err, IsNil)
+	names := make([]string, len(related))
+	for n, image := range related {
+		names[n] = image.Name
+	}
+	c.Assert(names, DeepEquals, []string{"app", "debian", "httpd"})
+}
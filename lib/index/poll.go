@@ -0,0 +1,164 @@
+// Copyright 2016
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package index
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/distribution/digest"
+	"github.com/nhurel/dim/lib"
+)
+
+// poll looks for changes on the registry every interval until the index is closed.
+// Each change is submitted as a notification job so registries that cannot send notifications keep the index current and fire hooks
+func (idx *Index) poll(interval time.Duration) {
+	logrus.WithField("interval", interval).Infoln("Starting registry polling")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var known map[string]map[string]string
+	for {
+		select {
+		case <-ticker.C:
+			if known == nil {
+				var err error
+				if known, err = idx.pollBaseline(); err != nil {
+					logrus.WithError(err).Errorln("Failed to read indexed images before polling registry")
+					continue
+				}
+			}
+			for name, client := range idx.clients() {
+				var jobs []*dim.NotificationJob
+				jobs, known[name] = pollChanges(name, client, known[name])
+				logrus.WithFields(logrus.Fields{"registry": name, "changes": len(jobs)}).Debugln("Polled registry")
+				for _, job := range jobs {
+					idx.Submit(job)
+				}
+			}
+		case <-idx.stop:
+			return
+		}
+	}
+}
+
+// pollBaseline returns the indexed tags once no reconciliation is running, so the first poll only reports real changes
+func (idx *Index) pollBaseline() (map[string]map[string]string, error) {
+	idx.reconciling.Lock()
+	defer idx.reconciling.Unlock()
+	return idx.indexedTags()
+}
+
+// polledRepository holds the manifest digest of every tag of a repository
+type polledRepository struct {
+	name    string
+	digests map[string]string
+	failed  bool
+}
+
+// pollChanges compares the tag digests of a registry with the known ones, by image full name.
+// It returns the jobs pushing the new or moved tags and deleting the vanished ones, along with the digests now known.
+// Tags of a repository that cannot be read are kept as they were
+func pollChanges(registry string, client dim.RegistryClient, known map[string]string) ([]*dim.NotificationJob, map[string]string) {
+	polled := make(chan *polledRepository, 5)
+	wg := sync.WaitGroup{}
+	for repository := range client.WalkRepositories() {
+		wg.Add(1)
+		go func(repo dim.Repository) {
+			defer wg.Done()
+			polled <- pollRepository(repo, known)
+		}(repository)
+	}
+
+	go func() {
+		wg.Wait()
+		close(polled)
+	}()
+
+	current := make(map[string]string, len(known))
+	failed := make(map[string]bool)
+	for repo := range polled {
+		if repo.failed {
+			failed[repo.name] = true
+		}
+		for fullName, dg := range repo.digests {
+			current[fullName] = dg
+		}
+	}
+
+	jobs := make([]*dim.NotificationJob, 0)
+	for fullName, dg := range current {
+		if previous, ok := known[fullName]; !ok || previous != dg {
+			name, tag := splitFullName(fullName)
+			jobs = append(jobs, &dim.NotificationJob{Registry: registry, Action: dim.PushAction, Repository: name, Tag: tag, Digest: digest.Digest(dg)})
+		}
+	}
+	for fullName, dg := range known {
+		if _, ok := current[fullName]; ok {
+			continue
+		}
+		name, tag := splitFullName(fullName)
+		if failed[name] {
+			current[fullName] = dg
+			continue
+		}
+		jobs = append(jobs, &dim.NotificationJob{Registry: registry, Action: dim.DeleteAction, Repository: name, Tag: tag, Digest: digest.Digest(dg)})
+	}
+
+	sort.Slice(jobs, func(i, j int) bool {
+		if jobs[i].Repository != jobs[j].Repository {
+			return jobs[i].Repository < jobs[j].Repository
+		}
+		return jobs[i].Tag < jobs[j].Tag
+	})
+	return jobs, current
+}
+
+// pollRepository reads the manifest digest of every tag of a repository.
+// The known digest of a tag is kept when the registry fails to return it
+func pollRepository(repo dim.Repository, known map[string]string) *polledRepository {
+	polled := &polledRepository{name: repo.Named().Name(), digests: make(map[string]string)}
+	l := logrus.WithField("repository", polled.name)
+
+	tags, err := repo.AllTags()
+	if err != nil {
+		l.WithError(err).Errorln("Failed to get tags while polling registry")
+		polled.failed = true
+		return polled
+	}
+
+	for _, tag := range tags {
+		fullName := fmt.Sprintf("%s:%s", polled.name, tag)
+		dg, err := repo.TagDigest(tag)
+		if err != nil {
+			l.WithError(err).WithField("tag", tag).Errorln("Failed to get tag digest while polling registry")
+			if previous, ok := known[fullName]; ok {
+				polled.digests[fullName] = previous
+			}
+			continue
+		}
+		polled.digests[fullName] = dg.String()
+	}
+	return polled
+}
+
+// splitFullName returns the repository and the tag of an image full name
+func splitFullName(fullName string) (string, string) {
+	i := strings.LastIndex(fullName, ":")
+	return fullName[:i], fullName[i+1:]
+}
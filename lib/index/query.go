@@ -0,0 +1,487 @@
+// Copyright 2016
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package index
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blevesearch/bleve"
+	"github.com/docker/go-units"
+	"github.com/nhurel/dim/lib"
+)
+
+// ParseQuery converts an advanced search into a bleve query.
+// Besides the bleve query string syntax, it understands boolean operators (AND, OR, NOT, parentheses) and shortcuts like
+// size>200MB, created<30d, label:team=payments, env:JAVA_VERSION~1.8* or port:8080.
+// Relative dates are computed from now. Syntax errors are returned as a dim.QueryError
+func ParseQuery(advanced string, now time.Time) (bleve.Query, error) {
+	tokens, err := lex(advanced)
+	if err != nil {
+		return nil, err
+	}
+	p := &queryParser{tokens: tokens, now: now}
+	var c *clause
+	if c, err = p.parseOr(); err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, syntaxError(t.pos, "unexpected %s", t)
+	}
+	return c.query(), nil
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenTerm
+	tokenAnd
+	tokenOr
+	tokenNot
+	tokenRequired
+	tokenOpen
+	tokenClose
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	// pos is the position of the token in the query, starting at 1
+	pos int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of query"
+	case tokenOpen:
+		return "opening parenthesis"
+	case tokenClose:
+		return "closing parenthesis"
+	}
+	return strconv.Quote(t.text)
+}
+
+func syntaxError(pos int, format string, args ...interface{}) error {
+	return &dim.QueryError{Message: fmt.Sprintf("Syntax error at position %d : %s", pos, fmt.Sprintf(format, args...))}
+}
+
+// lex splits a query into terms, operators and parentheses.
+// Quoted strings and /regular expressions/ are kept in a single term
+func lex(query string) ([]token, error) {
+	tokens := make([]token, 0, 10)
+	for i := 0; i < len(query); {
+		switch c := query[i]; {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '(':
+			tokens = append(tokens, token{tokenOpen, "(", i + 1})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{tokenClose, ")", i + 1})
+			i++
+		case c == '-' && i+1 < len(query) && query[i+1] == '(':
+			tokens = append(tokens, token{tokenNot, "-", i + 1})
+			i++
+		case c == '+' && i+1 < len(query) && query[i+1] == '(':
+			tokens = append(tokens, token{tokenRequired, "+", i + 1})
+			i++
+		default:
+			start := i
+			var err error
+			if i, err = lexTerm(query, i); err != nil {
+				return nil, err
+			}
+			t := token{tokenTerm, query[start:i], start + 1}
+			switch t.text {
+			case "AND":
+				t.kind = tokenAnd
+			case "OR":
+				t.kind = tokenOr
+			case "NOT":
+				t.kind = tokenNot
+			}
+			tokens = append(tokens, t)
+		}
+	}
+	return append(tokens, token{tokenEOF, "", len(query) + 1}), nil
+}
+
+// lexTerm returns the index of the end of the term starting at i
+func lexTerm(query string, i int) (int, error) {
+	start := i
+	for i < len(query) {
+		switch c := query[i]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == ')':
+			return i, nil
+		case c == '\\':
+			i += 2
+		case c == '"':
+			end := closing(query, i, '"')
+			if end < 0 {
+				return 0, syntaxError(i+1, "quote is never closed")
+			}
+			i = end + 1
+		case c == '/' && (i == start || strings.ContainsRune(":=~+-", rune(query[i-1]))):
+			end := closing(query, i, '/')
+			if end < 0 {
+				return 0, syntaxError(i+1, "regular expression is never closed")
+			}
+			i = end + 1
+		default:
+			i++
+		}
+	}
+	if i > len(query) {
+		return 0, syntaxError(len(query), "nothing to escape at the end of the query")
+	}
+	return i, nil
+}
+
+// closing returns the index of the unescaped delimiter closing the one at index i, or -1
+func closing(query string, i int, delimiter byte) int {
+	for j := i + 1; j < len(query); j++ {
+		switch query[j] {
+		case '\\':
+			j++
+		case delimiter:
+			return j
+		}
+	}
+	return -1
+}
+
+// clause is a parsed query, possibly negated or required
+type clause struct {
+	q        bleve.Query
+	not      bool
+	required bool
+}
+
+// query returns the bleve query of the clause. A negated clause matches all the images not matching it
+func (c *clause) query() bleve.Query {
+	if c.not {
+		return bleve.NewBooleanQuery([]bleve.Query{bleve.NewMatchAllQuery()}, nil, []bleve.Query{c.q})
+	}
+	return c.q
+}
+
+type queryParser struct {
+	tokens []token
+	next   int
+	now    time.Time
+}
+
+func (p *queryParser) peek() token {
+	return p.tokens[p.next]
+}
+
+func (p *queryParser) pop() token {
+	t := p.tokens[p.next]
+	if t.kind != tokenEOF {
+		p.next++
+	}
+	return t
+}
+
+// parseOr parses clauses separated by OR
+func (p *queryParser) parseOr() (*clause, error) {
+	first, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	clauses := []bleve.Query{first.query()}
+	for p.peek().kind == tokenOr {
+		p.pop()
+		var c *clause
+		if c, err = p.parseAnd(); err != nil {
+			return nil, err
+		}
+		clauses = append(clauses, c.query())
+	}
+	if len(clauses) == 1 {
+		return first, nil
+	}
+	return &clause{q: bleve.NewDisjunctionQuery(clauses)}, nil
+}
+
+// parseAnd parses clauses separated by AND or by spaces.
+// As in the bleve query string syntax, at least one of the clauses separated by spaces must match.
+// Clauses joined by AND and clauses prefixed with + must match, negated clauses must not match
+func (p *queryParser) parseAnd() (*clause, error) {
+	var clauses []*clause
+	and := false
+	for {
+		c, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		c.required = c.required || and
+		clauses = append(clauses, c)
+
+		switch t := p.peek(); t.kind {
+		case tokenOr, tokenClose, tokenEOF:
+			return joinClauses(clauses), nil
+		case tokenAnd:
+			p.pop()
+			c.required = true
+			and = true
+		default:
+			and = false
+		}
+	}
+}
+
+// joinClauses returns a clause matching the images that match the required clauses and at least one of the optional ones, if any,
+// but none of the negated clauses
+func joinClauses(clauses []*clause) *clause {
+	if len(clauses) == 1 {
+		return clauses[0]
+	}
+	var must, should, mustNot []bleve.Query
+	for _, c := range clauses {
+		switch {
+		case c.not:
+			mustNot = append(mustNot, c.q)
+		case c.required:
+			must = append(must, c.q)
+		default:
+			should = append(should, c.q)
+		}
+	}
+	if len(must)+len(should) == 0 {
+		must = []bleve.Query{bleve.NewMatchAllQuery()}
+	}
+	return &clause{q: bleve.NewBooleanQuery(must, should, mustNot)}
+}
+
+// parseUnary parses a term or a group of clauses in parentheses, possibly negated or required
+func (p *queryParser) parseUnary() (*clause, error) {
+	switch t := p.pop(); t.kind {
+	case tokenNot:
+		c, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &clause{q: c.q, not: !c.not}, nil
+	case tokenRequired:
+		c, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &clause{q: c.q, not: c.not, required: !c.not}, nil
+	case tokenOpen:
+		c, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.pop(); closing.kind != tokenClose {
+			return nil, syntaxError(t.pos, "parenthesis is never closed")
+		}
+		return c, nil
+	case tokenTerm:
+		return p.parseTerm(t)
+	default:
+		return nil, syntaxError(t.pos, "expected a search term but found %s", t)
+	}
+}
+
+// parseTerm parses a single term. Terms prefixed with - are negated and terms prefixed with + are required
+func (p *queryParser) parseTerm(t token) (*clause, error) {
+	text, not, required := t.text, false, false
+	for len(text) > 0 && (text[0] == '-' || text[0] == '+') {
+		not = not != (text[0] == '-')
+		required = required || text[0] == '+'
+		text = text[1:]
+	}
+	if text == "" {
+		return nil, syntaxError(t.pos, "%s is not followed by a search term", t)
+	}
+
+	q, err := termQuery(text, p.now)
+	if err != nil {
+		return nil, &dim.QueryError{Message: fmt.Sprintf("Invalid search term %s at position %d : %v", strconv.Quote(text), t.pos, err)}
+	}
+	return &clause{q: q, not: not, required: required && !not}, nil
+}
+
+var (
+	rangeTermRegexp = regexp.MustCompile(`^(?i)(size|created)(>=|<=|>|<|=)(.*)$`)
+	mapTermRegexp   = regexp.MustCompile(`^(?i)(label|env|annotation):(.*)$`)
+	portTermRegexp  = regexp.MustCompile(`^(?i)port:(.*)$`)
+	// Base images are named like repository:tag, whose colon the query string syntax does not accept
+	basedOnTermRegexp = regexp.MustCompile(`^(?i)basedon:(.+)$`)
+	ageRegexp         = regexp.MustCompile(`^(\d+)([hdwy])$`)
+)
+
+// mapField describes the fields indexing a map of the images
+type mapField struct {
+	// values is the prefix of the fields holding the analyzed values
+	values string
+	// keys is the field listing the keys
+	keys string
+	// exact is the prefix of the fields holding the whole values
+	exact string
+}
+
+// mapFields gives the fields of the map of each map shortcut
+var mapFields = map[string]mapField{
+	"label":      {"Label.", "Labels", "LabelValue."},
+	"env":        {"Env.", "Envs", "EnvValue."},
+	"annotation": {"Annotation.", "Annotations", "AnnotationValue."},
+}
+
+// termQuery converts a term into a query. Terms that are not shortcuts are parsed with the bleve query string syntax
+func termQuery(term string, now time.Time) (bleve.Query, error) {
+	if m := rangeTermRegexp.FindStringSubmatch(term); m != nil {
+		if strings.ToLower(m[1]) == "size" {
+			return sizeQuery(m[2], m[3])
+		}
+		return createdQuery(m[2], m[3], now)
+	}
+	if m := mapTermRegexp.FindStringSubmatch(term); m != nil {
+		return mapQuery(mapFields[strings.ToLower(m[1])], m[2])
+	}
+	if m := portTermRegexp.FindStringSubmatch(term); m != nil {
+		port, err := strconv.Atoi(m[1])
+		if err != nil || port <= 0 || port > 65535 {
+			return nil, fmt.Errorf("%s is not a port number", strconv.Quote(m[1]))
+		}
+		p, inclusive := float64(port), true
+		return bleve.NewNumericRangeInclusiveQuery(&p, &p, &inclusive, &inclusive).SetField("ExposedPorts"), nil
+	}
+
+	if m := basedOnTermRegexp.FindStringSubmatch(term); m != nil {
+		return bleve.NewTermQuery(strings.Trim(m[1], `"`)).SetField("BasedOn"), nil
+	}
+
+	q := bleve.NewQueryStringQuery(digestRegexp.ReplaceAllString(term, `:$1\:`))
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// numericRange returns the bounds of a range matching the values compared to value with operator
+func numericRange(operator string, value float64) (min, max *float64, minInclusive, maxInclusive *bool) {
+	inclusive := strings.Contains(operator, "=")
+	switch operator[0] {
+	case '>':
+		return &value, nil, &inclusive, nil
+	case '<':
+		return nil, &value, nil, &inclusive
+	}
+	return &value, &value, &inclusive, &inclusive
+}
+
+// sizeQuery matches the images whose size compares to a human readable size like 200MB
+func sizeQuery(operator, value string) (bleve.Query, error) {
+	size, err := units.FromHumanSize(value)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a size like 200MB", strconv.Quote(value))
+	}
+	return bleve.NewNumericRangeInclusiveQuery(numericRange(operator, float64(size))).SetField("Size"), nil
+}
+
+// createdQuery matches the images whose creation date compares to a date or to an age.
+// Ages like 30d (h, d, w or y) compare to the age of the image : created<30d matches the images created less than 30 days ago
+func createdQuery(operator, value string, now time.Time) (bleve.Query, error) {
+	if m := ageRegexp.FindStringSubmatch(value); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		unit := map[string]time.Duration{"h": time.Hour, "d": 24 * time.Hour, "w": 7 * 24 * time.Hour, "y": 365 * 24 * time.Hour}[m[2]]
+		date := now.Add(-time.Duration(n) * unit)
+		// Younger images were created after the date
+		switch operator {
+		case "<":
+			operator = ">"
+		case "<=":
+			operator = ">="
+		case ">":
+			operator = "<"
+		case ">=":
+			operator = "<="
+		default:
+			return nil, fmt.Errorf("ages can only be compared with <, <=, > or >=")
+		}
+		return dateQuery(operator, date, date), nil
+	}
+
+	var date time.Time
+	var err error
+	if date, err = time.Parse(time.RFC3339, value); err == nil {
+		return dateQuery(operator, date, date), nil
+	}
+	if date, err = time.Parse("2006-01-02", value); err == nil {
+		// A day covers 24 hours
+		return dateQuery(operator, date, date.Add(24*time.Hour)), nil
+	}
+	return nil, fmt.Errorf("%s is neither a date like 2016-01-31 nor an age like 30d", strconv.Quote(value))
+}
+
+// dateQuery matches the dates compared to the period from start to end with operator
+func dateQuery(operator string, start, end time.Time) bleve.Query {
+	format := func(t time.Time) *string {
+		s := t.UTC().Format(time.RFC3339)
+		return &s
+	}
+	inclusive, exclusive := true, false
+	switch operator {
+	case ">":
+		return bleve.NewDateRangeInclusiveQuery(format(end), nil, &inclusive, nil).SetField("Created")
+	case ">=":
+		return bleve.NewDateRangeInclusiveQuery(format(start), nil, &inclusive, nil).SetField("Created")
+	case "<":
+		return bleve.NewDateRangeInclusiveQuery(nil, format(start), nil, &exclusive).SetField("Created")
+	case "<=":
+		return bleve.NewDateRangeInclusiveQuery(nil, format(end), nil, &exclusive).SetField("Created")
+	}
+	if start.Equal(end) {
+		return bleve.NewDateRangeInclusiveQuery(format(start), format(end), &inclusive, &inclusive).SetField("Created")
+	}
+	return bleve.NewDateRangeInclusiveQuery(format(start), format(end), &inclusive, &exclusive).SetField("Created")
+}
+
+// mapQuery matches the images having a key (label:team), a value for a key (label:team=payments) or a value matching a pattern (label:team~pay*).
+// Values given with = are compared to the whole value. Patterns are wildcards or /regular expressions/
+func mapQuery(fields mapField, expression string) (bleve.Query, error) {
+	i := strings.IndexAny(expression, "=~")
+	if i < 0 {
+		if expression == "" {
+			return nil, fmt.Errorf("key is missing")
+		}
+		if strings.ContainsAny(expression, "*?") {
+			return bleve.NewWildcardQuery(expression).SetField(fields.keys), nil
+		}
+		return bleve.NewMatchQuery(expression).SetField(fields.keys), nil
+	}
+
+	key, operator, value := expression[:i], expression[i], expression[i+1:]
+	if key == "" {
+		return nil, fmt.Errorf("key is missing")
+	}
+	field := fields.values + key
+	switch {
+	case operator == '~' && len(value) > 1 && strings.HasPrefix(value, "/") && strings.HasSuffix(value, "/"):
+		return bleve.NewRegexpQuery(value[1 : len(value)-1]).SetField(field), nil
+	case operator == '~':
+		return bleve.NewWildcardQuery(value).SetField(field), nil
+	case len(value) > 1 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`):
+		value = value[1 : len(value)-1]
+	}
+	return bleve.NewTermQuery(value).SetField(fields.exact + key), nil
+}
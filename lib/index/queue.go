@@ -0,0 +1,459 @@
+// Copyright 2016
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package index
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/boltdb/bolt"
+	"github.com/nhurel/dim/lib"
+)
+
+var (
+	pendingBucket = []byte("pending")
+	deadBucket    = []byte("dead")
+	eventsBucket  = []byte("events")
+	// searchesBucket stores the saved searches created through the API, by name
+	searchesBucket = []byte("searches")
+)
+
+// DefaultMaxAttempts is the number of times a job is processed before being moved to the dead letters
+const DefaultMaxAttempts = 10
+
+// DefaultMaxDeadLetters is the number of dead letters kept by the queue. The oldest ones are dropped beyond it
+const DefaultMaxDeadLetters = 1000
+
+// Queue persists NotificationJobs until they are successfully applied to the index.
+// Failed jobs are retried with an exponential backoff and moved to the dead letters after MaxAttempts
+type Queue struct {
+	db *bolt.DB
+	// MaxAttempts is the number of times a job is processed before being moved to the dead letters
+	MaxAttempts int
+	// MaxDeadLetters is the number of dead letters kept. The oldest ones are dropped beyond it
+	MaxDeadLetters int
+	// RetryDelay is the delay before the first retry. It doubles on each new failure
+	RetryDelay time.Duration
+	// MaxRetryDelay caps the delay between two attempts
+	MaxRetryDelay time.Duration
+	// DedupWindow is how long a registry event ID is remembered to ignore duplicated notifications
+	DedupWindow time.Duration
+	mutex       sync.Mutex
+	inFlight    map[uint64]bool
+	wake        chan struct{}
+}
+
+// QueuedJob is a NotificationJob stored in the Queue
+type QueuedJob struct {
+	ID          uint64
+	Job         *dim.NotificationJob
+	Attempts    int
+	NextAttempt time.Time
+	LastError   string
+}
+
+// OpenQueue opens or creates the queue stored in the given file. Jobs already stored are due immediately
+func OpenQueue(path string) (*Queue, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open notification queue %s : %v", path, err)
+	}
+
+	if err = db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{pendingBucket, deadBucket, eventsBucket, searchesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Failed to initialize notification queue %s : %v", path, err)
+	}
+
+	return &Queue{
+		db:             db,
+		MaxAttempts:    DefaultMaxAttempts,
+		MaxDeadLetters: DefaultMaxDeadLetters,
+		RetryDelay:     2 * time.Second,
+		MaxRetryDelay:  10 * time.Minute,
+		DedupWindow:    24 * time.Hour,
+		inFlight:       make(map[uint64]bool),
+		wake:           make(chan struct{}, 1),
+	}, nil
+}
+
+// Close closes the underlying database
+func (q *Queue) Close() error {
+	return q.db.Close()
+}
+
+// Push stores a job in the queue. It returns false when the job was already received for the same registry event
+func (q *Queue) Push(job *dim.NotificationJob) (bool, error) {
+	added := false
+	err := q.db.Update(func(tx *bolt.Tx) error {
+		events := tx.Bucket(eventsBucket)
+		if job.EventID != "" {
+			if events.Get([]byte(job.EventID)) != nil {
+				return nil
+			}
+			if err := events.Put([]byte(job.EventID), encodeTime(time.Now())); err != nil {
+				return err
+			}
+		}
+
+		pending := tx.Bucket(pendingBucket)
+		id, err := pending.NextSequence()
+		if err != nil {
+			return err
+		}
+		added = true
+		return putJob(pending, &QueuedJob{ID: id, Job: job, NextAttempt: time.Now()})
+	})
+
+	if added {
+		q.signal()
+	}
+	return added, err
+}
+
+// signal wakes up the callers of Wait
+func (q *Queue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Due returns the pending jobs that can be processed now. Returned jobs are not returned again until they are acked or failed
+func (q *Queue) Due(now time.Time) ([]*QueuedJob, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	due := make([]*QueuedJob, 0, 10)
+	err := q.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(pendingBucket).ForEach(func(k, v []byte) error {
+			qj := &QueuedJob{}
+			if err := json.Unmarshal(v, qj); err != nil {
+				return err
+			}
+			if !q.inFlight[qj.ID] && !qj.NextAttempt.After(now) {
+				due = append(due, qj)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, qj := range due {
+		q.inFlight[qj.ID] = true
+	}
+	return due, nil
+}
+
+// Ack removes a successfully processed job from the queue
+func (q *Queue) Ack(qj *QueuedJob) error {
+	defer q.release(qj)
+	return q.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(pendingBucket).Delete(encodeID(qj.ID))
+	})
+}
+
+// Fail records a failed attempt. The job is scheduled again after a backoff delay, or moved to the dead letters once MaxAttempts is reached
+func (q *Queue) Fail(qj *QueuedJob, cause error) error {
+	defer q.release(qj)
+	qj.Attempts++
+	qj.LastError = cause.Error()
+
+	return q.db.Update(func(tx *bolt.Tx) error {
+		if qj.Attempts >= q.MaxAttempts {
+			logrus.WithField("job", qj.Job).WithError(cause).Errorln("Notification failed too many times, moving it to dead letters")
+			if err := tx.Bucket(pendingBucket).Delete(encodeID(qj.ID)); err != nil {
+				return err
+			}
+			dead := tx.Bucket(deadBucket)
+			if err := putJob(dead, qj); err != nil {
+				return err
+			}
+			return q.trimDeadLetters(dead)
+		}
+
+		qj.NextAttempt = time.Now().Add(q.backoff(qj.Attempts))
+		logrus.WithField("job", qj.Job).WithField("nextAttempt", qj.NextAttempt).WithError(cause).Warnln("Notification failed, scheduling a new attempt")
+		return putJob(tx.Bucket(pendingBucket), qj)
+	})
+}
+
+// Pending returns the number of jobs waiting to be processed
+func (q *Queue) Pending() (int, error) {
+	var n int
+	err := q.db.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket(pendingBucket).Stats().KeyN
+		return nil
+	})
+	return n, err
+}
+
+// DeadLetters returns the jobs that failed MaxAttempts times
+func (q *Queue) DeadLetters() ([]*QueuedJob, error) {
+	dead := make([]*QueuedJob, 0, 10)
+	err := q.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(deadBucket).ForEach(func(k, v []byte) error {
+			qj := &QueuedJob{}
+			if err := json.Unmarshal(v, qj); err != nil {
+				return err
+			}
+			dead = append(dead, qj)
+			return nil
+		})
+	})
+	return dead, err
+}
+
+// trimDeadLetters drops the oldest dead letters beyond MaxDeadLetters
+func (q *Queue) trimDeadLetters(dead *bolt.Bucket) error {
+	if q.MaxDeadLetters <= 0 {
+		return nil
+	}
+	keys := make([][]byte, 0, q.MaxDeadLetters+1)
+	if err := dead.ForEach(func(k, v []byte) error {
+		keys = append(keys, append([]byte(nil), k...))
+		return nil
+	}); err != nil {
+		return err
+	}
+	// Keys are job IDs, which increase with time
+	for len(keys) > q.MaxDeadLetters {
+		if err := dead.Delete(keys[0]); err != nil {
+			return err
+		}
+		keys = keys[1:]
+	}
+	return nil
+}
+
+// RetryDeadLetters moves the dead letters with the given IDs, or all of them when no ID is given, back to the pending jobs.
+// Their attempts are reset and they are due immediately. It returns the number of jobs moved
+func (q *Queue) RetryDeadLetters(ids []uint64) (int, error) {
+	n := 0
+	err := q.db.Update(func(tx *bolt.Tx) error {
+		n = 0
+		return q.forDeadLetters(tx, ids, func(dead *bolt.Bucket, qj *QueuedJob) error {
+			qj.Attempts, qj.LastError, qj.NextAttempt = 0, "", time.Time{}
+			if err := putJob(tx.Bucket(pendingBucket), qj); err != nil {
+				return err
+			}
+			n++
+			return dead.Delete(encodeID(qj.ID))
+		})
+	})
+	if err == nil && n > 0 {
+		q.signal()
+	}
+	return n, err
+}
+
+// PurgeDeadLetters removes the dead letters with the given IDs, or all of them when no ID is given.
+// It returns the number of jobs removed
+func (q *Queue) PurgeDeadLetters(ids []uint64) (int, error) {
+	n := 0
+	err := q.db.Update(func(tx *bolt.Tx) error {
+		n = 0
+		return q.forDeadLetters(tx, ids, func(dead *bolt.Bucket, qj *QueuedJob) error {
+			n++
+			return dead.Delete(encodeID(qj.ID))
+		})
+	})
+	return n, err
+}
+
+// forDeadLetters calls f on the dead letters with the given IDs, or on all of them when no ID is given
+func (q *Queue) forDeadLetters(tx *bolt.Tx, ids []uint64, f func(dead *bolt.Bucket, qj *QueuedJob) error) error {
+	dead := tx.Bucket(deadBucket)
+	if len(ids) == 0 {
+		if err := dead.ForEach(func(k, v []byte) error {
+			ids = append(ids, decodeID(k))
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+	for _, id := range ids {
+		v := dead.Get(encodeID(id))
+		if v == nil {
+			continue
+		}
+		qj := &QueuedJob{}
+		if err := json.Unmarshal(v, qj); err != nil {
+			return err
+		}
+		if err := f(dead, qj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Wait blocks until a job is pushed or the timeout expires
+func (q *Queue) Wait(timeout time.Duration) {
+	select {
+	case <-q.wake:
+	case <-time.After(timeout):
+	}
+}
+
+// PruneEvents forgets the registry event IDs received before DedupWindow
+func (q *Queue) PruneEvents(now time.Time) error {
+	limit := now.Add(-q.DedupWindow)
+	return q.db.Update(func(tx *bolt.Tx) error {
+		events := tx.Bucket(eventsBucket)
+		expired := make([][]byte, 0, 10)
+		if err := events.ForEach(func(k, v []byte) error {
+			if decodeTime(v).Before(limit) {
+				expired = append(expired, append([]byte(nil), k...))
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		for _, k := range expired {
+			if err := events.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Searches returns the saved searches stored in the queue database
+func (q *Queue) Searches() ([]*SavedSearch, error) {
+	searches := make([]*SavedSearch, 0, 10)
+	err := q.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(searchesBucket).ForEach(func(k, v []byte) error {
+			s := &SavedSearch{}
+			if err := json.Unmarshal(v, s); err != nil {
+				return err
+			}
+			searches = append(searches, s)
+			return nil
+		})
+	})
+	return searches, err
+}
+
+// StoreSearches replaces the saved searches stored in the queue database
+func (q *Queue) StoreSearches(searches []*SavedSearch) error {
+	return q.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(searchesBucket); err != nil {
+			return err
+		}
+		b, err := tx.CreateBucket(searchesBucket)
+		if err != nil {
+			return err
+		}
+		for _, s := range searches {
+			var v []byte
+			if v, err = json.Marshal(s); err != nil {
+				return err
+			}
+			if err = b.Put([]byte(s.Name), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (q *Queue) backoff(attempts int) time.Duration {
+	delay := q.RetryDelay
+	for i := 1; i < attempts && delay < q.MaxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > q.MaxRetryDelay {
+		delay = q.MaxRetryDelay
+	}
+	return delay
+}
+
+func (q *Queue) release(qj *QueuedJob) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	delete(q.inFlight, qj.ID)
+}
+
+func putJob(b *bolt.Bucket, qj *QueuedJob) error {
+	v, err := json.Marshal(qj)
+	if err != nil {
+		return err
+	}
+	return b.Put(encodeID(qj.ID), v)
+}
+
+func encodeID(id uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, id)
+	return b
+}
+
+func decodeID(b []byte) uint64 {
+	return binary.BigEndian.Uint64(b)
+}
+
+func encodeTime(t time.Time) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(t.UnixNano()))
+	return b
+}
+
+func decodeTime(b []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(b)))
+}
+
+// DeadLetters returns the notifications that failed too many times to be applied
+func (idx *Index) DeadLetters() ([]*dim.DeadLetter, error) {
+	letters := make([]*dim.DeadLetter, 0, 10)
+	if idx.queue == nil {
+		return letters, nil
+	}
+	dead, err := idx.queue.DeadLetters()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read dead letters : %v", err)
+	}
+	for _, qj := range dead {
+		letters = append(letters, &dim.DeadLetter{ID: qj.ID, Job: qj.Job, Attempts: qj.Attempts, LastError: qj.LastError})
+	}
+	return letters, nil
+}
+
+// RetryDeadLetters submits again the dead letters with the given IDs, or all of them when no ID is given
+func (idx *Index) RetryDeadLetters(ids []uint64) (int, error) {
+	if idx.queue == nil {
+		return 0, nil
+	}
+	return idx.queue.RetryDeadLetters(ids)
+}
+
+// PurgeDeadLetters removes the dead letters with the given IDs, or all of them when no ID is given
+func (idx *Index) PurgeDeadLetters(ids []uint64) (int, error) {
+	if idx.queue == nil {
+		return 0, nil
+	}
+	return idx.queue.PurgeDeadLetters(ids)
+}
@@ -0,0 +1,225 @@
+// Copyright 2016
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package index
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/nhurel/dim/lib"
+)
+
+func tempQueue(t *testing.T) (*Queue, string) {
+	dir, err := ioutil.TempDir("", "dim")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir : %v", err)
+	}
+	q, err := OpenQueue(path.Join(dir, "queue"))
+	if err != nil {
+		t.Fatalf("OpenQueue returned an error : %v", err)
+	}
+	return q, dir
+}
+
+func TestQueuePushDeduplicates(t *testing.T) {
+	q, dir := tempQueue(t)
+	defer os.RemoveAll(dir)
+	defer q.Close()
+
+	scenarii := []struct {
+		job      *dim.NotificationJob
+		expected bool
+	}{
+		{&dim.NotificationJob{EventID: "event1", Action: dim.PushAction}, true},
+		{&dim.NotificationJob{EventID: "event1", Action: dim.PushAction}, false},
+		{&dim.NotificationJob{EventID: "event2", Action: dim.DeleteAction}, true},
+		{&dim.NotificationJob{Action: dim.PushAction}, true},
+		{&dim.NotificationJob{Action: dim.PushAction}, true},
+	}
+
+	for _, scenario := range scenarii {
+		got, err := q.Push(scenario.job)
+		if err != nil {
+			t.Fatalf("Push(%v) returned an error : %v", scenario.job, err)
+		}
+		if got != scenario.expected {
+			t.Errorf("Push(%v) returned %v instead of %v", scenario.job, got, scenario.expected)
+		}
+	}
+
+	if n, _ := q.Pending(); n != 4 {
+		t.Errorf("Queue should have 4 pending jobs but has %d", n)
+	}
+
+	if err := q.PruneEvents(time.Now().Add(q.DedupWindow + time.Minute)); err != nil {
+		t.Fatalf("PruneEvents returned an error : %v", err)
+	}
+	if added, _ := q.Push(&dim.NotificationJob{EventID: "event1"}); !added {
+		t.Errorf("Push should accept an event ID pruned from the dedup window")
+	}
+}
+
+func TestQueueRetry(t *testing.T) {
+	q, dir := tempQueue(t)
+	defer os.RemoveAll(dir)
+	defer q.Close()
+	q.MaxAttempts = 2
+	q.RetryDelay = time.Minute
+
+	q.Push(&dim.NotificationJob{Action: dim.PushAction, Repository: "failing"})
+	q.Push(&dim.NotificationJob{Action: dim.PushAction, Repository: "working"})
+
+	due, err := q.Due(time.Now())
+	if err != nil || len(due) != 2 {
+		t.Fatalf("Due returned %v, %v instead of 2 jobs", due, err)
+	}
+	if again, _ := q.Due(time.Now()); len(again) != 0 {
+		t.Errorf("Due should not return in flight jobs but returned %v", again)
+	}
+
+	q.Fail(due[0], fmt.Errorf("registry unavailable"))
+	q.Ack(due[1])
+
+	if due, _ = q.Due(time.Now()); len(due) != 0 {
+		t.Errorf("Failed job should be delayed but Due returned %v", due)
+	}
+	if due, _ = q.Due(time.Now().Add(2 * time.Minute)); len(due) != 1 || due[0].Attempts != 1 || due[0].LastError != "registry unavailable" {
+		t.Fatalf("Failed job should be due after the retry delay but Due returned %v", due)
+	}
+
+	q.Fail(due[0], fmt.Errorf("registry still unavailable"))
+	if n, _ := q.Pending(); n != 0 {
+		t.Errorf("Queue should have no more pending jobs but has %d", n)
+	}
+	dead, err := q.DeadLetters()
+	if err != nil || len(dead) != 1 || dead[0].Job.Repository != "failing" {
+		t.Errorf("DeadLetters returned %v, %v instead of the failing job", dead, err)
+	}
+}
+
+func TestQueueDeadLetters(t *testing.T) {
+	q, dir := tempQueue(t)
+	defer os.RemoveAll(dir)
+	defer q.Close()
+	q.MaxAttempts = 1
+	q.MaxDeadLetters = 2
+
+	for _, repo := range []string{"first", "second", "third"} {
+		q.Push(&dim.NotificationJob{Action: dim.PushAction, Repository: repo})
+	}
+	due, _ := q.Due(time.Now())
+	for _, qj := range due {
+		q.Fail(qj, fmt.Errorf("registry unavailable"))
+	}
+
+	dead, err := q.DeadLetters()
+	if err != nil || len(dead) != 2 || dead[0].Job.Repository != "second" || dead[1].Job.Repository != "third" {
+		t.Fatalf("DeadLetters should only keep the 2 latest jobs but returned %v, %v", dead, err)
+	}
+
+	if n, err := q.RetryDeadLetters([]uint64{dead[0].ID, 42}); err != nil || n != 1 {
+		t.Errorf("RetryDeadLetters returned %d, %v instead of 1", n, err)
+	}
+	due, _ = q.Due(time.Now())
+	if len(due) != 1 || due[0].Job.Repository != "second" || due[0].Attempts != 0 || due[0].LastError != "" {
+		t.Errorf("Retried job should be due again with its attempts reset but Due returned %v", due)
+	}
+
+	if n, err := q.PurgeDeadLetters(nil); err != nil || n != 1 {
+		t.Errorf("PurgeDeadLetters returned %d, %v instead of 1", n, err)
+	}
+	if dead, _ = q.DeadLetters(); len(dead) != 0 {
+		t.Errorf("All dead letters should be purged but DeadLetters returned %v", dead)
+	}
+}
+
+func TestQueueReplay(t *testing.T) {
+	q, dir := tempQueue(t)
+	defer os.RemoveAll(dir)
+	q.Push(&dim.NotificationJob{Action: dim.PushAction, Repository: "pending"})
+	q.Due(time.Now())
+	q.Close()
+
+	q, err := OpenQueue(path.Join(dir, "queue"))
+	if err != nil {
+		t.Fatalf("OpenQueue returned an error : %v", err)
+	}
+	defer q.Close()
+
+	due, err := q.Due(time.Now())
+	if err != nil || len(due) != 1 || due[0].Job.Repository != "pending" {
+		t.Errorf("Pending job should be replayed after reopening the queue but Due returned %v, %v", due, err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	q := &Queue{RetryDelay: time.Second, MaxRetryDelay: 5 * time.Second}
+	scenarii := []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 5 * time.Second},
+		{20, 5 * time.Second},
+	}
+	for _, scenario := range scenarii {
+		if got := q.backoff(scenario.attempts); got != scenario.expected {
+			t.Errorf("backoff(%d) returned %v instead of %v", scenario.attempts, got, scenario.expected)
+		}
+	}
+}
+
+func TestCloseStopsWorkers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dim")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir : %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	idx, err := New(&Config{Backend: MemoryBackend, QueuePath: path.Join(dir, "queue")}, nil)
+	if err != nil {
+		t.Fatalf("New returned an error : %v", err)
+	}
+
+	closed := make(chan error)
+	go func() { closed <- idx.Close() }()
+	select {
+	case err = <-closed:
+		if err != nil {
+			t.Errorf("Close returned an error : %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Close did not return once the notification workers were stopped")
+	}
+
+	// Notifications received once the index is closed are not waiting for a worker
+	submitted := make(chan bool)
+	go func() {
+		idx.notifications = make(chan *QueuedJob)
+		idx.queue = nil
+		idx.Submit(&dim.NotificationJob{Action: dim.PushAction, Repository: "late"})
+		close(submitted)
+	}()
+	select {
+	case <-submitted:
+	case <-time.After(5 * time.Second):
+		t.Errorf("Submit blocked after Close")
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright 2016
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package index
+
+import (
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/nhurel/dim/lib"
+)
+
+// resync reconciles the index with the registry every interval until the index is closed
+func (idx *Index) resync(interval time.Duration) {
+	logrus.WithField("interval", interval).Infoln("Starting periodic resync with registry")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			logrus.Debugln("Resyncing index with registry")
+			idx.reconcile()
+		case <-idx.stop:
+			return
+		}
+	}
+}
+
+// Drift returns the report of the last reconciliation with the registry, or nil if none ran yet
+func (idx *Index) Drift() *dim.DriftReport {
+	idx.driftMu.RLock()
+	defer idx.driftMu.RUnlock()
+	return idx.drift
+}
+
+func (idx *Index) setDrift(report *dim.DriftReport) {
+	idx.driftMu.Lock()
+	defer idx.driftMu.Unlock()
+	idx.drift = report
+}
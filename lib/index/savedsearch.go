@@ -0,0 +1,245 @@
+// Copyright 2016
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package index
+
+import (
+	"fmt"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/nhurel/dim/lib"
+)
+
+// maxMatches is the number of recent matches kept for each saved search
+const maxMatches = 20
+
+// SavedSearch is a named advanced search. Its action is evaluated each time a pushed image matches the query
+type SavedSearch struct {
+	Name   string
+	Query  string
+	Action string
+	hook   *Hook
+}
+
+// ParseSearches checks the query of all saved searches and parses their actions like hooks
+func (c *Config) ParseSearches() error {
+	names := make(map[string]bool, len(c.Searches))
+	for _, s := range c.Searches {
+		if names[s.Name] {
+			return fmt.Errorf("Saved search %s is defined twice", s.Name)
+		}
+		names[s.Name] = true
+		if err := c.parseSearch(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseSearch checks the query of a saved search and parses its action with the functions available in hooks
+func (c *Config) parseSearch(s *SavedSearch) error {
+	if s.Name == "" {
+		return &dim.QueryError{Message: "Saved search has no name"}
+	}
+	if _, err := ParseQuery(s.Query, time.Now()); err != nil {
+		return &dim.QueryError{Message: fmt.Sprintf("Invalid query for saved search %s : %v", s.Name, err)}
+	}
+	if s.Action == "" {
+		s.hook = nil
+		return nil
+	}
+	tpl, err := template.New("search_" + s.Name).Funcs(c.funcMap).Parse(s.Action)
+	if err != nil {
+		return &dim.QueryError{Message: fmt.Sprintf("Failed to parse action of saved search %s : %v", s.Name, err)}
+	}
+	s.hook = &Hook{Event: dim.PushAction, Action: s.Action, eval: tpl}
+	return nil
+}
+
+// savedSearches holds the saved searches of an index and their recent matches
+type savedSearches struct {
+	sync.RWMutex
+	searches []*savedSearch
+}
+
+type savedSearch struct {
+	*SavedSearch
+	configured bool
+	matches    []dim.SavedSearchMatch
+}
+
+// find returns the position of the saved search having the given name, or -1
+func (ss *savedSearches) find(name string) int {
+	for i, s := range ss.searches {
+		if s.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// loadSearches registers the searches of the configuration and the ones created through the API stored with the notification queue.
+// Without notification queue, searches created through the API are kept in memory only
+func (idx *Index) loadSearches() error {
+	for _, s := range idx.Config.Searches {
+		idx.searches.searches = append(idx.searches.searches, &savedSearch{SavedSearch: s, configured: true})
+	}
+	if idx.queue == nil {
+		return nil
+	}
+
+	stored, err := idx.queue.Searches()
+	if err != nil {
+		return fmt.Errorf("Failed to read saved searches : %v", err)
+	}
+	for _, s := range stored {
+		if idx.searches.find(s.Name) >= 0 {
+			logrus.WithField("search", s.Name).Warnln("Saved search ignored because the configuration defines one with the same name")
+			continue
+		}
+		// Older versions accepted actions on the searches created through the API
+		if s.Action != "" {
+			logrus.WithField("search", s.Name).Warnln("Ignoring the action of a saved search created through the API")
+			s.Action = ""
+		}
+		if err = idx.Config.parseSearch(s); err != nil {
+			logrus.WithError(err).WithField("search", s.Name).Errorln("Saved search ignored")
+			continue
+		}
+		idx.searches.searches = append(idx.searches.searches, &savedSearch{SavedSearch: s})
+	}
+	return nil
+}
+
+// storeSearches saves the searches created through the API with the notification queue, so that they survive index rebuilds.
+// The caller must hold the lock
+func (idx *Index) storeSearches() error {
+	if idx.queue == nil {
+		return nil
+	}
+	stored := make([]*SavedSearch, 0, len(idx.searches.searches))
+	for _, s := range idx.searches.searches {
+		if !s.configured {
+			stored = append(stored, s.SavedSearch)
+		}
+	}
+	if err := idx.queue.StoreSearches(stored); err != nil {
+		return fmt.Errorf("Failed to save searches : %v", err)
+	}
+	return nil
+}
+
+// SavedSearches returns all the saved searches with their recent matches
+func (idx *Index) SavedSearches() []*dim.SavedSearch {
+	idx.searches.RLock()
+	defer idx.searches.RUnlock()
+	searches := make([]*dim.SavedSearch, len(idx.searches.searches))
+	for i, s := range idx.searches.searches {
+		searches[i] = &dim.SavedSearch{Name: s.Name, Query: s.Query, Action: s.Action, Configured: s.configured, Matches: append([]dim.SavedSearchMatch(nil), s.matches...)}
+	}
+	return searches
+}
+
+// SaveSearch creates or replaces a saved search. Searches defined in the configuration cannot be replaced.
+// As actions can call any hook function, only searches of the configuration have one : saved searches only record their matches
+func (idx *Index) SaveSearch(search *dim.SavedSearch) error {
+	if search.Action != "" {
+		return &dim.QueryError{Message: fmt.Sprintf("Saved search %s cannot have an action. Actions can only be set on the searches of the configuration", search.Name)}
+	}
+	s := &SavedSearch{Name: search.Name, Query: search.Query}
+	if err := idx.Config.parseSearch(s); err != nil {
+		return err
+	}
+
+	idx.searches.Lock()
+	defer idx.searches.Unlock()
+	previous := idx.searches.searches
+	if i := idx.searches.find(s.Name); i < 0 {
+		idx.searches.searches = append(idx.searches.searches, &savedSearch{SavedSearch: s})
+	} else if previous[i].configured {
+		return &dim.QueryError{Message: fmt.Sprintf("Saved search %s is defined in the configuration and cannot be changed", s.Name)}
+	} else {
+		idx.searches.searches = append([]*savedSearch(nil), previous...)
+		idx.searches.searches[i] = &savedSearch{SavedSearch: s}
+	}
+	if err := idx.storeSearches(); err != nil {
+		idx.searches.searches = previous
+		return err
+	}
+	return nil
+}
+
+// DeleteSearch removes a saved search created through the API
+func (idx *Index) DeleteSearch(name string) error {
+	idx.searches.Lock()
+	defer idx.searches.Unlock()
+	i := idx.searches.find(name)
+	if i < 0 {
+		return &dim.QueryError{Message: fmt.Sprintf("No saved search named %s", name)}
+	}
+	if idx.searches.searches[i].configured {
+		return &dim.QueryError{Message: fmt.Sprintf("Saved search %s is defined in the configuration and cannot be deleted", name)}
+	}
+	previous := idx.searches.searches
+	idx.searches.searches = append(append([]*savedSearch(nil), previous[:i]...), previous[i+1:]...)
+	if err := idx.storeSearches(); err != nil {
+		idx.searches.searches = previous
+		return err
+	}
+	return nil
+}
+
+// matchSearches records the images matching each saved search and triggers the action of the search for them.
+// The images must already be indexed
+func (idx *Index) matchSearches(images []*dim.IndexImage) {
+	idx.searches.RLock()
+	searches := idx.searches.searches
+	idx.searches.RUnlock()
+	if len(searches) == 0 || len(images) == 0 {
+		return
+	}
+
+	ids := make([]string, len(images))
+	byID := make(map[string]*dim.IndexImage, len(images))
+	for i, img := range images {
+		ids[i] = documentID(img)
+		byID[ids[i]] = img
+	}
+
+	now := time.Now()
+	for _, s := range searches {
+		l := logrus.WithField("search", s.Name)
+		// Registry and ID are needed to identify the matching documents
+		results, err := idx.Backend.Search(&SearchRequest{Advanced: s.Query, IDs: ids, Fields: []string{"Registry", "ID"}, Size: len(ids)})
+		if err != nil {
+			l.WithError(err).Errorln("Failed to evaluate saved search")
+			continue
+		}
+		for _, match := range results.Images {
+			img := byID[documentID(match)]
+			l.WithField("image", img.FullName).Infoln("Image matches saved search")
+			idx.searches.Lock()
+			s.matches = append([]dim.SavedSearchMatch{{FullName: img.FullName, ID: img.ID, Time: now}}, s.matches...)
+			if len(s.matches) > maxMatches {
+				s.matches = s.matches[:maxMatches]
+			}
+			idx.searches.Unlock()
+			if s.hook != nil {
+				triggerHooks([]*Hook{s.hook}, img)
+			}
+		}
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2016
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package index
+
+import (
+	"path"
+	"time"
+
+	"github.com/nhurel/dim/lib"
+	. "gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestSavedSearches(c *C) {
+	matched := make(chan string, len(images))
+	cfg := &Config{}
+	cfg.RegisterFunction("matched", func(name string) bool {
+		matched <- name
+		return true
+	})
+	cfg.Searches = []*SavedSearch{{Name: "debian", Query: "label:family=debian"}, {Name: "httpd", Query: "env:HTTPD_VERSION~2.4*", Action: "{{ matched .Name }}"}}
+	c.Assert(cfg.ParseSearches(), IsNil)
+	queue, err := OpenQueue(path.Join(c.MkDir(), "queue"))
+	c.Assert(err, IsNil)
+	defer queue.Close()
+	idx := &Index{Backend: s.index.Backend, Config: cfg, queue: queue}
+	c.Assert(idx.loadSearches(), IsNil)
+
+	c.Assert(idx.SaveSearch(&dim.SavedSearch{Name: "broken", Query: "(Name:httpd"}), FitsTypeOf, &dim.QueryError{})
+	c.Assert(idx.SaveSearch(&dim.SavedSearch{Name: "debian", Query: "Name:httpd"}), FitsTypeOf, &dim.QueryError{})
+	// Searches created through the API only record their matches
+	c.Assert(idx.SaveSearch(&dim.SavedSearch{Name: "mysql", Query: "Name:mysql", Action: "{{ matched .Name }}"}), FitsTypeOf, &dim.QueryError{})
+	c.Assert(idx.SaveSearch(&dim.SavedSearch{Name: "mysql", Query: "Name:mysql"}), IsNil)
+	defer idx.DeleteSearch("mysql")
+
+	pushed := make([]*dim.IndexImage, len(images))
+	for i := range images {
+		pushed[i] = &images[i]
+	}
+	idx.matchSearches(pushed)
+
+	select {
+	case name := <-matched:
+		c.Assert(name, Equals, "httpd")
+	case <-time.After(time.Second):
+		c.Fatal("Action of the saved search was not triggered")
+	}
+
+	searches := idx.SavedSearches()
+	c.Assert(searches, HasLen, 3)
+	c.Assert(searches[0].Name, Equals, "debian")
+	c.Assert(searches[0].Configured, Equals, true)
+	c.Assert(searches[0].Matches, HasLen, 2)
+	c.Assert(searches[1].Name, Equals, "httpd")
+	c.Assert(searches[1].Matches, HasLen, 1)
+	c.Assert(searches[1].Matches[0].ID, Equals, "234567")
+	c.Assert(searches[2].Name, Equals, "mysql")
+	c.Assert(searches[2].Configured, Equals, false)
+	c.Assert(searches[2].Matches, HasLen, 1)
+	c.Assert(searches[2].Matches[0].ID, Equals, "354678")
+
+	// Searches created through the API are stored with the notification queue
+	reopened := &Index{Config: &Config{funcMap: cfg.funcMap}, queue: queue}
+	c.Assert(reopened.loadSearches(), IsNil)
+	c.Assert(reopened.SavedSearches(), HasLen, 1)
+	c.Assert(reopened.SavedSearches()[0].Query, Equals, "Name:mysql")
+
+	c.Assert(idx.DeleteSearch("debian"), FitsTypeOf, &dim.QueryError{})
+	c.Assert(idx.DeleteSearch("mysql"), IsNil)
+	c.Assert(idx.DeleteSearch("mysql"), FitsTypeOf, &dim.QueryError{})
+	c.Assert(idx.SavedSearches(), HasLen, 2)
+}
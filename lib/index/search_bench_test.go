@@ -0,0 +1,70 @@
+// Copyright 2016
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package index
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/nhurel/dim/lib"
+	"github.com/nhurel/dim/lib/index/indextest"
+)
+
+// BenchmarkSearchImages measures the latency of result pages of growing sizes, with and without image details
+func BenchmarkSearchImages(b *testing.B) {
+	i, err := indextest.MockIndex(ImageMapping)
+	if err != nil {
+		b.Fatalf("Failed to create index : %v", err)
+	}
+	defer i.Close()
+	idx := &Index{Backend: NewBleveBackend(i), Config: &Config{}}
+
+	batch := i.NewBatch()
+	for n := 0; n < 1000; n++ {
+		image := &dim.IndexImage{
+			ID:         fmt.Sprintf("sha256:%d", n),
+			Name:       fmt.Sprintf("team%d/app%d", n%10, n),
+			Tags:       []string{"latest", fmt.Sprintf("1.%d", n)},
+			Label:      map[string]string{"family": "debian", "team": fmt.Sprintf("team%d", n%10), "version": fmt.Sprintf("1.%d", n)},
+			Env:        map[string]string{"PATH": "/usr/local/bin:/usr/bin:/bin", "APP_VERSION": fmt.Sprintf("1.%d", n)},
+			Layers:     []string{"sha256:debian", fmt.Sprintf("sha256:app%d", n)},
+			LayerSizes: []int64{1024, int64(n)},
+			History:    []string{"/bin/sh -c #(nop) ADD file:debian in /", "/bin/sh -c make install"},
+		}
+		image.Labels = []string{"family", "team", "version"}
+		image.Envs = []string{"PATH", "APP_VERSION"}
+		if err = batch.Index(documentID(image), newImageDocument(image)); err != nil {
+			b.Fatalf("Failed to index image : %v", err)
+		}
+	}
+	if err = i.Batch(batch); err != nil {
+		b.Fatalf("Failed to index images : %v", err)
+	}
+
+	for _, size := range []int{10, 100, 1000} {
+		for _, fields := range [][]string{nil, {"Labels", "Envs", "Created"}} {
+			b.Run(fmt.Sprintf("page=%d/fields=%d", size, len(fields)), func(b *testing.B) {
+				for n := 0; n < b.N; n++ {
+					sr, err := idx.SearchImages("", "Label.family:debian", "", fields, nil, nil, 0, size)
+					if err != nil {
+						b.Fatalf("Search failed : %v", err)
+					}
+					if len(sr.Images) != size {
+						b.Fatalf("Search returned %d images instead of %d", len(sr.Images), size)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2016
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package index
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nhurel/dim/lib"
+)
+
+// sortFields maps the sort keys accepted in search requests to the indexed fields they sort on
+var sortFields = map[string][]string{
+	"Name":     {"Repository"},
+	"Tag":      {"Tag"},
+	"FullName": {"Repository", "Tag"},
+	"Created":  {"Created"},
+	"Size":     {"Size"},
+	"Score":    {"_score"},
+	"Registry": {"Registry", "Repository", "Tag"},
+}
+
+// NewSortOrder converts sort keys like -Created or Name into a bleve sort order.
+// Without keys, results are sorted by score.
+// The document ID is always appended as last key so that results are stable across pages
+func NewSortOrder(keys []string) ([]string, error) {
+	if len(keys) == 0 {
+		return []string{"-_score", "_id"}, nil
+	}
+	order := make([]string, 0, 2*len(keys)+1)
+	for _, key := range keys {
+		prefix := ""
+		if strings.HasPrefix(key, "-") || strings.HasPrefix(key, "+") {
+			prefix, key = key[:1], key[1:]
+		}
+		fields, ok := sortFields[key]
+		if !ok {
+			return nil, &dim.QueryError{Message: fmt.Sprintf("Cannot sort on field %s", key)}
+		}
+		if prefix == "+" {
+			prefix = ""
+		}
+		for _, field := range fields {
+			order = append(order, prefix+field)
+		}
+	}
+	if last := order[len(order)-1]; last != "_id" && last != "-_id" {
+		order = append(order, "_id")
+	}
+	return order, nil
+}
@@ -0,0 +1,151 @@
+// Copyright 2016
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package index
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/nhurel/dim/lib"
+)
+
+// maxStatusErrors is the number of crawl errors kept in the index status
+const maxStatusErrors = 20
+
+// progress tracks the crawl of the registry by a build or a reconciliation
+type progress struct {
+	sync.Mutex
+	state               string
+	repositories        int
+	repositoriesCrawled int
+	tags                int
+	tagsCrawled         int
+	errors              []string
+}
+
+// start resets the counters for a new crawl of the registry
+func (p *progress) start(state string) {
+	p.Lock()
+	defer p.Unlock()
+	p.state = state
+	p.repositories, p.repositoriesCrawled, p.tags, p.tagsCrawled = 0, 0, 0, 0
+	p.errors = nil
+}
+
+// done marks the end of the crawl
+func (p *progress) done() {
+	p.Lock()
+	defer p.Unlock()
+	p.state = dim.IndexReady
+}
+
+func (p *progress) addRepository() {
+	p.Lock()
+	defer p.Unlock()
+	p.repositories++
+}
+
+func (p *progress) repositoryCrawled() {
+	p.Lock()
+	defer p.Unlock()
+	p.repositoriesCrawled++
+}
+
+func (p *progress) addTags(n int) {
+	p.Lock()
+	defer p.Unlock()
+	p.tags += n
+}
+
+func (p *progress) tagCrawled() {
+	p.Lock()
+	defer p.Unlock()
+	p.tagsCrawled++
+}
+
+// fail records an error met during the crawl. Only the last maxStatusErrors are kept
+func (p *progress) fail(err error) {
+	p.Lock()
+	defer p.Unlock()
+	p.errors = append(p.errors, err.Error())
+	if len(p.errors) > maxStatusErrors {
+		p.errors = p.errors[len(p.errors)-maxStatusErrors:]
+	}
+}
+
+// status returns the crawl progress as an IndexStatus
+func (p *progress) status() *dim.IndexStatus {
+	p.Lock()
+	defer p.Unlock()
+	status := &dim.IndexStatus{
+		State:               p.state,
+		Repositories:        p.repositories,
+		RepositoriesCrawled: p.repositoriesCrawled,
+		Tags:                p.tags,
+		TagsCrawled:         p.tagsCrawled,
+		Errors:              append([]string(nil), p.errors...),
+	}
+	if status.State == "" {
+		status.State = dim.IndexReady
+	}
+	return status
+}
+
+// Status returns the state of the index and the progress of the current or last crawl of the registry
+func (idx *Index) Status() *dim.IndexStatus {
+	status := idx.progress.status()
+
+	var err error
+	if status.Documents, err = idx.Backend.Count(); err != nil {
+		logrus.WithError(err).Errorln("Failed to count indexed images")
+	}
+
+	// Queued jobs stay pending until they are applied, including the ones already sent to the workers
+	if idx.queue != nil {
+		if status.PendingJobs, err = idx.queue.Pending(); err != nil {
+			logrus.WithError(err).Errorln("Failed to count pending notifications")
+		}
+	} else {
+		status.PendingJobs = len(idx.notifications)
+	}
+
+	if drift := idx.Drift(); drift != nil {
+		end := drift.Started.Add(drift.Duration)
+		status.LastResync = &end
+	}
+
+	if idx.Config != nil && idx.Config.Directory != "" && idx.Config.Backend != MemoryBackend {
+		if status.DiskSize, err = diskSize(idx.Config.Directory); err != nil {
+			logrus.WithError(err).Errorln("Failed to compute index size")
+		}
+	}
+	return status
+}
+
+// diskSize returns the total size of the files in a directory
+func diskSize(directory string) (int64, error) {
+	var size int64
+	err := filepath.Walk(directory, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
@@ -0,0 +1,57 @@
+// Copyright 2016
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package index
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/nhurel/dim/lib"
+)
+
+// unsuggestableFields are the fields whose terms are encoded numbers or dates
+var unsuggestableFields = map[string]bool{"Created": true, "Size": true, "ExposedPorts": true, "LayerSizes": true}
+
+// defaultSuggestions is the number of terms suggested when no size is given
+const defaultSuggestions = 10
+
+// Suggest returns the most frequent terms of a field starting with prefix, with the number of images having each of them.
+// Name suggests whole repository names and Tags whole tags
+func (idx *Index) Suggest(field, prefix string, size int) ([]dim.FacetTerm, error) {
+	if field == "" {
+		return nil, &dim.QueryError{Message: "No field given for suggestions"}
+	}
+	if unsuggestableFields[field] {
+		return nil, &dim.QueryError{Message: fmt.Sprintf("Cannot suggest values of field %s", field)}
+	}
+	if size <= 0 {
+		size = defaultSuggestions
+	}
+
+	terms, err := idx.Backend.Terms(field, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read terms of field %s : %v", field, err)
+	}
+
+	sort.Slice(terms, func(i, j int) bool {
+		if terms[i].Count != terms[j].Count {
+			return terms[i].Count > terms[j].Count
+		}
+		return terms[i].Term < terms[j].Term
+	})
+	if len(terms) > size {
+		terms = terms[:size]
+	}
+	return terms, nil
+}
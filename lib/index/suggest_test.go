@@ -0,0 +1,52 @@
+// Copyright 2016
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package index
+
+import (
+	"github.com/nhurel/dim/lib"
+	. "gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestSuggest(c *C) {
+	var tests = []struct {
+		field    string
+		prefix   string
+		size     int
+		expected []dim.FacetTerm
+	}{
+		{"Name", "", 0, []dim.FacetTerm{{Term: "centos", Count: 1}, {Term: "httpd", Count: 1}, {Term: "mysql", Count: 1}}},
+		{"Name", "my", 0, []dim.FacetTerm{{Term: "mysql", Count: 1}}},
+		{"Tags", "cen", 0, []dim.FacetTerm{{Term: "centos6", Count: 1}}},
+		{"Labels", "", 2, []dim.FacetTerm{{Term: "family", Count: 3}, {Term: "type", Count: 3}}},
+		{"Labels", "fr", 0, []dim.FacetTerm{{Term: "framework", Count: 2}}},
+		{"Envs", "HTTPD_V", 0, []dim.FacetTerm{{Term: "HTTPD_VERSION", Count: 1}}},
+		{"Labels", "unknown", 0, []dim.FacetTerm{}},
+		// Whole values are suggested, not the words the analyzers split them into
+		{"Envs", "", 0, []dim.FacetTerm{{Term: "PATH", Count: 2}, {Term: "HTTPD_BZ2_URL", Count: 1}, {Term: "HTTPD_PREFIX", Count: 1}, {Term: "HTTPD_VERSION", Count: 1}, {Term: "MYSQL_MAJOR", Count: 1}, {Term: "MYSQL_VERSION", Count: 1}}},
+		{"Label.framework", "apa", 0, []dim.FacetTerm{{Term: "apache-httpd", Count: 1}}},
+		{"Author", "", 0, []dim.FacetTerm{{Term: "John Doe <john.doe@example.com>", Count: 1}}},
+	}
+
+	for _, t := range tests {
+		c.Logf("Test with field %s and prefix %s", t.field, t.prefix)
+		terms, err := s.index.Suggest(t.field, t.prefix, t.size)
+		c.Assert(err, IsNil)
+		c.Assert(terms, DeepEquals, t.expected)
+	}
+
+	for _, field := range []string{"", "Size", "Created"} {
+		_, err := s.index.Suggest(field, "", 0)
+		c.Assert(err, FitsTypeOf, &dim.QueryError{})
+	}
+}
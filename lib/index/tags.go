@@ -0,0 +1,175 @@
+// Copyright 2016
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package index
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/nhurel/dim/lib"
+	"github.com/nhurel/dim/lib/utils"
+)
+
+// documentID returns the ID of the document storing an image.
+// A document holds a manifest of a repository, whatever the number of tags pointing to it
+func documentID(image *dim.IndexImage) string {
+	return fmt.Sprintf("%s@%s", qualifiedName(image.Registry, image.Name), image.ID)
+}
+
+// qualifiedName prefixes a repository or image name with the name of its registry, if any
+func qualifiedName(registry, name string) string {
+	if registry == "" {
+		return name
+	}
+	return registry + "/" + name
+}
+
+// changeSet gathers the documents updated by tag moves so that they are written to the index in a single batch.
+// Documents losing their last tag are deleted. Index.changes must be held from the first read of a change set to its commit,
+// so that concurrent change sets do not overwrite each other
+type changeSet struct {
+	idx  *Index
+	docs map[string]*dim.IndexImage
+}
+
+func (idx *Index) newChangeSet() *changeSet {
+	return &changeSet{idx: idx, docs: make(map[string]*dim.IndexImage)}
+}
+
+// doc returns the document with the given ID as modified by the change set, or nil if it does not exist
+func (cs *changeSet) doc(id string) (*dim.IndexImage, error) {
+	if doc, ok := cs.docs[id]; ok {
+		return doc, nil
+	}
+	doc, err := cs.idx.Backend.Get(id)
+	if err != nil || doc == nil {
+		return nil, err
+	}
+	cs.docs[id] = doc
+	return doc, nil
+}
+
+// untag removes a tag from the documents of the repository of a registry holding it.
+// It returns the documents as they were before losing the tag, with Tag set to the removed tag
+func (cs *changeSet) untag(registry, repository, tag string) ([]*dim.IndexImage, error) {
+	filter := Filter{"Name": repository, "Tags": tag}
+	if registry != "" {
+		filter["Registry"] = registry
+	}
+	indexed, err := cs.idx.Backend.Find(filter)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to find indexed images of %s:%s : %v", qualifiedName(registry, repository), tag, err)
+	}
+
+	ids := make([]string, 0, len(indexed))
+	for _, image := range indexed {
+		ids = append(ids, documentID(image))
+	}
+	for id, doc := range cs.docs {
+		if doc.Registry == registry && doc.Name == repository && utils.ListContains(doc.Tags, tag) {
+			ids = append(ids, id)
+		}
+	}
+
+	untagged := make([]*dim.IndexImage, 0, len(ids))
+	for _, id := range ids {
+		var doc *dim.IndexImage
+		if doc, err = cs.doc(id); err != nil {
+			return nil, fmt.Errorf("Failed to read indexed image %s : %v", id, err)
+		}
+		if doc == nil || doc.Registry != registry || !utils.ListContains(doc.Tags, tag) {
+			continue
+		}
+		previous := *doc
+		previous.Tag = tag
+		normalizeTags(&previous)
+		untagged = append(untagged, &previous)
+		doc.Tags = removeTag(doc.Tags, tag)
+	}
+	return untagged, nil
+}
+
+// add puts images in the change set. An image already indexed keeps its other tags
+func (cs *changeSet) add(images []*dim.IndexImage) error {
+	for _, image := range images {
+		id := documentID(image)
+		previous, err := cs.doc(id)
+		if err != nil {
+			return fmt.Errorf("Failed to read indexed image %s : %v", id, err)
+		}
+		if previous != nil {
+			image.Tags = mergeTags(previous.Tags, image.Tags)
+		}
+		cs.docs[id] = image
+	}
+	return nil
+}
+
+// commit writes all the documents of the change set to the index, along with the images whose lineage changed
+func (cs *changeSet) commit() error {
+	if err := cs.link(); err != nil {
+		return err
+	}
+	docs := make(map[string]*dim.IndexImage, len(cs.docs))
+	var deleted []string
+	for id, doc := range cs.docs {
+		if len(doc.Tags) == 0 {
+			deleted = append(deleted, id)
+			continue
+		}
+		normalizeTags(doc)
+		docs[id] = doc
+	}
+	return cs.idx.Backend.Write(docs, deleted)
+}
+
+// normalizeTags sorts the tags of an image. Tag and FullName are set from the first tag unless Tag is one of the image tags
+func normalizeTags(image *dim.IndexImage) {
+	if len(image.Tags) == 0 && image.Tag != "" {
+		image.Tags = []string{image.Tag}
+	}
+	image.Tags = mergeTags(nil, image.Tags)
+	if len(image.Tags) == 0 {
+		return
+	}
+	if !utils.ListContains(image.Tags, image.Tag) {
+		image.Tag = image.Tags[0]
+	}
+	image.FullName = fmt.Sprintf("%s:%s", image.Name, image.Tag)
+}
+
+// mergeTags returns the sorted union of two tag lists
+func mergeTags(a, b []string) []string {
+	merged := make([]string, 0, len(a)+len(b))
+	for _, tags := range [][]string{a, b} {
+		for _, tag := range tags {
+			if !utils.ListContains(merged, tag) {
+				merged = append(merged, tag)
+			}
+		}
+	}
+	sort.Strings(merged)
+	return merged
+}
+
+// removeTag returns the tags without the given one
+func removeTag(tags []string, tag string) []string {
+	result := make([]string, 0, len(tags))
+	for _, t := range tags {
+		if t != tag {
+			result = append(result, t)
+		}
+	}
+	return result
+}
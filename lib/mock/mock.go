@@ -34,6 +34,7 @@ type NoOpRegistryClient struct {
 	RepositoriesFn    func(repos []string, last string) (int, error)
 	WalkRepoitoriesFn func() <-chan dim.Repository
 	NewRepositoryFn   func(parsedName reference.Named) (dim.Repository, error)
+	SearchFn          func(query, advanced, platform string, facets, sort []string, offset, numResults int) (*dim.SearchResults, error)
 }
 
 // Repositories is a mock implementation of Repositories method of dim.RegistryClient interface
@@ -47,8 +48,11 @@ func (r *NoOpRegistryClient) NewRepository(parsedName reference.Named) (dim.Repo
 }
 
 // Search is a mock implementation of Search method of dim.RegistryClient interface
-func (r *NoOpRegistryClient) Search(query, advanced string, offset, numResults int) (*dim.SearchResults, error) {
-	return nil, nil
+func (r *NoOpRegistryClient) Search(query, advanced, platform string, facets, sort []string, offset, numResults int) (*dim.SearchResults, error) {
+	if r.SearchFn == nil {
+		return nil, nil
+	}
+	return r.SearchFn(query, advanced, platform, facets, sort, offset, numResults)
 }
 
 // WalkRepositories is a mock implementation of WalkRepositories method of dim.RegistryClient interface
@@ -57,7 +61,7 @@ func (r *NoOpRegistryClient) WalkRepositories() <-chan dim.Repository {
 }
 
 // PrintImageInfo is a mock implementation of PrintImageInfo method of dim.RegistryClient interface
-func (r *NoOpRegistryClient) PrintImageInfo(w io.Writer, parsedName reference.Named, tpl *template.Template) error {
+func (r *NoOpRegistryClient) PrintImageInfo(w io.Writer, parsedName reference.Named, platform string, tpl *template.Template) error {
 	return nil
 }
 
@@ -71,15 +75,56 @@ func (r *NoOpRegistryClient) ServerVersion() (*dim.Info, error) {
 	return nil, nil
 }
 
+// IndexStatus is a mock implementation of IndexStatus method of dim.RegistryClient interface
+func (r *NoOpRegistryClient) IndexStatus() (*dim.IndexStatus, error) {
+	return nil, nil
+}
+
+// BackupIndex is a mock implementation of BackupIndex method of dim.RegistryClient interface
+func (r *NoOpRegistryClient) BackupIndex(w io.Writer) error {
+	return nil
+}
+
+// ExportIndex is a mock implementation of ExportIndex method of dim.RegistryClient interface
+func (r *NoOpRegistryClient) ExportIndex(w io.Writer, format string, fields []string) error {
+	return nil
+}
+
+// DeadLetters is a mock implementation of DeadLetters method of dim.RegistryClient interface
+func (r *NoOpRegistryClient) DeadLetters() ([]*dim.DeadLetter, error) {
+	return nil, nil
+}
+
+// RetryDeadLetters is a mock implementation of RetryDeadLetters method of dim.RegistryClient interface
+func (r *NoOpRegistryClient) RetryDeadLetters(ids []uint64) (int, error) {
+	return 0, nil
+}
+
+// PurgeDeadLetters is a mock implementation of PurgeDeadLetters method of dim.RegistryClient interface
+func (r *NoOpRegistryClient) PurgeDeadLetters(ids []uint64) (int, error) {
+	return 0, nil
+}
+
+// Suggest is a mock implementation of Suggest method of dim.RegistryClient interface
+func (r *NoOpRegistryClient) Suggest(field, prefix string, size int) ([]dim.FacetTerm, error) {
+	return nil, nil
+}
+
+// Image is a mock implementation of Image method of dim.RegistryClient interface
+func (r *NoOpRegistryClient) Image(parsedName reference.Named, platform string) (*dim.RegistryImage, error) {
+	return nil, nil
+}
+
 // NoOpRegistryRepository is a mock implementation of dim.Repository interface
 type NoOpRegistryRepository struct {
 	distribution.Repository
-	Name                string
-	AllTagsFn           func() ([]string, error)
-	ImageFn             func(tag string) (*dim.RegistryImage, error)
-	ImageFromManifestFn func(tagDigest digest.Digest, digest string) (img *dim.RegistryImage, err error)
-	WalkImagesFn        func() <-chan *dim.RegistryImage
-	NamedFn             func() ref.Named
+	Name                 string
+	AllTagsFn            func() ([]string, error)
+	TagDigestFn          func(tag string) (digest.Digest, error)
+	ImagesFn             func(tag string) ([]*dim.RegistryImage, error)
+	ImagesFromManifestFn func(tagDigest digest.Digest, digest string) (imgs []*dim.RegistryImage, err error)
+	WalkImagesFn         func() <-chan *dim.RegistryImage
+	NamedFn              func() ref.Named
 }
 
 // AllTags is a mock implementation of AllTags method from dim.Repository interface
@@ -87,14 +132,19 @@ func (r *NoOpRegistryRepository) AllTags() ([]string, error) {
 	return r.AllTagsFn()
 }
 
-// Image is a mock implementation of Image method from dim.Repository interface
-func (r *NoOpRegistryRepository) Image(tag string) (*dim.RegistryImage, error) {
-	return r.ImageFn(tag)
+// TagDigest is a mock implementation of TagDigest method from dim.Repository interface
+func (r *NoOpRegistryRepository) TagDigest(tag string) (digest.Digest, error) {
+	return r.TagDigestFn(tag)
+}
+
+// Images is a mock implementation of Images method from dim.Repository interface
+func (r *NoOpRegistryRepository) Images(tag string) ([]*dim.RegistryImage, error) {
+	return r.ImagesFn(tag)
 }
 
-// ImageFromManifest is a mock implementation of ImageFromManifest method from dim.Repository interface
-func (r *NoOpRegistryRepository) ImageFromManifest(tagDigest digest.Digest, digest string) (*dim.RegistryImage, error) {
-	return r.ImageFromManifestFn(tagDigest, digest)
+// ImagesFromManifest is a mock implementation of ImagesFromManifest method from dim.Repository interface
+func (r *NoOpRegistryRepository) ImagesFromManifest(tagDigest digest.Digest, digest string) ([]*dim.RegistryImage, error) {
+	return r.ImagesFromManifestFn(tagDigest, digest)
 }
 
 // DeleteImage is a mock implementation of DeleteImage method from dim.Repository interface
@@ -114,7 +164,12 @@ func (r *NoOpRegistryRepository) Named() ref.Named {
 
 // NoOpRegistryIndex is a mock implementation of dim.RegistryIndex interface
 type NoOpRegistryIndex struct {
-	Calls map[string][]interface{}
+	Calls       map[string][]interface{}
+	DriftReport *dim.DriftReport
+	IndexStatus *dim.IndexStatus
+	Searches    []*dim.SavedSearch
+	Suggestions []dim.FacetTerm
+	Dead        []*dim.DeadLetter
 }
 
 // Build is a mock implementation of Build method from dim.RegistryIndex interface
@@ -123,8 +178,8 @@ func (i *NoOpRegistryIndex) Build() <-chan bool {
 	return nil
 }
 
-// GetImage is a mock implementation of GetImage method from dim.RegistryIndex interface
-func (i *NoOpRegistryIndex) GetImage(repository, tag string, dg digest.Digest) (*dim.IndexImage, error) {
+// GetImages is a mock implementation of GetImages method from dim.RegistryIndex interface
+func (i *NoOpRegistryIndex) GetImages(repository, tag string, dg digest.Digest) ([]*dim.IndexImage, error) {
 	i.Calls["GetImageAndIndex"] = []interface{}{repository, tag, dg}
 	return nil, nil
 }
@@ -135,13 +190,14 @@ func (i *NoOpRegistryIndex) IndexImage(image *dim.IndexImage) {
 }
 
 // DeleteImage is a mock implementation of DeleteImage method from dim.RegistryIndex interface
-func (i *NoOpRegistryIndex) DeleteImage(id string) {
-	i.Calls["DeleteImage"] = []interface{}{id}
+func (i *NoOpRegistryIndex) DeleteImage(repository, id string) ([]*dim.IndexImage, error) {
+	i.Calls["DeleteImage"] = []interface{}{repository, id}
+	return nil, nil
 }
 
 // SearchImages is a mock implementation of SearchImages method from dim.RegistryIndex interface
-func (i *NoOpRegistryIndex) SearchImages(q, a string, fields []string, offset, maxResults int) (*dim.IndexResults, error) {
-	i.Calls["SearchImages"] = []interface{}{q, a, fields, offset, maxResults}
+func (i *NoOpRegistryIndex) SearchImages(q, a, platform string, fields, facets, sort []string, offset, maxResults int) (*dim.IndexResults, error) {
+	i.Calls["SearchImages"] = []interface{}{q, a, platform, fields, facets, sort, offset, maxResults}
 	return nil, nil
 }
 
@@ -156,9 +212,64 @@ func (i *NoOpRegistryIndex) FindImage(id string) (*dim.IndexImage, error) {
 	return nil, nil
 }
 
+// Drift is a mock implementation of Drift method from dim.RegistryIndex interface
+func (i *NoOpRegistryIndex) Drift() *dim.DriftReport {
+	i.Calls["Drift"] = nil
+	return i.DriftReport
+}
+
+// Status is a mock implementation of Status method from dim.RegistryIndex interface
+func (i *NoOpRegistryIndex) Status() *dim.IndexStatus {
+	i.Calls["Status"] = nil
+	return i.IndexStatus
+}
+
+// Backup is a mock implementation of Backup method from dim.RegistryIndex interface
+func (i *NoOpRegistryIndex) Backup(w io.Writer) error {
+	i.Calls["Backup"] = []interface{}{w}
+	return nil
+}
+
+// Export is a mock implementation of Export method from dim.RegistryIndex interface
+func (i *NoOpRegistryIndex) Export(w io.Writer, format string, fields []string) error {
+	i.Calls["Export"] = []interface{}{w, format, fields}
+	return nil
+}
+
+// Reconcile is a mock implementation of Reconcile method from dim.RegistryIndex interface
+func (i *NoOpRegistryIndex) Reconcile() <-chan bool {
+	i.Calls["Reconcile"] = nil
+	return nil
+}
+
+// SavedSearches is a mock implementation of SavedSearches method from dim.RegistryIndex interface
+func (i *NoOpRegistryIndex) SavedSearches() []*dim.SavedSearch {
+	i.Calls["SavedSearches"] = nil
+	return i.Searches
+}
+
+// SaveSearch is a mock implementation of SaveSearch method from dim.RegistryIndex interface
+func (i *NoOpRegistryIndex) SaveSearch(search *dim.SavedSearch) error {
+	i.Calls["SaveSearch"] = []interface{}{search}
+	return nil
+}
+
+// DeleteSearch is a mock implementation of DeleteSearch method from dim.RegistryIndex interface
+func (i *NoOpRegistryIndex) DeleteSearch(name string) error {
+	i.Calls["DeleteSearch"] = []interface{}{name}
+	return nil
+}
+
+// Suggest is a mock implementation of Suggest method from dim.RegistryIndex interface
+func (i *NoOpRegistryIndex) Suggest(field, prefix string, size int) ([]dim.FacetTerm, error) {
+	i.Calls["Suggest"] = []interface{}{field, prefix, size}
+	return i.Suggestions, nil
+}
+
 // NoOpDockerClient is a mock implementation of dockerClient.Docker interface
 type NoOpDockerClient struct {
 	ImageInspectLabels map[string]string
+	ImageHistory       []types.ImageHistory
 	Calls              map[string][]interface{}
 }
 
@@ -180,6 +291,12 @@ func (n *NoOpDockerClient) Inspect(image string) (types.ImageInspect, error) {
 	return types.ImageInspect{Config: &container.Config{Labels: n.ImageInspectLabels}, ContainerConfig: &container.Config{Labels: n.ImageInspectLabels}}, nil
 }
 
+// History is a mock implementation of History method from dockerClient.Client interface
+func (n *NoOpDockerClient) History(image string) ([]types.ImageHistory, error) {
+	n.Calls["History"] = []interface{}{image}
+	return n.ImageHistory, nil
+}
+
 // Remove is a mock implementation of Remove method from dockerClient.Client interface
 func (n *NoOpDockerClient) Remove(image string) error {
 	n.Calls["Remove"] = []interface{}{image}
@@ -191,3 +308,21 @@ func (n *NoOpDockerClient) Push(image string) error {
 	n.Calls["Push"] = []interface{}{image}
 	return nil
 }
+
+// DeadLetters is a mock implementation of DeadLetters method from dim.RegistryIndex interface
+func (i *NoOpRegistryIndex) DeadLetters() ([]*dim.DeadLetter, error) {
+	i.Calls["DeadLetters"] = nil
+	return i.Dead, nil
+}
+
+// RetryDeadLetters is a mock implementation of RetryDeadLetters method from dim.RegistryIndex interface
+func (i *NoOpRegistryIndex) RetryDeadLetters(ids []uint64) (int, error) {
+	i.Calls["RetryDeadLetters"] = []interface{}{ids}
+	return len(i.Dead), nil
+}
+
+// PurgeDeadLetters is a mock implementation of PurgeDeadLetters method from dim.RegistryIndex interface
+func (i *NoOpRegistryIndex) PurgeDeadLetters(ids []uint64) (int, error) {
+	i.Calls["PurgeDeadLetters"] = []interface{}{ids}
+	return len(i.Dead), nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2016
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/manifest"
+)
+
+// MediaTypeManifestList specifies the mediaType for manifest lists
+const MediaTypeManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+
+func init() {
+	for _, mediaType := range []string{MediaTypeManifestList, MediaTypeOCIIndex} {
+		if err := distribution.RegisterManifestSchema(mediaType, unmarshalManifestList(mediaType)); err != nil {
+			panic(fmt.Sprintf("Unable to register manifest list %s : %v", mediaType, err))
+		}
+	}
+}
+
+// PlatformSpec describes the platform a manifest of a manifest list runs on
+type PlatformSpec struct {
+	Architecture string   `json:"architecture"`
+	OS           string   `json:"os"`
+	OSVersion    string   `json:"os.version,omitempty"`
+	OSFeatures   []string `json:"os.features,omitempty"`
+	Variant      string   `json:"variant,omitempty"`
+	Features     []string `json:"features,omitempty"`
+}
+
+// ManifestDescriptor references a platform specific manifest
+type ManifestDescriptor struct {
+	distribution.Descriptor
+	Platform    PlatformSpec      `json:"platform"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// ManifestList references the manifests of an image built for several platforms.
+// It also holds OCI image indexes
+type ManifestList struct {
+	manifest.Versioned
+	Manifests   []ManifestDescriptor `json:"manifests"`
+	Annotations map[string]string    `json:"annotations,omitempty"`
+	canonical   []byte
+}
+
+// References returns the platform specific manifests
+func (m *ManifestList) References() []distribution.Descriptor {
+	dependencies := make([]distribution.Descriptor, len(m.Manifests))
+	for i := range m.Manifests {
+		dependencies[i] = m.Manifests[i].Descriptor
+	}
+	return dependencies
+}
+
+// Payload returns the raw content of the manifest list
+func (m *ManifestList) Payload() (string, []byte, error) {
+	return m.MediaType, m.canonical, nil
+}
+
+// unmarshalManifestList returns the function unmarshalling manifest lists of the given media type
+func unmarshalManifestList(mediaType string) distribution.UnmarshalFunc {
+	return func(b []byte) (distribution.Manifest, distribution.Descriptor, error) {
+		m := &ManifestList{canonical: b}
+		if err := json.Unmarshal(b, m); err != nil {
+			return nil, distribution.Descriptor{}, err
+		}
+		// The media type is optional in OCI documents
+		if m.MediaType == "" {
+			m.MediaType = mediaType
+		}
+		return m, distribution.Descriptor{Digest: digest.FromBytes(b), Size: int64(len(b)), MediaType: mediaType}, nil
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2016
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/docker/docker/image"
+	"github.com/nhurel/dim/lib"
+)
+
+const manifestList = `{
+  "schemaVersion": 2,
+  "mediaType": "application/vnd.docker.distribution.manifest.list.v2+json",
+  "manifests": [
+    {
+      "mediaType": "application/vnd.docker.distribution.manifest.v2+json",
+      "size": 528,
+      "digest": "sha256:5b0bcabd1ed22e9fb1310cf6c2dec7cdef19f0ad69efa1f392e94a4333501270",
+      "platform": {"architecture": "amd64", "os": "linux"}
+    },
+    {
+      "mediaType": "application/vnd.docker.distribution.manifest.v2+json",
+      "size": 528,
+      "digest": "sha256:e692418e4cbaf90ca69d05a66403747baa33ee08806650b51fab815ad7fc331f",
+      "platform": {"architecture": "arm", "os": "linux", "variant": "v7"}
+    }
+  ]
+}`
+
+func TestUnmarshalManifestList(t *testing.T) {
+	mf, desc, err := distribution.UnmarshalManifest(MediaTypeManifestList, []byte(manifestList))
+	if err != nil {
+		t.Fatalf("UnmarshalManifest returned an error : %v", err)
+	}
+	if desc.MediaType != MediaTypeManifestList {
+		t.Errorf("UnmarshalManifest returned media type %s instead of %s", desc.MediaType, MediaTypeManifestList)
+	}
+
+	list, ok := mf.(*ManifestList)
+	if !ok {
+		t.Fatalf("UnmarshalManifest returned a %T instead of a manifest list", mf)
+	}
+	if len(list.References()) != 2 {
+		t.Errorf("Manifest list should reference 2 manifests but references %d", len(list.References()))
+	}
+	if p := list.Manifests[1].Platform; p.Architecture != "arm" || p.Variant != "v7" {
+		t.Errorf("Second manifest has platform %v instead of linux/arm/v7", p)
+	}
+	if mediaType, payload, _ := list.Payload(); mediaType != MediaTypeManifestList || string(payload) != manifestList {
+		t.Errorf("Payload returned %s, %s instead of the original manifest list", mediaType, payload)
+	}
+}
+
+func TestSelectPlatform(t *testing.T) {
+	newImage := func(os, arch, variant string) *dim.RegistryImage {
+		return &dim.RegistryImage{Image: &image.Image{V1Image: image.V1Image{OS: os, Architecture: arch}}, Variant: variant}
+	}
+	multiArch := []*dim.RegistryImage{
+		newImage("linux", runtime.GOARCH, ""),
+		newImage("linux", "arm", "v7"),
+		newImage("windows", "amd64", ""),
+	}
+
+	scenarii := []struct {
+		images   []*dim.RegistryImage
+		platform string
+		expected *dim.RegistryImage
+	}{
+		{multiArch[2:], "", multiArch[2]},
+		{multiArch, "", multiArch[0]},
+		{multiArch, "linux/arm", multiArch[1]},
+		{multiArch, "linux/arm/v7", multiArch[1]},
+		{multiArch, "windows/amd64", multiArch[2]},
+		{multiArch, "linux/arm/v6", nil},
+		{multiArch[1:], "", nil},
+	}
+
+	for _, scenario := range scenarii {
+		got, err := SelectPlatform(scenario.images, scenario.platform)
+		if got != scenario.expected {
+			t.Errorf("SelectPlatform(%s) returned %v instead of %v", scenario.platform, got, scenario.expected)
+		}
+		if scenario.expected == nil && err == nil {
+			t.Errorf("SelectPlatform(%s) should have returned an error", scenario.platform)
+		}
+	}
+}
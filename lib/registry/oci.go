@@ -0,0 +1,79 @@
+// Copyright 2016
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/manifest"
+)
+
+const (
+	// MediaTypeOCIManifest specifies the mediaType for OCI image manifests
+	MediaTypeOCIManifest = "application/vnd.oci.image.manifest.v1+json"
+	// MediaTypeOCIIndex specifies the mediaType for OCI image indexes
+	MediaTypeOCIIndex = "application/vnd.oci.image.index.v1+json"
+	// MediaTypeOCIConfig specifies the mediaType for OCI image configs
+	MediaTypeOCIConfig = "application/vnd.oci.image.config.v1+json"
+)
+
+// attestationAnnotation marks the manifests of an OCI index holding build attestations instead of an image
+const attestationAnnotation = "vnd.docker.reference.type"
+
+func init() {
+	if err := distribution.RegisterManifestSchema(MediaTypeOCIManifest, unmarshalOCIManifest); err != nil {
+		panic(fmt.Sprintf("Unable to register OCI manifest : %v", err))
+	}
+}
+
+// OCIManifest describes an image following the OCI image specification
+type OCIManifest struct {
+	manifest.Versioned
+	Config      distribution.Descriptor   `json:"config"`
+	Layers      []distribution.Descriptor `json:"layers"`
+	Annotations map[string]string         `json:"annotations,omitempty"`
+	canonical   []byte
+}
+
+// References returns the config and the layers of the image
+func (m *OCIManifest) References() []distribution.Descriptor {
+	return append([]distribution.Descriptor{m.Config}, m.Layers...)
+}
+
+// Payload returns the raw content of the manifest
+func (m *OCIManifest) Payload() (string, []byte, error) {
+	return MediaTypeOCIManifest, m.canonical, nil
+}
+
+func unmarshalOCIManifest(b []byte) (distribution.Manifest, distribution.Descriptor, error) {
+	m := &OCIManifest{canonical: b}
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, distribution.Descriptor{}, err
+	}
+	if m.MediaType == "" {
+		m.MediaType = MediaTypeOCIManifest
+	}
+	return m, distribution.Descriptor{Digest: digest.FromBytes(b), Size: int64(len(b)), MediaType: MediaTypeOCIManifest}, nil
+}
+
+// isImageManifest returns true if the descriptor of a manifest list references a runnable image
+func isImageManifest(desc ManifestDescriptor) bool {
+	if _, ok := desc.Annotations[attestationAnnotation]; ok {
+		return false
+	}
+	return desc.Platform.OS != "unknown"
+}
@@ -0,0 +1,115 @@
+// Copyright 2016
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"testing"
+
+	"github.com/docker/distribution"
+)
+
+const ociManifest = `{
+  "schemaVersion": 2,
+  "config": {
+    "mediaType": "application/vnd.oci.image.config.v1+json",
+    "size": 1470,
+    "digest": "sha256:c8a1ba0e4a42ae5a8a4ba35bd0b5d87a1b9aa2e5d8d3e5a9c1a1f38bfa8b8e7a"
+  },
+  "layers": [
+    {
+      "mediaType": "application/vnd.oci.image.layer.v1.tar+gzip",
+      "size": 2811478,
+      "digest": "sha256:31e352740f534f9ad170f75378a84fe453d6156e40700b882d737a8f4a6988a3"
+    }
+  ],
+  "annotations": {"org.opencontainers.image.source": "https://github.com/nhurel/dim"}
+}`
+
+const ociIndex = `{
+  "schemaVersion": 2,
+  "manifests": [
+    {
+      "mediaType": "application/vnd.oci.image.manifest.v1+json",
+      "size": 675,
+      "digest": "sha256:5b0bcabd1ed22e9fb1310cf6c2dec7cdef19f0ad69efa1f392e94a4333501270",
+      "platform": {"architecture": "amd64", "os": "linux"}
+    },
+    {
+      "mediaType": "application/vnd.oci.image.manifest.v1+json",
+      "size": 566,
+      "digest": "sha256:e692418e4cbaf90ca69d05a66403747baa33ee08806650b51fab815ad7fc331f",
+      "platform": {"architecture": "unknown", "os": "unknown"},
+      "annotations": {"vnd.docker.reference.type": "attestation-manifest"}
+    }
+  ],
+  "annotations": {"org.opencontainers.image.version": "1.0"}
+}`
+
+func TestUnmarshalOCIManifest(t *testing.T) {
+	mf, desc, err := distribution.UnmarshalManifest(MediaTypeOCIManifest, []byte(ociManifest))
+	if err != nil {
+		t.Fatalf("UnmarshalManifest returned an error : %v", err)
+	}
+	if desc.MediaType != MediaTypeOCIManifest {
+		t.Errorf("UnmarshalManifest returned media type %s instead of %s", desc.MediaType, MediaTypeOCIManifest)
+	}
+
+	m, ok := mf.(*OCIManifest)
+	if !ok {
+		t.Fatalf("UnmarshalManifest returned a %T instead of an OCI manifest", mf)
+	}
+	if m.Config.MediaType != MediaTypeOCIConfig {
+		t.Errorf("Config has media type %s instead of %s", m.Config.MediaType, MediaTypeOCIConfig)
+	}
+	if len(m.References()) != 2 {
+		t.Errorf("OCI manifest should reference 2 blobs but references %d", len(m.References()))
+	}
+	if m.Annotations["org.opencontainers.image.source"] != "https://github.com/nhurel/dim" {
+		t.Errorf("OCI manifest annotations were not read : %v", m.Annotations)
+	}
+	if mediaType, _, _ := m.Payload(); mediaType != MediaTypeOCIManifest {
+		t.Errorf("Payload returned media type %s instead of %s", mediaType, MediaTypeOCIManifest)
+	}
+}
+
+func TestUnmarshalOCIIndex(t *testing.T) {
+	mf, _, err := distribution.UnmarshalManifest(MediaTypeOCIIndex, []byte(ociIndex))
+	if err != nil {
+		t.Fatalf("UnmarshalManifest returned an error : %v", err)
+	}
+
+	index, ok := mf.(*ManifestList)
+	if !ok {
+		t.Fatalf("UnmarshalManifest returned a %T instead of a manifest list", mf)
+	}
+	if mediaType, _, _ := index.Payload(); mediaType != MediaTypeOCIIndex {
+		t.Errorf("Payload returned media type %s instead of %s", mediaType, MediaTypeOCIIndex)
+	}
+	if index.Annotations["org.opencontainers.image.version"] != "1.0" {
+		t.Errorf("OCI index annotations were not read : %v", index.Annotations)
+	}
+	if !isImageManifest(index.Manifests[0]) {
+		t.Errorf("First manifest of the index should describe an image")
+	}
+	if isImageManifest(index.Manifests[1]) {
+		t.Errorf("Attestation manifest should not describe an image")
+	}
+}
+
+func TestMergeAnnotations(t *testing.T) {
+	merged := mergeAnnotations(map[string]string{"a": "index", "b": "index"}, map[string]string{"b": "image"})
+	if len(merged) != 2 || merged["a"] != "index" || merged["b"] != "image" {
+		t.Errorf("mergeAnnotations returned %v", merged)
+	}
+}
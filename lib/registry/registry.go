@@ -20,6 +20,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"runtime"
 	"strconv"
 	"strings"
 	"text/template"
@@ -113,7 +114,7 @@ func (c *Client) NewRepository(parsedName reference.Named) (dim.Repository, erro
 }
 
 // Search runs a search against the registry, handling dim advanced querying option
-func (c *Client) Search(query, advanced string, offset, maxResults int) (*dim.SearchResults, error) {
+func (c *Client) Search(query, advanced, platform string, facets, sort []string, offset, maxResults int) (*dim.SearchResults, error) {
 	q := strings.TrimSpace(query)
 	a := strings.TrimSpace(advanced)
 	var err error
@@ -128,10 +129,22 @@ func (c *Client) Search(query, advanced string, offset, maxResults int) (*dim.Se
 		values.Set("q", q)
 	}
 
-	for _, field := range []string{"Name", "Tag", "FullName", "Labels", "Envs", "Volumes", "ExposedPorts", "Size", "Created"} {
+	for _, field := range []string{"Registry", "Name", "Tags", "Labels", "Annotations", "Envs", "Volumes", "ExposedPorts", "Size", "Created", "Layers", "LayerSizes", "Entrypoint", "Cmd", "User", "WorkingDir", "OS", "Architecture", "Variant", "Platform", "Healthcheck", "ID", "Parent", "BasedOn"} {
 		values.Add("f", field)
 	}
 
+	for _, facet := range facets {
+		values.Add("facet", facet)
+	}
+
+	for _, key := range sort {
+		values.Add("sort", key)
+	}
+
+	if platform != "" {
+		values.Set("platform", platform)
+	}
+
 	values.Set("offset", strconv.Itoa(offset))
 	values.Set("maxResults", strconv.Itoa(maxResults))
 
@@ -161,7 +174,8 @@ func (c *Client) WalkRepositories() <-chan dim.Repository {
 	return WalkRepositories(c)
 }
 
-// WalkRepositories walks through all repositories and send them in the given channel
+// WalkRepositories walks through all repositories and send them in the given channel.
+// Repositories that cannot be opened are sent as well, their methods returning the error met
 func WalkRepositories(c dim.RegistryClient) <-chan dim.Repository {
 	repositories := make(chan dim.Repository, 5)
 
@@ -196,7 +210,7 @@ func WalkRepositories(c dim.RegistryClient) <-chan dim.Repository {
 
 				if repository, err = c.NewRepository(parsedName); err != nil {
 					logrus.WithError(err).WithField("name", last).Errorln("Failed to fetch repository info")
-					continue
+					repository = &unreachableRepository{named: parsedName, err: fmt.Errorf("Failed to fetch repository info : %v", err)}
 				}
 				repositories <- repository
 			}
@@ -208,30 +222,76 @@ func WalkRepositories(c dim.RegistryClient) <-chan dim.Repository {
 }
 
 // PrintImageInfo prints the info about an image available on the remote registry
-func (c *Client) PrintImageInfo(w io.Writer, parsedName reference.Named, tpl *template.Template) error {
+func (c *Client) PrintImageInfo(w io.Writer, parsedName reference.Named, platform string, tpl *template.Template) error {
+	var image *dim.RegistryImage
+	var err error
+	if image, err = c.Image(parsedName, platform); err != nil {
+		return err
+	}
+
+	info := &imageInfo{
+		ImageInspect: types.ImageInspect{
+			RepoTags: []string{image.Tag},
+			ID:       image.ImageID(),
+			Config:   image.Config,
+		},
+		Platform:    image.Platform(),
+		Annotations: image.Annotations,
+		History:     make([]dim.HistoryEntry, len(image.History)),
+	}
+	for i, h := range image.History {
+		info.History[i] = dim.HistoryEntry{Created: h.Created, CreatedBy: h.CreatedBy, Comment: h.Comment}
+	}
+
+	return tpl.Execute(w, info)
+}
+
+// imageInfo adds the platform, the OCI annotations and the build history to the image details
+type imageInfo struct {
+	types.ImageInspect
+	Platform    string
+	Annotations map[string]string
+	History     []dim.HistoryEntry
+}
+
+// Image returns the details of an image hosted on the registry.
+// When the image is available for several platforms, the platform argument selects which one is returned.
+// If it is empty, the linux image matching the current architecture is returned
+func (c *Client) Image(parsedName reference.Named, platform string) (*dim.RegistryImage, error) {
 	var repository dim.Repository
 	var err error
 	name, _ := reference.ParseNamed(parsedName.Name()[strings.Index(parsedName.Name(), "/")+1:])
 	if repository, err = c.NewRepository(name); err != nil {
 		logrus.WithError(err).Errorln("Failed to fetch repository info")
-		return err
+		return nil, err
 	}
 
-	tag := ParseTag(parsedName)
-
-	var image *dim.RegistryImage
-	if image, err = repository.Image(tag); err != nil {
+	var images []*dim.RegistryImage
+	if images, err = repository.Images(ParseTag(parsedName)); err != nil {
 		logrus.WithError(err).Errorln("Failed to fetch image info")
-		return err
+		return nil, err
 	}
+	return SelectPlatform(images, platform)
+}
 
-	info := &types.ImageInspect{
-		RepoTags: []string{image.Tag},
-		ID:       image.ImageID(),
-		Config:   image.Config,
+// SelectPlatform returns the image matching the given platform.
+// With no platform given, a single image is returned as is, otherwise the linux image for the current architecture is chosen
+func SelectPlatform(images []*dim.RegistryImage, platform string) (*dim.RegistryImage, error) {
+	if platform == "" {
+		if len(images) == 1 {
+			return images[0], nil
+		}
+		platform = dim.FormatPlatform("linux", runtime.GOARCH, "")
 	}
 
-	return tpl.Execute(w, info)
+	available := make([]string, 0, len(images))
+	for _, image := range images {
+		if dim.MatchPlatform(image.Platform(), platform) {
+			return image, nil
+		}
+		available = append(available, image.Platform())
+	}
+	return nil, fmt.Errorf("No image found for platform %s. Available platforms are : %s", platform, strings.Join(available, ", "))
 }
 
 // DeleteImage deletes the image on the remote registry
@@ -283,6 +343,176 @@ func (c *Client) ServerVersion() (*dim.Info, error) {
 	return nil, fmt.Errorf("Server returned an error : %s", resp.Status)
 }
 
+// IndexStatus reads the state of the dim server index
+func (c *Client) IndexStatus() (*dim.IndexStatus, error) {
+
+	var resp *http.Response
+	var err error
+	httpClient := http.Client{Transport: c.transport}
+
+	endpoint := strings.TrimSuffix(c.registryURL, "/") + "/dim/index/status"
+	if resp, err = httpClient.Get(endpoint); err != nil {
+		return nil, fmt.Errorf("Failed to send request : %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 400 {
+		status := &dim.IndexStatus{}
+		if err := json.NewDecoder(resp.Body).Decode(status); err != nil {
+			return nil, fmt.Errorf("Failed to parse response : %v", err)
+		}
+
+		return status, nil
+	}
+
+	return nil, fmt.Errorf("Server returned an error : %s", resp.Status)
+}
+
+// BackupIndex downloads a snapshot archive of the dim server index to w
+func (c *Client) BackupIndex(w io.Writer) error {
+
+	var resp *http.Response
+	var err error
+	httpClient := http.Client{Transport: c.transport}
+
+	endpoint := strings.TrimSuffix(c.registryURL, "/") + "/dim/index/backup"
+	if resp, err = httpClient.Get(endpoint); err != nil {
+		return fmt.Errorf("Failed to send request : %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return fmt.Errorf("Server returned an error : %s", resp.Status)
+	}
+
+	if _, err = io.Copy(w, resp.Body); err != nil {
+		return fmt.Errorf("Failed to download snapshot : %v", err)
+	}
+	return nil
+}
+
+// ExportIndex downloads all the images indexed by the dim server to w, in the given format and with the given fields only if any
+func (c *Client) ExportIndex(w io.Writer, format string, fields []string) error {
+
+	var resp *http.Response
+	var err error
+	httpClient := http.Client{Transport: c.transport}
+
+	values := url.Values{}
+	if format != "" {
+		values.Set("format", format)
+	}
+	for _, field := range fields {
+		values.Add("f", field)
+	}
+
+	endpoint := strings.TrimSuffix(c.registryURL, "/") + "/dim/index/export?" + values.Encode()
+	if resp, err = httpClient.Get(endpoint); err != nil {
+		return fmt.Errorf("Failed to send request : %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("Server returned an error : %s", string(b))
+	}
+
+	if _, err = io.Copy(w, resp.Body); err != nil {
+		return fmt.Errorf("Failed to download images : %v", err)
+	}
+	return nil
+}
+
+// Suggest returns the most frequent values of a field starting with prefix, as indexed by the dim server
+func (c *Client) Suggest(field, prefix string, size int) ([]dim.FacetTerm, error) {
+
+	var resp *http.Response
+	var err error
+	httpClient := http.Client{Transport: c.transport}
+
+	values := url.Values{}
+	values.Set("field", field)
+	values.Set("prefix", prefix)
+	if size > 0 {
+		values.Set("size", strconv.Itoa(size))
+	}
+
+	endpoint := strings.TrimSuffix(c.registryURL, "/") + "/dim/suggest?" + values.Encode()
+	if resp, err = httpClient.Get(endpoint); err != nil {
+		return nil, fmt.Errorf("Failed to send request : %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 400 {
+		terms := make([]dim.FacetTerm, 0, size)
+		if err := json.NewDecoder(resp.Body).Decode(&terms); err != nil {
+			return nil, fmt.Errorf("Failed to parse response : %v", err)
+		}
+		return terms, nil
+	}
+
+	b, _ := ioutil.ReadAll(resp.Body)
+	return nil, fmt.Errorf("Server returned an error : %s", string(b))
+}
+
+// DeadLetters returns the notifications the dim server failed to apply too many times
+func (c *Client) DeadLetters() ([]*dim.DeadLetter, error) {
+	httpClient := http.Client{Transport: c.transport}
+
+	endpoint := strings.TrimSuffix(c.registryURL, "/") + "/dim/index/deadletters"
+	resp, err := httpClient.Get(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to send request : %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 400 {
+		letters := make([]*dim.DeadLetter, 0, 10)
+		if err := json.NewDecoder(resp.Body).Decode(&letters); err != nil {
+			return nil, fmt.Errorf("Failed to parse response : %v", err)
+		}
+		return letters, nil
+	}
+
+	b, _ := ioutil.ReadAll(resp.Body)
+	return nil, fmt.Errorf("Server returned an error : %s", string(b))
+}
+
+// RetryDeadLetters asks the dim server to apply again the dead letters with the given IDs, or all of them when no ID is given
+func (c *Client) RetryDeadLetters(ids []uint64) (int, error) {
+	return c.updateDeadLetters(http.MethodPost, ids)
+}
+
+// PurgeDeadLetters asks the dim server to remove the dead letters with the given IDs, or all of them when no ID is given
+func (c *Client) PurgeDeadLetters(ids []uint64) (int, error) {
+	return c.updateDeadLetters(http.MethodDelete, ids)
+}
+
+// updateDeadLetters sends a request with the given method on the dead letters and returns the number of dead letters updated
+func (c *Client) updateDeadLetters(method string, ids []uint64) (int, error) {
+	httpClient := http.Client{Transport: c.transport}
+
+	values := url.Values{}
+	for _, id := range ids {
+		values.Add("id", strconv.FormatUint(id, 10))
+	}
+	endpoint := strings.TrimSuffix(c.registryURL, "/") + "/dim/index/deadletters?" + values.Encode()
+	req, err := http.NewRequest(method, endpoint, nil)
+	if err != nil {
+		return 0, err
+	}
+	var resp *http.Response
+	if resp, err = httpClient.Do(req); err != nil {
+		return 0, fmt.Errorf("Failed to send request : %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 400 {
+		result := make(map[string]int)
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			return 0, fmt.Errorf("Failed to parse response : %v", err)
+		}
+		return result["count"], nil
+	}
+
+	b, _ := ioutil.ReadAll(resp.Body)
+	return 0, fmt.Errorf("Server returned an error : %s", string(b))
+}
+
 // ParseTag returns the tag corresponding to the given image name
 func ParseTag(name reference.Named) string {
 	var tag string
@@ -15,6 +15,7 @@ package registry
 
 import (
 	"encoding/json"
+	"fmt"
 
 	"sync"
 
@@ -22,6 +23,7 @@ import (
 	"github.com/docker/distribution"
 	"github.com/docker/distribution/digest"
 	"github.com/docker/distribution/manifest/schema2"
+	"github.com/docker/distribution/reference"
 	"github.com/nhurel/dim/lib"
 )
 
@@ -63,66 +65,170 @@ func (r *Repository) blobService() distribution.BlobService {
 	return r.bfs
 }
 
-// Image return image info for a given tag
-func (r *Repository) Image(tag string) (img *dim.RegistryImage, err error) {
+// Images return image info for a given tag. A tag pointing to a manifest list returns one image per platform
+func (r *Repository) Images(tag string) (imgs []*dim.RegistryImage, err error) {
 
 	var tagDigest digest.Digest
-	if tagDigest, err = r.getTagDigest(tag); err != nil {
+	if tagDigest, err = r.TagDigest(tag); err != nil {
 		return
 	}
 
-	if img, err = r.ImageFromManifest(tagDigest, tag); err != nil {
+	if imgs, err = r.ImagesFromManifest(tagDigest, tag); err != nil {
 		logrus.WithFields(logrus.Fields{"repository": r.Named().Name()}).WithError(err).Errorln("Failed to get image")
 	}
 	return
 }
 
-// ImageFromManifest returns image information from its manifest digest
-func (r *Repository) ImageFromManifest(tagDigest digest.Digest, tag string) (image *dim.RegistryImage, err error) {
+// ImagesFromManifest returns image information from its manifest digest.
+// Manifest lists are resolved to the image of each platform they reference
+func (r *Repository) ImagesFromManifest(tagDigest digest.Digest, tag string) (images []*dim.RegistryImage, err error) {
+	var mf distribution.Manifest
+	if mf, err = r.manifest(tagDigest); err != nil {
+		return
+	}
+
+	switch m := mf.(type) {
+	case *ManifestList:
+		images = make([]*dim.RegistryImage, 0, len(m.Manifests))
+		for _, desc := range m.Manifests {
+			if !isImageManifest(desc) {
+				logrus.WithField("digest", desc.Digest).Debugln("Skipping manifest not describing an image")
+				continue
+			}
+			var platformMf distribution.Manifest
+			if platformMf, err = r.manifest(desc.Digest); err != nil {
+				return nil, err
+			}
+			var image *dim.RegistryImage
+			if image, err = r.imageFromManifest(platformMf, desc.Digest, tag); err != nil {
+				return nil, err
+			}
+			image.ListDigest = string(tagDigest)
+			// The platform declared in the list prevails over the image config
+			image.OS, image.Architecture, image.Variant = desc.Platform.OS, desc.Platform.Architecture, desc.Platform.Variant
+			image.Annotations = mergeAnnotations(m.Annotations, image.Annotations)
+			images = append(images, image)
+		}
+	default:
+		var image *dim.RegistryImage
+		if image, err = r.imageFromManifest(mf, tagDigest, tag); err != nil {
+			return nil, err
+		}
+		images = []*dim.RegistryImage{image}
+	}
+	return
+}
+
+func (r *Repository) manifest(dg digest.Digest) (mf distribution.Manifest, err error) {
 	var mService distribution.ManifestService
 	if mService, err = r.manifestService(); err != nil {
 		return
 	}
 
-	var mf distribution.Manifest
-	l := logrus.WithField("tagDigest", tagDigest)
-	l.Debugln("Getting manifest")
-	if mf, err = mService.Get(ctx, tagDigest); err != nil {
+	logrus.WithField("digest", dg).Debugln("Getting manifest")
+	if mf, err = mService.Get(ctx, dg); err != nil {
 		logrus.WithFields(logrus.Fields{"repository": r.Named().Name()}).WithError(err).Errorln("Failed to get manifest")
-		return
+	}
+	return
+}
+
+// imageFromManifest returns image information from a single platform manifest
+func (r *Repository) imageFromManifest(mf distribution.Manifest, manifestDigest digest.Digest, tag string) (image *dim.RegistryImage, err error) {
+	l := logrus.WithField("manifestDigest", manifestDigest)
+	if m, ok := mf.(*Schema1Manifest); ok {
+		return r.imageFromSchema1(m, manifestDigest, tag)
 	}
 
 	l.Debugln("Reading manifest")
+	var mediaType string
 	var payload []byte
-	if _, payload, err = mf.Payload(); err != nil {
+	if mediaType, payload, err = mf.Payload(); err != nil {
 		logrus.WithError(err).Errorln("Failed to read manifest")
 		return
 	}
 
 	l.Debugln("Unmarshalling manifest")
-	manif := &schema2.Manifest{}
-	if err = json.Unmarshal(payload, manif); err != nil {
-		logrus.WithFields(logrus.Fields{"repository": r.Named().Name()}).WithError(err).Errorln("Failed to read image manifest")
-		return
+	var config distribution.Descriptor
+	var layers []distribution.Descriptor
+	var annotations map[string]string
+	switch mediaType {
+	case schema2.MediaTypeManifest:
+		manif := &schema2.Manifest{}
+		if err = json.Unmarshal(payload, manif); err != nil {
+			logrus.WithFields(logrus.Fields{"repository": r.Named().Name()}).WithError(err).Errorln("Failed to read image manifest")
+			return
+		}
+		config, layers = manif.Config, manif.Layers
+	case MediaTypeOCIManifest:
+		manif := &OCIManifest{}
+		if err = json.Unmarshal(payload, manif); err != nil {
+			logrus.WithFields(logrus.Fields{"repository": r.Named().Name()}).WithError(err).Errorln("Failed to read OCI image manifest")
+			return
+		}
+		config, layers, annotations = manif.Config, manif.Layers, manif.Annotations
+	default:
+		return nil, fmt.Errorf("Unsupported manifest media type %s", mediaType)
 	}
 
-	if payload, err = r.blobService().Get(ctx, manif.Config.Digest); err != nil {
+	if payload, err = r.blobService().Get(ctx, config.Digest); err != nil {
 		logrus.WithError(err).Errorln("Failed to get image config")
 		return
 	}
 
-	logrus.WithField("Digest", manif.Config.Digest).Debugln("Unmarshalling V2Image")
+	logrus.WithField("Digest", config.Digest).Debugln("Unmarshalling V2Image")
 
-	image = &dim.RegistryImage{Tag: tag, Digest: string(tagDigest)}
+	image = &dim.RegistryImage{Tag: tag, Digest: string(manifestDigest), Annotations: annotations}
 	if err = json.Unmarshal(payload, image); err != nil {
-		logrus.WithField("Digest", manif.Config.Digest).WithError(err).Errorln("Failed to read image")
+		logrus.WithField("Digest", config.Digest).WithError(err).Errorln("Failed to read image")
 		return
 	}
 
+	image.Layers = make([]dim.Layer, len(layers))
+	for i, layer := range layers {
+		image.Layers[i] = dim.Layer{Digest: string(layer.Digest), Size: layer.Size}
+	}
+
 	return
 }
 
-func (r *Repository) getTagDigest(tag string) (digest.Digest, error) {
+// imageFromSchema1 returns image information from a legacy schema1 manifest.
+// Schema1 manifests don't give the size of the layers so they are read from the blob store
+func (r *Repository) imageFromSchema1(mf *Schema1Manifest, manifestDigest digest.Digest, tag string) (image *dim.RegistryImage, err error) {
+	logrus.WithField("manifestDigest", manifestDigest).Debugln("Converting schema1 manifest")
+	if image, err = mf.Image(manifestDigest, tag); err != nil {
+		logrus.WithFields(logrus.Fields{"repository": r.Named().Name()}).WithError(err).Errorln("Failed to read schema1 manifest")
+		return nil, err
+	}
+
+	for i, layer := range image.Layers {
+		var desc distribution.Descriptor
+		if desc, err = r.blobService().Stat(ctx, digest.Digest(layer.Digest)); err != nil {
+			logrus.WithField("layer", layer.Digest).WithError(err).Warnln("Failed to get layer size")
+			continue
+		}
+		image.Layers[i].Size = desc.Size
+	}
+	return image, nil
+}
+
+// mergeAnnotations returns the annotations of an index completed with the ones of an image manifest.
+// Image annotations prevail over the index ones
+func mergeAnnotations(index, image map[string]string) map[string]string {
+	if len(index) == 0 {
+		return image
+	}
+	merged := make(map[string]string, len(index)+len(image))
+	for k, v := range index {
+		merged[k] = v
+	}
+	for k, v := range image {
+		merged[k] = v
+	}
+	return merged
+}
+
+// TagDigest returns the digest of the manifest the given tag points to
+func (r *Repository) TagDigest(tag string) (digest.Digest, error) {
 	var err error
 	var tDescriptor distribution.Descriptor
 	if tDescriptor, err = r.tagService().Get(ctx, tag); err != nil {
@@ -140,7 +246,7 @@ func (r *Repository) DeleteImage(tag string) error {
 	var mfService distribution.ManifestService
 
 	var tagDigest digest.Digest
-	if tagDigest, err = r.getTagDigest(tag); err != nil {
+	if tagDigest, err = r.TagDigest(tag); err != nil {
 		return err
 	}
 
@@ -183,14 +289,16 @@ func WalkImages(r dim.Repository) <-chan *dim.RegistryImage {
 					l = l.WithField("tag", tag)
 					l.Debugln("Getting image details")
 
-					var img *dim.RegistryImage
-					if img, err = r.Image(tag); err != nil {
+					var imgs []*dim.RegistryImage
+					if imgs, err = r.Images(tag); err != nil {
 						logrus.WithError(err).Errorln("Failed to get image")
 						return
 					}
 
-					l.WithField("image", img).Debugln("Walking on image")
-					images <- img
+					for _, img := range imgs {
+						l.WithField("image", img).Debugln("Walking on image")
+						images <- img
+					}
 				}
 				wg.Done()
 			}()
@@ -207,3 +315,48 @@ func WalkImages(r dim.Repository) <-chan *dim.RegistryImage {
 	return images
 
 }
+
+// unreachableRepository stands for a repository that could not be opened.
+// Its methods fail with the opening error, so that the repository is reported as failed rather than empty
+type unreachableRepository struct {
+	distribution.Repository
+	named reference.Named
+	err   error
+}
+
+// Named returns the name of the repository
+func (r *unreachableRepository) Named() reference.Named {
+	return r.named
+}
+
+// AllTags returns the error met when opening the repository
+func (r *unreachableRepository) AllTags() ([]string, error) {
+	return nil, r.err
+}
+
+// TagDigest returns the error met when opening the repository
+func (r *unreachableRepository) TagDigest(tag string) (digest.Digest, error) {
+	return "", r.err
+}
+
+// Images returns the error met when opening the repository
+func (r *unreachableRepository) Images(tag string) ([]*dim.RegistryImage, error) {
+	return nil, r.err
+}
+
+// ImagesFromManifest returns the error met when opening the repository
+func (r *unreachableRepository) ImagesFromManifest(tagDigest digest.Digest, tag string) ([]*dim.RegistryImage, error) {
+	return nil, r.err
+}
+
+// DeleteImage returns the error met when opening the repository
+func (r *unreachableRepository) DeleteImage(tag string) error {
+	return r.err
+}
+
+// WalkImages returns a closed channel as the repository cannot be read
+func (r *unreachableRepository) WalkImages() <-chan *dim.RegistryImage {
+	images := make(chan *dim.RegistryImage)
+	close(images)
+	return images
+}
@@ -0,0 +1,185 @@
+// Copyright 2016
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/manifest"
+	"github.com/docker/docker/image"
+	"github.com/nhurel/dim/lib"
+)
+
+const (
+	// MediaTypeSignedManifest specifies the mediaType for signed schema1 manifests
+	MediaTypeSignedManifest = "application/vnd.docker.distribution.manifest.v1+prettyjws"
+	// MediaTypeManifestV1 specifies the mediaType for unsigned schema1 manifests
+	MediaTypeManifestV1 = "application/vnd.docker.distribution.manifest.v1+json"
+)
+
+func init() {
+	// Registries that predate media types serve schema1 manifests as plain json
+	for _, mediaType := range []string{MediaTypeSignedManifest, MediaTypeManifestV1, "application/json", ""} {
+		if err := distribution.RegisterManifestSchema(mediaType, unmarshalSchema1Manifest); err != nil {
+			panic(fmt.Sprintf("Unable to register schema1 manifest %s : %v", mediaType, err))
+		}
+	}
+}
+
+// FSLayer references a layer of a schema1 manifest
+type FSLayer struct {
+	BlobSum digest.Digest `json:"blobSum"`
+}
+
+// V1History holds the v1 json of an image layer
+type V1History struct {
+	V1Compatibility string `json:"v1Compatibility"`
+}
+
+// Schema1Manifest is a legacy schema1 manifest. FSLayers and History are ordered from the top layer to the base one
+type Schema1Manifest struct {
+	manifest.Versioned
+	Name         string      `json:"name"`
+	Tag          string      `json:"tag"`
+	Architecture string      `json:"architecture"`
+	FSLayers     []FSLayer   `json:"fsLayers"`
+	History      []V1History `json:"history"`
+	canonical    []byte
+	all          []byte
+}
+
+// v1Compatibility is the part of the v1 json describing how a layer was built
+type v1Compatibility struct {
+	Created         time.Time `json:"created"`
+	Author          string    `json:"author,omitempty"`
+	Comment         string    `json:"comment,omitempty"`
+	ContainerConfig struct {
+		Cmd []string
+	} `json:"container_config,omitempty"`
+	ThrowAway bool `json:"throwaway,omitempty"`
+}
+
+// jwsProtected is the protected header of a schema1 signature. It tells how to rebuild the signed payload
+type jwsProtected struct {
+	FormatLength int    `json:"formatLength"`
+	FormatTail   string `json:"formatTail"`
+}
+
+// References returns the layers of the image
+func (m *Schema1Manifest) References() []distribution.Descriptor {
+	dependencies := make([]distribution.Descriptor, len(m.FSLayers))
+	for i, l := range m.FSLayers {
+		dependencies[i] = distribution.Descriptor{MediaType: "application/octet-stream", Digest: l.BlobSum}
+	}
+	return dependencies
+}
+
+// Payload returns the raw content of the manifest, signatures included
+func (m *Schema1Manifest) Payload() (string, []byte, error) {
+	return MediaTypeSignedManifest, m.all, nil
+}
+
+func unmarshalSchema1Manifest(b []byte) (distribution.Manifest, distribution.Descriptor, error) {
+	m := &Schema1Manifest{all: b}
+	var signed struct {
+		Signatures []struct {
+			Protected string `json:"protected"`
+		} `json:"signatures"`
+	}
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, distribution.Descriptor{}, err
+	}
+	if err := json.Unmarshal(b, &signed); err != nil {
+		return nil, distribution.Descriptor{}, err
+	}
+	if m.SchemaVersion != 1 {
+		return nil, distribution.Descriptor{}, fmt.Errorf("Unsupported manifest schema version %d", m.SchemaVersion)
+	}
+
+	m.canonical = b
+	if len(signed.Signatures) > 0 {
+		var err error
+		if m.canonical, err = canonicalPayload(b, signed.Signatures[0].Protected); err != nil {
+			return nil, distribution.Descriptor{}, err
+		}
+	}
+	// The registry computes the digest of schema1 manifests on their unsigned content
+	return m, distribution.Descriptor{Digest: digest.FromBytes(m.canonical), Size: int64(len(m.canonical)), MediaType: MediaTypeSignedManifest}, nil
+}
+
+// canonicalPayload removes the signatures from a signed manifest
+func canonicalPayload(b []byte, protected string) ([]byte, error) {
+	header, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(protected, "="))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode manifest signature : %v", err)
+	}
+	var p jwsProtected
+	if err = json.Unmarshal(header, &p); err != nil {
+		return nil, fmt.Errorf("Failed to read manifest signature : %v", err)
+	}
+	var tail []byte
+	if tail, err = base64.RawURLEncoding.DecodeString(strings.TrimRight(p.FormatTail, "=")); err != nil {
+		return nil, fmt.Errorf("Failed to decode manifest signature : %v", err)
+	}
+	if p.FormatLength > len(b) {
+		return nil, fmt.Errorf("Invalid manifest signature")
+	}
+	return append(append([]byte{}, b[:p.FormatLength]...), tail...), nil
+}
+
+// Image converts the manifest into an image. The config is read from the v1 json of the top layer
+// and the build history from the v1 json of every layer
+func (m *Schema1Manifest) Image(manifestDigest digest.Digest, tag string) (*dim.RegistryImage, error) {
+	if len(m.History) == 0 || len(m.History) != len(m.FSLayers) {
+		return nil, fmt.Errorf("Invalid schema1 manifest : %d layers and %d history entries", len(m.FSLayers), len(m.History))
+	}
+
+	img := &dim.RegistryImage{Tag: tag, Digest: string(manifestDigest)}
+	if err := json.Unmarshal([]byte(m.History[0].V1Compatibility), img); err != nil {
+		return nil, fmt.Errorf("Failed to read image config : %v", err)
+	}
+	if img.Architecture == "" {
+		img.Architecture = m.Architecture
+	}
+	if img.OS == "" {
+		img.OS = "linux"
+	}
+
+	img.History = make([]image.History, 0, len(m.History))
+	img.Layers = make([]dim.Layer, 0, len(m.FSLayers))
+	for i := len(m.History) - 1; i >= 0; i-- {
+		var v1 v1Compatibility
+		if err := json.Unmarshal([]byte(m.History[i].V1Compatibility), &v1); err != nil {
+			return nil, fmt.Errorf("Failed to read image history : %v", err)
+		}
+		img.History = append(img.History, image.History{
+			Created:    v1.Created,
+			Author:     v1.Author,
+			CreatedBy:  strings.Join(v1.ContainerConfig.Cmd, " "),
+			Comment:    v1.Comment,
+			EmptyLayer: v1.ThrowAway,
+		})
+		if !v1.ThrowAway {
+			img.Layers = append(img.Layers, dim.Layer{Digest: string(m.FSLayers[i].BlobSum)})
+		}
+	}
+
+	return img, nil
+}
@@ -0,0 +1,105 @@
+// Copyright 2016
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"encoding/base64"
+	"fmt"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/digest"
+)
+
+// schema1Manifest is an unsigned schema1 manifest without its closing brace
+const schema1Manifest = `{
+   "schemaVersion": 1,
+   "name": "library/httpd",
+   "tag": "2.2",
+   "architecture": "amd64",
+   "fsLayers": [
+      {"blobSum": "sha256:a3ed95caeb02ffe68cdd9fd84406680ae93d633cb16422d00e8a7c22955b46d4"},
+      {"blobSum": "sha256:httpd"},
+      {"blobSum": "sha256:debian"}
+   ],
+   "history": [
+      {"v1Compatibility": "{\"id\":\"3\",\"parent\":\"2\",\"created\":\"2016-01-03T00:00:00Z\",\"container_config\":{\"Cmd\":[\"/bin/sh\",\"-c\",\"#(nop) CMD [\\\"httpd-foreground\\\"]\"]},\"config\":{\"Cmd\":[\"httpd-foreground\"],\"Labels\":{\"family\":\"debian\"}},\"os\":\"linux\",\"throwaway\":true}"},
+      {"v1Compatibility": "{\"id\":\"2\",\"parent\":\"1\",\"created\":\"2016-01-02T00:00:00Z\",\"container_config\":{\"Cmd\":[\"/bin/sh\",\"-c\",\"apt-get install -y apache2\"]}}"},
+      {"v1Compatibility": "{\"id\":\"1\",\"created\":\"2016-01-01T00:00:00Z\",\"container_config\":{\"Cmd\":[\"/bin/sh\",\"-c\",\"#(nop) ADD file:debian in /\"]}}"}
+   ]`
+
+func TestUnmarshalSchema1Manifest(t *testing.T) {
+	unsigned := schema1Manifest + "\n}"
+	protected := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"formatLength":%d,"formatTail":"%s"}`, len(schema1Manifest), base64.RawURLEncoding.EncodeToString([]byte("\n}")))))
+	signed := fmt.Sprintf(`%s,
+   "signatures": [{"header": {"alg": "ES256"}, "signature": "sig", "protected": "%s"}]
+}`, schema1Manifest, protected)
+
+	scenarii := []struct {
+		mediaType string
+		payload   string
+	}{
+		{MediaTypeSignedManifest, signed},
+		{MediaTypeManifestV1, unsigned},
+		{"application/json", signed},
+	}
+
+	for _, scenario := range scenarii {
+		mf, desc, err := distribution.UnmarshalManifest(scenario.mediaType, []byte(scenario.payload))
+		if err != nil {
+			t.Fatalf("UnmarshalManifest(%s) returned an error : %v", scenario.mediaType, err)
+		}
+		if desc.Digest != digest.FromBytes([]byte(unsigned)) {
+			t.Errorf("UnmarshalManifest(%s) returned digest %s instead of the digest of the unsigned manifest", scenario.mediaType, desc.Digest)
+		}
+		if _, payload, _ := mf.Payload(); string(payload) != scenario.payload {
+			t.Errorf("Payload of %s manifest should return the original manifest", scenario.mediaType)
+		}
+		if _, ok := mf.(*Schema1Manifest); !ok {
+			t.Errorf("UnmarshalManifest(%s) returned a %T instead of a schema1 manifest", scenario.mediaType, mf)
+		}
+	}
+}
+
+func TestSchema1Image(t *testing.T) {
+	mf, _, err := distribution.UnmarshalManifest(MediaTypeManifestV1, []byte(schema1Manifest+"\n}"))
+	if err != nil {
+		t.Fatalf("UnmarshalManifest returned an error : %v", err)
+	}
+
+	img, err := mf.(*Schema1Manifest).Image("sha256:manifest", "2.2")
+	if err != nil {
+		t.Fatalf("Image returned an error : %v", err)
+	}
+	if img.Tag != "2.2" || img.Digest != "sha256:manifest" {
+		t.Errorf("Image returned tag %s and digest %s", img.Tag, img.Digest)
+	}
+	if img.Platform() != "linux/amd64" {
+		t.Errorf("Image returned platform %s instead of linux/amd64", img.Platform())
+	}
+	if img.Config == nil || img.Config.Labels["family"] != "debian" || len(img.Config.Cmd) != 1 {
+		t.Errorf("Image config was not read from the top layer : %v", img.Config)
+	}
+	if len(img.Layers) != 2 || img.Layers[0].Digest != "sha256:debian" || img.Layers[1].Digest != "sha256:httpd" {
+		t.Errorf("Image should have the base and httpd layers, got %v", img.Layers)
+	}
+	if len(img.History) != 3 || img.History[0].CreatedBy != "/bin/sh -c #(nop) ADD file:debian in /" || !img.History[2].EmptyLayer {
+		t.Errorf("Image history should be ordered from the oldest step, got %v", img.History)
+	}
+
+	mf.(*Schema1Manifest).History = nil
+	if _, err = mf.(*Schema1Manifest).Image("sha256:manifest", "2.2"); err == nil {
+		t.Errorf("Image should fail when history is missing")
+	}
+}
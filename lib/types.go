@@ -14,7 +14,10 @@
 package dim
 
 import (
+	"fmt"
 	"io"
+	"sort"
+	"strings"
 	"time"
 
 	"text/template"
@@ -34,6 +37,8 @@ type SearchResult struct {
 	StarCount int `json:"star_count"`
 	// IsOfficial is true if the result is from an official repository. (not supported in private registry)
 	IsOfficial bool `json:"is_official"`
+	// Registry is the name of the registry hosting the image when the server indexes several registries
+	Registry string `json:"registry,omitempty"`
 	// Name is the name of the repository
 	Name string `json:"name"`
 	// IsAutomated indicates whether the result is automated (not supported in private registry)
@@ -44,18 +49,56 @@ type SearchResult struct {
 	Tag string `json:"tag"`
 	// FullName stores the fully qualified name of the image
 	FullName string `json:"full_name"`
+	// Tags lists all the tags of the repository pointing to the image
+	Tags []string `json:"tags,omitempty"`
 	// Created is the time when the image was created
 	Created time.Time `json:"created"`
 	// Label is an array holding all the labels applied to  an image
 	Label map[string]string `json:"label"`
+	// Annotation holds the OCI annotations of the image manifest
+	Annotation map[string]string `json:"annotation,omitempty"`
 	// Volumes is an array holding all volumes declared by the image
 	Volumes []string `json:"volumes"`
 	// Exposed port is an array containing all the ports exposed by an image
 	ExposedPorts []int `json:"exposed_ports"`
 	// Env is a map of all environment variables
 	Env map[string]string `json:"env"`
+	// Entrypoint is the entrypoint of the image
+	Entrypoint []string `json:"entrypoint,omitempty"`
+	// Cmd is the default command of the image
+	Cmd []string `json:"cmd,omitempty"`
+	// User is the user running the image processes
+	User string `json:"user,omitempty"`
+	// WorkingDir is the directory in which the image processes start
+	WorkingDir string `json:"working_dir,omitempty"`
+	// OS is the operating system the image runs on
+	OS string `json:"os,omitempty"`
+	// Architecture is the hardware architecture the image runs on
+	Architecture string `json:"architecture,omitempty"`
+	// Variant is the variant of the architecture (like v7 for arm)
+	Variant string `json:"variant,omitempty"`
+	// Platform is the os/architecture[/variant] the image runs on
+	Platform string `json:"platform,omitempty"`
+	// Healthcheck is the command checking the container health
+	Healthcheck string `json:"healthcheck,omitempty"`
 	// Size is the size of the image
 	Size int64 `json:"size"`
+	// Layers lists the layers of the image, from the base layer to the top one
+	Layers []Layer `json:"layers,omitempty"`
+	// ID is the digest of the image manifest
+	ID string `json:"id,omitempty"`
+	// Parent is the digest of the closest indexed image this image is built on
+	Parent string `json:"parent,omitempty"`
+	// BasedOn lists the repositories and full names of all the indexed images this image is built on
+	BasedOn []string `json:"based_on,omitempty"`
+}
+
+// Layer describes one layer of an image
+type Layer struct {
+	// Digest is the digest of the compressed layer
+	Digest string `json:"digest"`
+	// Size is the compressed size of the layer
+	Size int64 `json:"size"`
 }
 
 // SearchResults lists a collection search results returned from a registry
@@ -66,6 +109,37 @@ type SearchResults struct {
 	NumResults int `json:"num_results"`
 	// Results is a slice containing the actual results for the search
 	Results []SearchResult `json:"results"`
+	// Facets contains the count of images per value of each requested facet
+	Facets map[string]*Facet `json:"facets,omitempty"`
+}
+
+// Facet counts the images matching a search for each value of a field
+type Facet struct {
+	// Field is the faceted field
+	Field string `json:"field"`
+	// Total is the number of values counted
+	Total int `json:"total"`
+	// Missing is the number of images without value for this field
+	Missing int `json:"missing"`
+	// Other is the number of values not returned in Terms
+	Other int `json:"other"`
+	// Terms lists the most frequent values or the ranges of the field
+	Terms []FacetTerm `json:"terms"`
+}
+
+// FacetTerm is the number of images having a given value for a faceted field
+type FacetTerm struct {
+	Term  string `json:"term"`
+	Count int    `json:"count"`
+}
+
+// QueryError is returned when search parameters given by the user are invalid
+type QueryError struct {
+	Message string
+}
+
+func (e *QueryError) Error() string {
+	return e.Message
 }
 
 // Info represents the server version endpoint payload
@@ -76,34 +150,112 @@ type Info struct {
 	Uptime string `json:"uptime"`
 }
 
+// IndexBuilding is the state of an index while the registry is fully crawled
+const IndexBuilding = "building"
+
+// IndexReconciling is the state of an index while it is compared with the registry
+const IndexReconciling = "reconciling"
+
+// IndexReady is the state of an index that is up to date
+const IndexReady = "ready"
+
+// IndexStatus describes the state of the index and the progress of the crawl of the registry
+type IndexStatus struct {
+	// State is building or reconciling while the registry is crawled, ready otherwise
+	State string `json:"state"`
+	// Repositories is the number of repositories found by the last crawl
+	Repositories int `json:"repositories"`
+	// RepositoriesCrawled is the number of repositories whose tags were all read
+	RepositoriesCrawled int `json:"repositories_crawled"`
+	// Tags is the number of tags found by the last crawl
+	Tags int `json:"tags"`
+	// TagsCrawled is the number of tags already read
+	TagsCrawled int `json:"tags_crawled"`
+	// Documents is the number of images in the index
+	Documents uint64 `json:"documents"`
+	// PendingJobs is the number of notifications waiting to be applied
+	PendingJobs int `json:"pending_jobs"`
+	// LastResync is the time the last reconciliation with the registry ended
+	LastResync *time.Time `json:"last_resync,omitempty"`
+	// Errors are the last errors met while crawling the registry
+	Errors []string `json:"errors,omitempty"`
+	// DiskSize is the size of the index directory, in bytes
+	DiskSize int64 `json:"disk_size"`
+}
+
+// SavedSearch is a named advanced search evaluated against each pushed image
+type SavedSearch struct {
+	// Name identifies the saved search
+	Name string `json:"name"`
+	// Query is the advanced search the pushed images must match
+	Query string `json:"query"`
+	// Action is the hook template evaluated with each matching image. Matches are only recorded when empty
+	Action string `json:"action,omitempty"`
+	// Configured is true for the searches defined in the server configuration. They cannot be changed through the API
+	Configured bool `json:"configured"`
+	// Matches are the last images that matched the search, most recent first
+	Matches []SavedSearchMatch `json:"matches,omitempty"`
+}
+
+// SavedSearchMatch is a pushed image that matched a saved search
+type SavedSearchMatch struct {
+	// FullName is the name and tag of the image
+	FullName string `json:"full_name"`
+	// ID is the digest of the image
+	ID string `json:"id"`
+	// Time is when the image matched the search
+	Time time.Time `json:"time"`
+}
+
 // RegistryIndex defines method to manage the indexation of a docker registry
 type RegistryIndex interface {
 	Build() <-chan bool
-	GetImage(repository, tag string, dg digest.Digest) (*IndexImage, error)
+	Reconcile() <-chan bool
+	GetImages(repository, tag string, dg digest.Digest) ([]*IndexImage, error)
 	IndexImage(image *IndexImage)
-	DeleteImage(id string)
-	SearchImages(q, a string, fields []string, offset, maxResults int) (*IndexResults, error)
+	DeleteImage(repository, id string) ([]*IndexImage, error)
+	SearchImages(q, a, platform string, fields, facets, sort []string, offset, maxResults int) (*IndexResults, error)
 	Submit(job *NotificationJob)
 	FindImage(id string) (*IndexImage, error)
+	Drift() *DriftReport
+	Status() *IndexStatus
+	Backup(w io.Writer) error
+	Export(w io.Writer, format string, fields []string) error
+	SavedSearches() []*SavedSearch
+	SaveSearch(search *SavedSearch) error
+	DeleteSearch(name string) error
+	Suggest(field, prefix string, size int) ([]FacetTerm, error)
+	DeadLetters() ([]*DeadLetter, error)
+	RetryDeadLetters(ids []uint64) (int, error)
+	PurgeDeadLetters(ids []uint64) (int, error)
 }
 
 // RegistryClient defines method to interact with a docker registry
 type RegistryClient interface {
 	client.Registry
 	NewRepository(parsedName reference.Named) (Repository, error)
-	Search(query, advanced string, offset, maxResults int) (*SearchResults, error)
+	Search(query, advanced, platform string, facets, sort []string, offset, maxResults int) (*SearchResults, error)
 	WalkRepositories() <-chan Repository
-	PrintImageInfo(out io.Writer, parsedName reference.Named, tpl *template.Template) error
+	PrintImageInfo(out io.Writer, parsedName reference.Named, platform string, tpl *template.Template) error
 	DeleteImage(parsedName reference.Named) error
 	ServerVersion() (*Info, error)
+	IndexStatus() (*IndexStatus, error)
+	BackupIndex(w io.Writer) error
+	ExportIndex(w io.Writer, format string, fields []string) error
+	Suggest(field, prefix string, size int) ([]FacetTerm, error)
+	DeadLetters() ([]*DeadLetter, error)
+	RetryDeadLetters(ids []uint64) (int, error)
+	PurgeDeadLetters(ids []uint64) (int, error)
+	Image(parsedName reference.Named, platform string) (*RegistryImage, error)
 }
 
 // Repository interface defines methods exposed by a registry repository
 type Repository interface {
 	distribution.Repository
 	AllTags() ([]string, error)
-	Image(tag string) (img *RegistryImage, err error)
-	ImageFromManifest(tagDigest digest.Digest, tag string) (img *RegistryImage, err error)
+	TagDigest(tag string) (digest.Digest, error)
+	Images(tag string) (imgs []*RegistryImage, err error)
+	ImagesFromManifest(tagDigest digest.Digest, tag string) (imgs []*RegistryImage, err error)
 	DeleteImage(tag string) error
 	WalkImages() <-chan *RegistryImage
 }
@@ -113,24 +265,81 @@ type RegistryImage struct {
 	*image.Image
 	Tag    string
 	Digest string
+	// ListDigest is the digest of the manifest list referencing the image, if any
+	ListDigest string  `json:"-"`
+	Variant    string  `json:"variant,omitempty"`
+	Layers     []Layer `json:"-"`
+	// Annotations are the OCI annotations of the image manifest and of its index
+	Annotations map[string]string `json:"-"`
+}
+
+// Platform returns the os/architecture[/variant] the image runs on
+func (img *RegistryImage) Platform() string {
+	return FormatPlatform(img.OS, img.Architecture, img.Variant)
 }
 
-// IndexImage is an Image modeling for indexation
+// FormatPlatform returns the os/architecture[/variant] notation of a platform
+func FormatPlatform(os, architecture, variant string) string {
+	if os == "" && architecture == "" {
+		return ""
+	}
+	if variant == "" {
+		return fmt.Sprintf("%s/%s", os, architecture)
+	}
+	return fmt.Sprintf("%s/%s/%s", os, architecture, variant)
+}
+
+// MatchPlatform returns true if platform is the given filter or one of its variants
+func MatchPlatform(platform, filter string) bool {
+	return platform == filter || strings.HasPrefix(platform, filter+"/")
+}
+
+// IndexImage is an Image modeling for indexation.
+// An IndexImage is a manifest of a repository, with all the tags pointing to it.
+// Tag and FullName are the first of these tags and are not indexed
 type IndexImage struct {
 	ID           string
+	Registry     string
 	Name         string
-	FullName     string
-	Tag          string
+	FullName     string `json:"-"`
+	Tag          string `json:"-"`
+	Tags         []string
 	Comment      string
 	Created      time.Time
 	Author       string
 	Label        map[string]string
 	Labels       []string
+	Annotation   map[string]string
+	Annotations  []string
 	Volumes      []string
 	ExposedPorts []int
 	Env          map[string]string
 	Envs         []string
+	Entrypoint   []string
+	Cmd          []string
+	User         string
+	WorkingDir   string
+	OS           string
+	Architecture string
+	Variant      string
+	Platform     string
+	ListDigest   string
+	Healthcheck  string
 	Size         int64
+	Layers       []string
+	LayerSizes   []int64
+	History      []string
+	// Parent is the digest of the closest indexed image this image is built on
+	Parent string
+	// BasedOn lists the repositories and full names of all the indexed images this image is built on
+	BasedOn []string
+}
+
+// HistoryEntry describes one step of the build of an image
+type HistoryEntry struct {
+	Created   time.Time
+	CreatedBy string
+	Comment   string
 }
 
 // Type implementation of bleve.Classifier interface
@@ -142,6 +351,39 @@ func (im IndexImage) Type() string {
 type IndexResults struct {
 	Total  uint64
 	Images []*IndexImage
+	Facets map[string]*Facet
+}
+
+// DriftReport describes the differences between the index and the registry corrected by a reconciliation
+type DriftReport struct {
+	// Started is the time the reconciliation started
+	Started time.Time `json:"started"`
+	// Duration is the time the reconciliation took
+	Duration time.Duration `json:"duration"`
+	// FullBuild is true when the index was empty and fully built instead
+	FullBuild bool `json:"full_build,omitempty"`
+	// Added lists the tags missing from the index
+	Added []string `json:"added,omitempty"`
+	// Updated lists the tags that pointed to another image in the index
+	Updated []string `json:"updated,omitempty"`
+	// Removed lists the tags that no longer exist on the registry
+	Removed []string `json:"removed,omitempty"`
+	// FailedRepositories lists the repositories whose tags could not be read
+	FailedRepositories []string `json:"failed_repositories,omitempty"`
+	// Error is set when the corrections could not be written to the index
+	Error string `json:"error,omitempty"`
+}
+
+// Drifted returns true if the reconciliation found differences between the index and the registry
+func (r *DriftReport) Drifted() bool {
+	return len(r.Added)+len(r.Updated)+len(r.Removed) > 0
+}
+
+// Sort sorts all the lists of the report
+func (r *DriftReport) Sort() {
+	for _, l := range [][]string{r.Added, r.Updated, r.Removed, r.FailedRepositories} {
+		sort.Strings(l)
+	}
 }
 
 // ActionType indicates the kind of a NotificationJob
@@ -155,12 +397,28 @@ const PushAction ActionType = "push"
 
 // NotificationJob stores info to reindex an image after a push or deletion
 type NotificationJob struct {
+	// EventID is the ID of the registry event that triggered the job, used to ignore duplicated notifications
+	EventID string
+	// Registry is the name of the registry the job applies to when the server indexes several registries
+	Registry   string
 	Action     ActionType
 	Repository string
 	Tag        string
 	Digest     digest.Digest
 }
 
+// DeadLetter is a notification that failed too many times to be applied to the index
+type DeadLetter struct {
+	// ID identifies the notification in the queue of the server
+	ID uint64 `json:"id"`
+	// Job is the failed notification
+	Job *NotificationJob `json:"job"`
+	// Attempts is the number of times the notification was processed
+	Attempts int `json:"attempts"`
+	// LastError is the error returned by the last attempt
+	LastError string `json:"last_error"`
+}
+
 // RegistryProxy forwards request to a docker registry if user is granted
 type RegistryProxy interface {
 	Forwards(w http.ResponseWriter, r *http.Request)
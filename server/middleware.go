@@ -50,6 +50,19 @@ func securityFilter(cfg *Config, hf http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// authenticatedFilter is a securityFilter that also rejects the requests whose URL is not restricted to some users by the configuration
+func authenticatedFilter(cfg *Config, hf http.HandlerFunc) http.HandlerFunc {
+	secured := securityFilter(cfg, hf)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if auth := GetAuthorization(r, cfg.Authorizations); auth == nil || len(auth.Users) == 0 {
+			logrus.WithField("url", r.URL).Infoln("Rejecting request to an URL that is not restricted to some users")
+			http.Error(w, fmt.Sprintf("Access to %s must be restricted to some users in the server configuration", r.URL.Path), http.StatusForbidden)
+			return
+		}
+		secured(w, r)
+	}
+}
+
 func grantAccess(req *http.Request, auth *Authorization) error {
 	if auth.Users != nil {
 		for _, user := range auth.Users {
@@ -93,3 +93,37 @@ func TestGetAuthorization(t *testing.T) {
 	}
 
 }
+
+func TestAuthenticatedFilter(t *testing.T) {
+	public := &Authorization{Path: "/public"}
+	secure := &Authorization{Path: "/secure", Users: []*Credentials{{Username: "private", Password: utils.Sha256("secure")}}}
+	for _, auth := range []*Authorization{public, secure} {
+		auth.CompilePath()
+	}
+	cfg := &Config{Authorizations: []*Authorization{public, secure}}
+	filter := authenticatedFilter(cfg, func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		givenURL                     string
+		givenUsername, givenPassword string
+		expected                     int
+	}{
+		{givenURL: "/unknown", expected: http.StatusForbidden},
+		{givenURL: "/public", expected: http.StatusForbidden},
+		{givenURL: "/secure", expected: http.StatusUnauthorized},
+		{givenURL: "/secure", givenUsername: "private", givenPassword: "wrong", expected: http.StatusUnauthorized},
+		{givenURL: "/secure", givenUsername: "private", givenPassword: "secure", expected: http.StatusOK},
+	}
+
+	for i, test := range tests {
+		r := httptest.NewRequest(http.MethodPost, test.givenURL, nil)
+		if test.givenUsername != "" {
+			r.SetBasicAuth(test.givenUsername, test.givenPassword)
+		}
+		w := httptest.NewRecorder()
+		filter(w, r)
+		if w.Code != test.expected {
+			t.Errorf("Test #%d authenticatedFilter returned %d but it was expected %d", i, w.Code, test.expected)
+		}
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"context"
@@ -28,6 +29,8 @@ import (
 	"github.com/mailgun/manners"
 	"github.com/nhurel/dim/lib"
 	"github.com/nhurel/dim/lib/environment"
+	"github.com/nhurel/dim/lib/index"
+	"github.com/nhurel/dim/lib/registry"
 )
 
 // Server type handle  indexation of a docker registry and serves the search endpoint
@@ -42,7 +45,16 @@ func NewServer(cfg *Config, index dim.RegistryIndex, ctx context.Context, proxy
 
 	http.HandleFunc("/v1/search", securityFilter(cfg, handler(index, Search)))
 	http.HandleFunc("/dim/notify", securityFilter(cfg, handler(index, NotifyImageChange)))
+	http.HandleFunc("/dim/notify/", securityFilter(cfg, handler(index, NotifyImageChange)))
 	http.HandleFunc("/dim/version", securityFilter(cfg, buildVersionHandler(c)))
+	http.HandleFunc("/dim/index/drift", securityFilter(cfg, handler(index, Drift)))
+	http.HandleFunc("/dim/index/status", securityFilter(cfg, handler(index, Status)))
+	http.HandleFunc("/dim/index/backup", authenticatedFilter(cfg, handler(index, Backup)))
+	http.HandleFunc("/dim/index/export", authenticatedFilter(cfg, handler(index, Export)))
+	http.HandleFunc("/dim/index/deadletters", authenticatedFilter(cfg, handler(index, DeadLetters)))
+	http.HandleFunc("/dim/suggest", securityFilter(cfg, handler(index, Suggest)))
+	http.HandleFunc("/dim/searches", authenticatedFilter(cfg, handler(index, SavedSearches)))
+	http.HandleFunc("/dim/searches/", authenticatedFilter(cfg, handler(index, SavedSearches)))
 	http.HandleFunc("/", securityFilter(cfg, proxy.Forwards))
 	return &Server{manners.NewWithServer(&http.Server{Addr: cfg.Port, Handler: http.DefaultServeMux}), index}
 }
@@ -89,10 +101,204 @@ func Version(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// NotifyImageChange handles docker registry events
+// Drift returns the report of the last reconciliation of the index with the registry
+func Drift(i dim.RegistryIndex, w http.ResponseWriter, r *http.Request) {
+	report := i.Drift()
+	if report == nil {
+		http.Error(w, "No reconciliation ran yet", http.StatusNotFound)
+		return
+	}
+	if b, err := json.Marshal(report); err != nil {
+		http.Error(w, "Failed to serialize the response", http.StatusInternalServerError)
+		logrus.WithError(err).Errorln("Error occured while serializing drift report")
+	} else {
+		w.Write(b)
+	}
+}
+
+// Status returns the state of the index and the progress of the registry crawl
+func Status(i dim.RegistryIndex, w http.ResponseWriter, r *http.Request) {
+	if b, err := json.Marshal(i.Status()); err != nil {
+		http.Error(w, "Failed to serialize the response", http.StatusInternalServerError)
+		logrus.WithError(err).Errorln("Error occured while serializing index status")
+	} else {
+		w.Write(b)
+	}
+}
+
+// Backup streams a snapshot archive of the index
+func Backup(i dim.RegistryIndex, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", `attachment; filename="dim-index.tar.gz"`)
+	if err := i.Backup(w); err != nil {
+		// The archive may already be partially sent so the status cannot be changed anymore
+		logrus.WithError(err).Errorln("Failed to backup index")
+	}
+}
+
+// Export streams all the indexed images as JSON lines or CSV.
+// The format parameter selects the format and each f parameter adds a field to export
+func Export(i dim.RegistryIndex, w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		logrus.WithError(err).Errorln("Failed to parse query")
+		http.Error(w, "Failed to parse query", http.StatusBadRequest)
+		return
+	}
+	format, fields := r.Form.Get("format"), r.Form["f"]
+
+	if format == index.CSVFormat {
+		w.Header().Set("Content-Type", "text/csv")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	if err := i.Export(w, format, fields); err != nil {
+		// Invalid parameters are reported before anything is written
+		if _, ok := err.(*dim.QueryError); ok {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		logrus.WithError(err).Errorln("Failed to export index")
+	}
+}
+
+// Suggest returns the most frequent values of the field parameter starting with the prefix parameter, with their counts.
+// The size parameter limits the number of values returned
+func Suggest(i dim.RegistryIndex, w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		logrus.WithError(err).Errorln("Failed to parse query")
+		http.Error(w, "Failed to parse query", http.StatusBadRequest)
+		return
+	}
+	field, prefix := r.Form.Get("field"), r.Form.Get("prefix")
+	// Using default size if wrong param given
+	size, _ := strconv.Atoi(r.Form.Get("size"))
+
+	terms, err := i.Suggest(field, prefix, size)
+	if err != nil {
+		if _, ok := err.(*dim.QueryError); ok {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "An error occured while procesing your request", http.StatusInternalServerError)
+		logrus.WithError(err).WithField("field", field).Errorln("Error occured while suggesting terms")
+		return
+	}
+	if terms == nil {
+		terms = []dim.FacetTerm{}
+	}
+
+	var b []byte
+	if b, err = json.Marshal(terms); err != nil {
+		http.Error(w, "Failed to serialize the response", http.StatusInternalServerError)
+		logrus.WithError(err).Errorln("Error occured while serializing suggestions")
+		return
+	}
+	w.Write(b)
+}
+
+// SavedSearches lists the saved searches and their recent matches on GET, creates or replaces a saved search on POST
+// and deletes the saved search named in the path (/dim/searches/NAME) on DELETE
+func SavedSearches(i dim.RegistryIndex, w http.ResponseWriter, r *http.Request) {
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		var b []byte
+		if b, err = json.Marshal(i.SavedSearches()); err != nil {
+			http.Error(w, "Failed to serialize the response", http.StatusInternalServerError)
+			logrus.WithError(err).Errorln("Error occured while serializing saved searches")
+			return
+		}
+		w.Write(b)
+		return
+	case http.MethodPost:
+		defer r.Body.Close()
+		search := &dim.SavedSearch{}
+		if err = json.NewDecoder(r.Body).Decode(search); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to parse saved search : %v", err), http.StatusBadRequest)
+			return
+		}
+		if err = i.SaveSearch(search); err == nil {
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+	case http.MethodDelete:
+		name := strings.TrimPrefix(r.URL.Path, "/dim/searches/")
+		if name == "" || name == r.URL.Path {
+			http.Error(w, "No saved search name given", http.StatusBadRequest)
+			return
+		}
+		if err = i.DeleteSearch(name); err == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if _, ok := err.(*dim.QueryError); ok {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Error(w, "An error occured while procesing your request", http.StatusInternalServerError)
+	logrus.WithError(err).Errorln("Error occured while updating saved searches")
+}
+
+// DeadLetters lists the notifications that failed too many times with GET, submits them again with POST and removes them with DELETE.
+// Each id parameter selects a dead letter. All of them are selected when no id is given
+func DeadLetters(i dim.RegistryIndex, w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse query", http.StatusBadRequest)
+		return
+	}
+	ids := make([]uint64, 0, len(r.Form["id"]))
+	for _, v := range r.Form["id"] {
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid dead letter id %s", v), http.StatusBadRequest)
+			return
+		}
+		ids = append(ids, id)
+	}
+
+	var response interface{}
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		response, err = i.DeadLetters()
+	case http.MethodPost:
+		var n int
+		n, err = i.RetryDeadLetters(ids)
+		response = map[string]int{"count": n}
+	case http.MethodDelete:
+		var n int
+		n, err = i.PurgeDeadLetters(ids)
+		response = map[string]int{"count": n}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		http.Error(w, "An error occured while procesing your request", http.StatusInternalServerError)
+		logrus.WithError(err).Errorln("Error occured while handling dead letters")
+		return
+	}
+
+	var b []byte
+	if b, err = json.Marshal(response); err != nil {
+		http.Error(w, "Failed to serialize the response", http.StatusInternalServerError)
+		logrus.WithError(err).Errorln("Error occured while serializing dead letters")
+		return
+	}
+	w.Write(b)
+}
+
+// NotifyImageChange handles docker registry events.
+// When several registries are indexed, each one sends its events to /dim/notify/NAME
 func NotifyImageChange(i dim.RegistryIndex, w http.ResponseWriter, r *http.Request) {
+	registryName := strings.Trim(strings.TrimPrefix(r.URL.Path, "/dim/notify"), "/")
 
-	logrus.Debugln("Receiving event from registry")
+	logrus.WithField("registry", registryName).Debugln("Receiving event from registry")
 	defer r.Body.Close()
 
 	enveloppe := &notifications.Envelope{}
@@ -113,12 +319,13 @@ func NotifyImageChange(i dim.RegistryIndex, w http.ResponseWriter, r *http.Reque
 		switch event.Action {
 		case notifications.EventActionDelete:
 			logrus.WithField("enveloppe", enveloppe).Infoln("Processing delete event")
-			i.Submit(&dim.NotificationJob{Action: dim.DeleteAction, Digest: event.Target.Digest})
+			i.Submit(&dim.NotificationJob{EventID: event.ID, Registry: registryName, Action: dim.DeleteAction, Repository: event.Target.Repository, Tag: event.Target.Tag, Digest: event.Target.Digest})
 		case notifications.EventActionPush:
-			if event.Target.MediaType == schema2.MediaTypeManifest {
+			switch event.Target.MediaType {
+			case schema2.MediaTypeManifest, registry.MediaTypeManifestList, registry.MediaTypeOCIManifest, registry.MediaTypeOCIIndex, registry.MediaTypeSignedManifest, registry.MediaTypeManifestV1:
 				logrus.WithField("enveloppe", enveloppe).Infoln("Processing push event")
-				i.Submit(&dim.NotificationJob{Action: dim.PushAction, Repository: event.Target.Repository, Tag: event.Target.Tag, Digest: event.Target.Digest})
-			} else {
+				i.Submit(&dim.NotificationJob{EventID: event.ID, Registry: registryName, Action: dim.PushAction, Repository: event.Target.Repository, Tag: event.Target.Tag, Digest: event.Target.Digest})
+			default:
 				logrus.WithField("mediatype", event.Target.MediaType).WithField("Event", event).Debugln("Event safely ignored because mediatype is unknown")
 			}
 		default:
@@ -137,7 +344,8 @@ func Search(i dim.RegistryIndex, w http.ResponseWriter, r *http.Request) {
 		logrus.WithError(err).Errorln("Failed to parse query")
 		http.Error(w, "Failed to parse query", http.StatusBadRequest)
 	}
-	q, a, fields := r.Form.Get("q"), r.Form.Get("a"), r.Form["f"]
+	q, a, platform := r.Form.Get("q"), r.Form.Get("a"), r.Form.Get("platform")
+	fields, facets, sort := r.Form["f"], r.Form["facet"], r.Form["sort"]
 
 	// No error handling here. Using defaults if wrong params given
 	offset, _ := strconv.Atoi(r.FormValue("offset"))
@@ -152,15 +360,19 @@ func Search(i dim.RegistryIndex, w http.ResponseWriter, r *http.Request) {
 	}
 
 	var sr *dim.IndexResults
-	l := logrus.WithFields(logrus.Fields{"query": q, "advanced_query": a, "fields": fields})
+	l := logrus.WithFields(logrus.Fields{"query": q, "advanced_query": a, "platform": platform, "fields": fields, "facets": facets, "sort": sort})
 	l.Debugln("Searching image")
-	if sr, err = i.SearchImages(q, a, fields, offset, maxResults); err != nil {
+	if sr, err = i.SearchImages(q, a, platform, fields, facets, sort, offset, maxResults); err != nil {
+		if _, ok := err.(*dim.QueryError); ok {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		http.Error(w, "An error occured while procesing your request", http.StatusInternalServerError)
 		l.WithError(err).Errorln("Error occured when processing search")
 		return
 	}
 
-	results := dim.SearchResults{NumResults: int(sr.Total), Query: q}
+	results := dim.SearchResults{NumResults: int(sr.Total), Query: q, Facets: sr.Facets}
 	l.WithField("#results", results.NumResults).Debugln("Found results")
 
 	results.Results = buildResults(sr)
@@ -184,16 +396,39 @@ func buildResults(sr *dim.IndexResults) []dim.SearchResult {
 func imageToSearchResult(i *dim.IndexImage) dim.SearchResult {
 	logrus.WithField("image", i).Debugln("Entering imageToSearchResult")
 	result := dim.SearchResult{
+		Registry:     i.Registry,
 		Name:         i.Name,
 		Description:  i.Tag,
 		Tag:          i.Tag,
 		FullName:     i.FullName,
+		Tags:         i.Tags,
 		Created:      i.Created,
 		Label:        i.Label,
+		Annotation:   i.Annotation,
 		Volumes:      i.Volumes,
 		ExposedPorts: i.ExposedPorts,
 		Env:          i.Env,
+		Entrypoint:   i.Entrypoint,
+		Cmd:          i.Cmd,
+		User:         i.User,
+		WorkingDir:   i.WorkingDir,
+		OS:           i.OS,
+		Architecture: i.Architecture,
+		Variant:      i.Variant,
+		Platform:     i.Platform,
+		Healthcheck:  i.Healthcheck,
 		Size:         i.Size,
+		ID:           i.ID,
+		Parent:       i.Parent,
+		BasedOn:      i.BasedOn,
+	}
+
+	for n, layer := range i.Layers {
+		l := dim.Layer{Digest: layer}
+		if n < len(i.LayerSizes) {
+			l.Size = i.LayerSizes[n]
+		}
+		result.Layers = append(result.Layers, l)
 	}
 
 	return result
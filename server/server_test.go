@@ -14,6 +14,7 @@
 package server_test
 
 import (
+	"reflect"
 	"testing"
 
 	"net/http"
@@ -30,7 +31,6 @@ import (
 	"time"
 
 	"github.com/Sirupsen/logrus"
-	"github.com/blevesearch/bleve"
 	"github.com/docker/distribution"
 	"github.com/docker/distribution/digest"
 	"github.com/docker/distribution/manifest/schema2"
@@ -117,18 +117,14 @@ var (
 
 func TestSearch(t *testing.T) {
 
-	var i bleve.Index
-	var err error
-	if i, err = indextest.MockIndex(index.ImageMapping); err != nil {
+	ind, err := index.New(&index.Config{Backend: index.MemoryBackend}, nil)
+	if err != nil {
 		t.Fatalf("Failed to create index : %v", err)
 	}
+	defer ind.Close()
 
-	ind := &index.Index{Index: i}
-
-	for _, image := range images {
-		if err := ind.Index.Index(image.FullName, image); err != nil {
-			logrus.WithError(err).Errorln("Failed to index image")
-		}
+	for i := range images {
+		ind.IndexImage(&images[i])
 	}
 
 	for _, image := range images {
@@ -187,6 +183,22 @@ func TestSearch(t *testing.T) {
 	if response.Result().StatusCode != http.StatusBadRequest {
 		t.Errorf("Search returned status %s instead of %d when called with no search param", response.Result().Status, http.StatusBadRequest)
 	}
+
+	request = httptest.NewRequest(http.MethodGet, "/v1/search?q=*&facet=Name&facet=Label.family", nil)
+	response = httptest.NewRecorder()
+	server.Search(ind, response, request)
+	sr := &dim.SearchResults{}
+	json.NewDecoder(response.Body).Decode(sr)
+	if len(sr.Facets) != 2 || sr.Facets["Name"] == nil || sr.Facets["Name"].Total != 4 {
+		t.Errorf("Search returned facets %v instead of Name and Label.family facets", sr.Facets)
+	}
+
+	request = httptest.NewRequest(http.MethodGet, "/v1/search?q=*&facet=Name:0", nil)
+	response = httptest.NewRecorder()
+	server.Search(ind, response, request)
+	if response.Result().StatusCode != http.StatusBadRequest {
+		t.Errorf("Search returned status %s instead of %d when called with an invalid facet", response.Result().Status, http.StatusBadRequest)
+	}
 }
 
 func TestNotifyImageChange(t *testing.T) {
@@ -198,6 +210,7 @@ func TestNotifyImageChange(t *testing.T) {
 		Action: notifications.EventActionDelete,
 	}
 	deleteEvent.Target.Descriptor = distribution.Descriptor{Digest: deleteEventDigest}
+	deleteEvent.Target.Repository = "delete"
 
 	deleteEventMessage, err := json.Marshal(&notifications.Envelope{Events: []notifications.Event{deleteEvent}})
 
@@ -212,6 +225,8 @@ func TestNotifyImageChange(t *testing.T) {
 
 	if ind.Calls["Submit"] == nil || ind.Calls["Submit"][0].(*dim.NotificationJob).Action != dim.DeleteAction {
 		t.Errorf("NotifyImageChange(deleteEvent) did not submit a job with action %s. Called with %v", dim.DeleteAction, ind.Calls["Submit"][0])
+	} else if j := ind.Calls["Submit"][0].(*dim.NotificationJob); j.Repository != "delete" || j.Digest != deleteEventDigest {
+		t.Errorf("NotifyImageChange(deleteEvent) submitted %v instead of a job for repository delete and digest %s", j, deleteEventDigest)
 	}
 
 	pushEventDigest := digest.NewDigestFromBytes(digest.Canonical, []byte("push"))
@@ -249,6 +264,14 @@ func TestNotifyImageChange(t *testing.T) {
 		}
 	}
 
+	response = httptest.NewRecorder()
+	request = httptest.NewRequest(http.MethodGet, "/dim/notify/prod", bytes.NewBuffer(pushEventMessage))
+
+	server.NotifyImageChange(ind, response, request)
+	if j := ind.Calls["Submit"][0].(*dim.NotificationJob); j.Registry != "prod" {
+		t.Errorf("NotifyImageChange(pushEvent) on /dim/notify/prod submitted a job for registry %s instead of prod", j.Registry)
+	}
+
 	badMessage := ""
 	response = httptest.NewRecorder()
 	request = httptest.NewRequest(http.MethodGet, "/dim/notify", bytes.NewBufferString(badMessage))
@@ -306,3 +329,123 @@ func TestVersion(t *testing.T) {
 	}
 
 }
+
+func TestDrift(t *testing.T) {
+	ind := &mock.NoOpRegistryIndex{Calls: make(map[string][]interface{})}
+
+	w := httptest.NewRecorder()
+	server.Drift(ind, w, httptest.NewRequest(http.MethodGet, "/dim/index/drift", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("/dim/index/drift returned %d instead of %d before any reconciliation", w.Code, http.StatusNotFound)
+	}
+
+	ind.DriftReport = &dim.DriftReport{Added: []string{"httpd:2.4"}, Removed: []string{"mysql:5.5"}}
+	w = httptest.NewRecorder()
+	server.Drift(ind, w, httptest.NewRequest(http.MethodGet, "/dim/index/drift", nil))
+	got := &dim.DriftReport{}
+	if err := json.Unmarshal(w.Body.Bytes(), got); err != nil {
+		t.Fatalf("Failed to parse response : %v", err)
+	}
+	if !reflect.DeepEqual(got.Added, ind.DriftReport.Added) || !reflect.DeepEqual(got.Removed, ind.DriftReport.Removed) {
+		t.Errorf("/dim/index/drift returned %v instead of %v", got, ind.DriftReport)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	ind := &mock.NoOpRegistryIndex{Calls: make(map[string][]interface{}), IndexStatus: &dim.IndexStatus{State: dim.IndexBuilding, Repositories: 3, Tags: 12, TagsCrawled: 5}}
+
+	w := httptest.NewRecorder()
+	server.Status(ind, w, httptest.NewRequest(http.MethodGet, "/dim/index/status", nil))
+	got := &dim.IndexStatus{}
+	if err := json.Unmarshal(w.Body.Bytes(), got); err != nil {
+		t.Fatalf("Failed to parse response : %v", err)
+	}
+	if !reflect.DeepEqual(got, ind.IndexStatus) {
+		t.Errorf("/dim/index/status returned %v instead of %v", got, ind.IndexStatus)
+	}
+}
+
+func TestSavedSearches(t *testing.T) {
+	ind := &mock.NoOpRegistryIndex{Calls: make(map[string][]interface{}), Searches: []*dim.SavedSearch{{Name: "java7", Query: "env:JAVA_VERSION~1.7*", Configured: true}}}
+
+	w := httptest.NewRecorder()
+	server.SavedSearches(ind, w, httptest.NewRequest(http.MethodGet, "/dim/searches", nil))
+	got := make([]*dim.SavedSearch, 0, 1)
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("Failed to parse response : %v", err)
+	}
+	if !reflect.DeepEqual(got, ind.Searches) {
+		t.Errorf("/dim/searches returned %v instead of %v", got, ind.Searches)
+	}
+
+	w = httptest.NewRecorder()
+	server.SavedSearches(ind, w, httptest.NewRequest(http.MethodPost, "/dim/searches", bytes.NewBufferString(`{"name": "big", "query": "size>1GB"}`)))
+	if w.Code != http.StatusCreated {
+		t.Errorf("POST /dim/searches returned %d instead of %d", w.Code, http.StatusCreated)
+	}
+	if saved := ind.Calls["SaveSearch"]; len(saved) != 1 || !reflect.DeepEqual(saved[0], &dim.SavedSearch{Name: "big", Query: "size>1GB"}) {
+		t.Errorf("POST /dim/searches saved %v", saved)
+	}
+
+	w = httptest.NewRecorder()
+	server.SavedSearches(ind, w, httptest.NewRequest(http.MethodDelete, "/dim/searches/big", nil))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("DELETE /dim/searches/big returned %d instead of %d", w.Code, http.StatusNoContent)
+	}
+	if deleted := ind.Calls["DeleteSearch"]; len(deleted) != 1 || deleted[0] != "big" {
+		t.Errorf("DELETE /dim/searches/big deleted %v", deleted)
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	ind := &mock.NoOpRegistryIndex{Calls: make(map[string][]interface{}), Suggestions: []dim.FacetTerm{{Term: "org.opencontainers.image.source", Count: 4}}}
+
+	w := httptest.NewRecorder()
+	server.Suggest(ind, w, httptest.NewRequest(http.MethodGet, "/dim/suggest?field=Labels&prefix=or&size=5", nil))
+	if args := ind.Calls["Suggest"]; !reflect.DeepEqual(args, []interface{}{"Labels", "or", 5}) {
+		t.Errorf("/dim/suggest called Suggest with %v", args)
+	}
+	got := make([]dim.FacetTerm, 0, 1)
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("Failed to parse response : %v", err)
+	}
+	if !reflect.DeepEqual(got, ind.Suggestions) {
+		t.Errorf("/dim/suggest returned %v instead of %v", got, ind.Suggestions)
+	}
+}
+
+func TestDeadLetters(t *testing.T) {
+	dead := []*dim.DeadLetter{{ID: 12, Job: &dim.NotificationJob{Action: dim.PushAction, Repository: "mysql", Tag: "5.5"}, Attempts: 10, LastError: "registry unavailable"}}
+	ind := &mock.NoOpRegistryIndex{Calls: make(map[string][]interface{}), Dead: dead}
+
+	w := httptest.NewRecorder()
+	server.DeadLetters(ind, w, httptest.NewRequest(http.MethodGet, "/dim/index/deadletters", nil))
+	got := make([]*dim.DeadLetter, 0, 1)
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("Failed to parse response : %v", err)
+	}
+	if !reflect.DeepEqual(got, dead) {
+		t.Errorf("/dim/index/deadletters returned %v instead of %v", got, dead)
+	}
+
+	w = httptest.NewRecorder()
+	server.DeadLetters(ind, w, httptest.NewRequest(http.MethodPost, "/dim/index/deadletters?id=12&id=15", nil))
+	if args := ind.Calls["RetryDeadLetters"]; !reflect.DeepEqual(args, []interface{}{[]uint64{12, 15}}) {
+		t.Errorf("POST /dim/index/deadletters called RetryDeadLetters with %v", args)
+	}
+	if w.Body.String() != `{"count":1}` {
+		t.Errorf("POST /dim/index/deadletters returned %s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	server.DeadLetters(ind, w, httptest.NewRequest(http.MethodDelete, "/dim/index/deadletters", nil))
+	if args := ind.Calls["PurgeDeadLetters"]; !reflect.DeepEqual(args, []interface{}{[]uint64{}}) {
+		t.Errorf("DELETE /dim/index/deadletters called PurgeDeadLetters with %v", args)
+	}
+
+	w = httptest.NewRecorder()
+	server.DeadLetters(ind, w, httptest.NewRequest(http.MethodDelete, "/dim/index/deadletters?id=first", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("/dim/index/deadletters returned %d instead of %d for an invalid id", w.Code, http.StatusBadRequest)
+	}
+}
@@ -41,6 +41,7 @@ type Docker interface {
 	ImageBuild(parent string, buildLabels map[string]string, tag string) error
 	Pull(image string) error
 	Inspect(image string) (types.ImageInspect, error)
+	History(image string) ([]types.ImageHistory, error)
 	Remove(image string) error
 	Push(image string) error
 }
@@ -251,6 +252,18 @@ func (dc *DockerClient) Inspect(image string) (types.ImageInspect, error) {
 	return resp, err
 }
 
+// History returns the build history of an image, from the most recent step to the oldest one
+func (dc *DockerClient) History(image string) ([]types.ImageHistory, error) {
+	var c *client.Client
+	var err error
+	if c, err = dc.Client(); err != nil {
+		logrus.WithError(err).Fatalln("Error occured while connecting to docker daemon")
+		return nil, err
+	}
+
+	return c.ImageHistory(context.Background(), image)
+}
+
 // Remove removes an image locally
 func (dc *DockerClient) Remove(image string) error {
 	logrus.WithField("image", image).Debugln("Entering Remove")